@@ -0,0 +1,419 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+
+	"github.com/reconquest/karma-go"
+	"github.com/reconquest/pkg/log"
+	"github.com/reconquest/snake-runner/internal/cache"
+	"github.com/reconquest/snake-runner/internal/config"
+	"github.com/reconquest/snake-runner/internal/console"
+	"github.com/reconquest/snake-runner/internal/pipeline"
+	"github.com/reconquest/snake-runner/internal/responses"
+	"github.com/reconquest/snake-runner/internal/runner"
+	"github.com/reconquest/snake-runner/internal/set"
+	conditions "github.com/reconquest/snake-runner/internal/signal"
+	"github.com/reconquest/snake-runner/internal/snake"
+	"github.com/reconquest/snake-runner/internal/sshkey"
+	"github.com/reconquest/snake-runner/internal/status"
+	"github.com/reconquest/snake-runner/internal/tasks"
+)
+
+const (
+	// LOCAL_PIPELINE_ID is used as ID of pipelines that are started by the
+	// exec command, such pipelines are never reported to the master.
+	LOCAL_PIPELINE_ID = 1
+
+	// LOCAL_REPOSITORY_DIR is the path inside of the sidecar container where
+	// the local repository is mounted to be cloned from.
+	LOCAL_REPOSITORY_DIR = "/snake-runner-local-repository"
+)
+
+type ExecOptions struct {
+	Dir          string
+	Filename     string
+	PipelinesDir string
+	ArtifactsDir string
+	Jobs         []string
+}
+
+// LocalPipeline runs a pipeline from the local git repository without
+// Bitbucket, logs of jobs are printed to stdout.
+type LocalPipeline struct {
+	config *runner.Config
+	opts   ExecOptions
+
+	// toplevel is the root of the local git repository
+	toplevel string
+}
+
+func runExec(opts ExecOptions) error {
+	config, err := runner.LoadLocalConfig(*configPath)
+	if err != nil {
+		return karma.Format(err, "unable to load configuration")
+	}
+
+	if config.Log.Debug {
+		log.SetLevel(log.LevelDebug)
+	}
+
+	if config.Log.Trace {
+		log.SetLevel(log.LevelTrace)
+	}
+
+	if opts.PipelinesDir != "" {
+		config.PipelinesDir, err = filepath.Abs(opts.PipelinesDir)
+		if err != nil {
+			return karma.Format(
+				err,
+				"unable to get absolute path of %q", opts.PipelinesDir,
+			)
+		}
+	}
+
+	return (&LocalPipeline{config: config, opts: opts}).run()
+}
+
+func (local *LocalPipeline) run() error {
+	var err error
+
+	// the local repository is cloned by the sidecar which is running on a
+	// remote build host in the ssh mode
+	if local.config.Mode == runner.RUNNER_MODE_SSH {
+		return errors.New(
+			"the ssh mode is not supported by exec, " +
+				"the local repository is not reachable from build hosts",
+		)
+	}
+
+	local.toplevel, err = local.git("rev-parse", "--show-toplevel")
+	if err != nil {
+		return karma.Format(
+			err,
+			"unable to find git repository in %s", local.opts.Dir,
+		)
+	}
+
+	task, err := local.getTask()
+	if err != nil {
+		return err
+	}
+
+	executor, err := NewProbeFactory(local.config).Probe()
+	if err != nil {
+		return err
+	}
+
+	log.Infof(nil, "generating ssh key for the pipeline")
+
+	sshKey, err := sshkey.Generate(sshkey.DEFAULT_BLOCK_SIZE)
+	if err != nil {
+		return karma.Format(err, "unable to generate ssh key")
+	}
+
+	parentCtx, parentCancel := context.WithCancel(context.Background())
+	defer parentCancel()
+
+	ctx, cancel := context.WithCancel(parentCtx)
+	defer cancel()
+
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(interrupts)
+
+	go func() {
+		select {
+		case signal := <-interrupts:
+			log.Warningf(nil, "got signal: %s, canceling pipeline", signal)
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	master := console.NewMaster(os.Stdout, task.Jobs, local.opts.ArtifactsDir)
+
+	process := pipeline.NewProcess(
+		parentCtx,
+		ctx,
+		master,
+		local.config,
+		task,
+		executor,
+		cache.NewFromConfig(local.config),
+		log.NewChildWithPrefix(fmt.Sprintf("[pipeline:%d]", task.Pipeline.ID)),
+		*sshKey,
+		conditions.NewCondition(),
+	)
+
+	runErr := process.Run()
+
+	fmt.Fprintln(os.Stdout)
+	for _, job := range task.Jobs {
+		fmt.Fprintf(
+			os.Stdout,
+			":: %-9s %s (stage: %s)\n",
+			master.Status(job.ID), job.Name, job.Stage,
+		)
+	}
+
+	if runErr != nil {
+		return karma.Format(runErr, "pipeline failed")
+	}
+
+	return nil
+}
+
+func (local *LocalPipeline) getTask() (tasks.PipelineRun, error) {
+	var task tasks.PipelineRun
+
+	commit, err := local.git("rev-parse", "HEAD")
+	if err != nil {
+		return task, karma.Format(err, "unable to get current commit")
+	}
+
+	ref, err := local.git("rev-parse", "--abbrev-ref", "HEAD")
+	if err != nil {
+		return task, karma.Format(err, "unable to get current branch")
+	}
+
+	filename, err := local.getFilename()
+	if err != nil {
+		return task, err
+	}
+
+	// the pipeline is read from the HEAD because the sidecar clones the
+	// repository, so uncommitted changes will not be visible to jobs anyway
+	contents, err := local.git("show", "HEAD:"+filepath.ToSlash(filename))
+	if err != nil {
+		return task, karma.Format(
+			err,
+			"unable to read %q at commit %s", filename, commit,
+		)
+	}
+
+	var problems config.Problems
+
+	document, err := config.NewDocument([]byte(contents))
+	if err != nil {
+		problems = config.Lint([]byte(contents))
+	} else {
+		err = document.Include(
+			filepath.ToSlash(filename),
+			func(source config.IncludeSource) ([]byte, error) {
+				return local.readInclude(commit, source)
+			},
+		)
+		if err != nil {
+			return task, karma.Format(
+				err,
+				"unable to include files into %q",
+				filename,
+			)
+		}
+
+		problems = config.LintDocument(document)
+	}
+
+	if len(problems) > 0 {
+		return task, problems.Reason(
+			fmt.Sprintf("pipeline file %q is not valid", filename),
+		)
+	}
+
+	pipelineConfig, err := config.UnmarshalDocument(document)
+	if err != nil {
+		return task, karma.Format(
+			err,
+			"unable to unmarshal yaml data: %q",
+			filename,
+		)
+	}
+
+	jobs, err := local.getJobs(pipelineConfig, commit)
+	if err != nil {
+		return task, err
+	}
+
+	slug := filepath.Base(local.toplevel)
+
+	task.Pipeline = snake.Pipeline{
+		ID:           LOCAL_PIPELINE_ID,
+		Commit:       commit,
+		Filename:     filepath.ToSlash(filename),
+		RefType:      "BRANCH",
+		RefDisplayId: ref,
+	}
+	task.Jobs = jobs
+	task.Env = map[string]string{}
+	task.Project = responses.Project{Key: "local", Name: "local"}
+	task.Repository = responses.Repository{Slug: slug, Name: slug}
+
+	switch local.config.Mode {
+	case runner.RUNNER_MODE_DOCKER, runner.RUNNER_MODE_PODMAN:
+		local.config.Sidecar.Docker.Volumes = append(
+			local.config.Sidecar.Docker.Volumes,
+			local.toplevel+":"+LOCAL_REPOSITORY_DIR+":ro",
+		)
+
+		task.CloneURL.SSH = LOCAL_REPOSITORY_DIR
+	default:
+		task.CloneURL.SSH = local.toplevel
+	}
+
+	return task, nil
+}
+
+func (local *LocalPipeline) getFilename() (string, error) {
+	dir, err := filepath.Abs(local.opts.Dir)
+	if err != nil {
+		return "", karma.Format(
+			err,
+			"unable to get absolute path of %q", local.opts.Dir,
+		)
+	}
+
+	path := local.opts.Filename
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(dir, path)
+	}
+
+	toplevel, err := filepath.EvalSymlinks(local.toplevel)
+	if err != nil {
+		return "", karma.Format(err, "unable to resolve %q", local.toplevel)
+	}
+
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		path = resolved
+	}
+
+	filename, err := filepath.Rel(toplevel, path)
+	if err != nil || strings.HasPrefix(filename, "..") {
+		return "", karma.
+			Describe("repository", local.toplevel).
+			Format(err, "pipeline file %q is outside of repository", path)
+	}
+
+	return filename, nil
+}
+
+// readInclude reads the included file at the given commit, there is no
+// Bitbucket instance to fetch other repositories from.
+func (local *LocalPipeline) readInclude(
+	commit string,
+	source config.IncludeSource,
+) ([]byte, error) {
+	if source.Project != "" {
+		return nil, errors.New(
+			"files of other repositories can't be included into local pipelines",
+		)
+	}
+
+	contents, err := local.git("show", commit+":"+source.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	return []byte(contents), nil
+}
+
+// getJobs lists jobs ordered by stages, jobs within the same stage are ordered
+// by name. If specific jobs are requested then the jobs they need are listed
+// too. A matrix or parallel job is requested by its name or by names of the
+// jobs it's expanded into.
+func (local *LocalPipeline) getJobs(
+	pipelineConfig config.Pipeline,
+	commit string,
+) ([]snake.PipelineJob, error) {
+	requested := []string{}
+	for _, name := range local.opts.Jobs {
+		if expanded, ok := pipelineConfig.Expanded[name]; ok {
+			requested = append(requested, expanded...)
+			continue
+		}
+
+		if _, ok := pipelineConfig.Jobs[name]; !ok {
+			return nil, fmt.Errorf("no such job in pipeline: %q", name)
+		}
+
+		requested = append(requested, name)
+	}
+
+	only := set.NewStringSet(requested...)
+
+	// jobs needed by the selected jobs have to be run as well
+	queue := append([]string{}, requested...)
+	for len(queue) > 0 {
+		name := queue[0]
+		queue = queue[1:]
+
+		for _, need := range pipelineConfig.Jobs[name].Needs {
+			if !only.Has(need) {
+				only.Put(need)
+				queue = append(queue, need)
+			}
+		}
+	}
+
+	jobs := []snake.PipelineJob{}
+	for _, stage := range pipelineConfig.Stages {
+		names := []string{}
+		for name, job := range pipelineConfig.Jobs {
+			if job.Stage != stage {
+				continue
+			}
+
+			if len(local.opts.Jobs) > 0 && !only.Has(name) {
+				continue
+			}
+
+			names = append(names, name)
+		}
+
+		sort.Strings(names)
+
+		for _, name := range names {
+			jobs = append(jobs, snake.PipelineJob{
+				ID:         len(jobs) + 1,
+				PipelineID: LOCAL_PIPELINE_ID,
+				Commit:     commit,
+				Stage:      stage,
+				Name:       name,
+				Status:     string(status.PENDING),
+			})
+		}
+	}
+
+	if len(jobs) == 0 {
+		return nil, errors.New("no jobs to run in pipeline")
+	}
+
+	return jobs, nil
+}
+
+func (local *LocalPipeline) git(args ...string) (string, error) {
+	var stdout, stderr bytes.Buffer
+
+	cmd := exec.Command("git", append([]string{"-C", local.opts.Dir}, args...)...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err := cmd.Run()
+	if err != nil {
+		return "", karma.
+			Describe("cmd", cmd.Args).
+			Describe("stderr", strings.TrimSpace(stderr.String())).
+			Reason(err)
+	}
+
+	return strings.TrimSpace(stdout.String()), nil
+}
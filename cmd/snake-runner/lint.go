@@ -0,0 +1,75 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/reconquest/karma-go"
+	"github.com/reconquest/snake-runner/internal/config"
+)
+
+func runLint(files []string) error {
+	total := 0
+	for _, file := range files {
+		contents, err := ioutil.ReadFile(file)
+		if err != nil {
+			return karma.Format(err, "unable to read file: %s", file)
+		}
+
+		problems, err := lintFile(file, contents)
+		if err != nil {
+			return err
+		}
+
+		for _, problem := range problems {
+			// problems of included files mention these files
+			if problem.File != "" {
+				problem.File = filepath.Join(filepath.Dir(file), problem.File)
+				fmt.Fprintf(os.Stdout, "%s\n", problem)
+				continue
+			}
+
+			fmt.Fprintf(os.Stdout, "%s:%s\n", file, problem)
+		}
+
+		total += len(problems)
+	}
+
+	if total > 0 {
+		return fmt.Errorf("found %d problem(s) in pipeline files", total)
+	}
+
+	return nil
+}
+
+// lintFile lints the pipeline file with all files it includes, the pipeline
+// file is expected to be in the root of the repository.
+func lintFile(file string, contents []byte) (config.Problems, error) {
+	document, err := config.NewDocument(contents)
+	if err != nil {
+		return config.Lint(contents), nil
+	}
+
+	err = document.Include(
+		filepath.Base(file),
+		func(source config.IncludeSource) ([]byte, error) {
+			if source.Project != "" {
+				return nil, errors.New(
+					"files of other repositories can't be included by lint",
+				)
+			}
+
+			return ioutil.ReadFile(
+				filepath.Join(filepath.Dir(file), filepath.FromSlash(source.Path)),
+			)
+		},
+	)
+	if err != nil {
+		return nil, karma.Format(err, "unable to include files into %s", file)
+	}
+
+	return config.LintDocument(document), nil
+}
@@ -85,6 +85,45 @@ func main() {
 		},
 	)
 
+	execCmd := app.Command(
+		"exec",
+		"Run a pipeline from the local git repository without Bitbucket",
+	)
+	var execOpts ExecOptions
+	execCmd.Flag("dir", "Path to the local git repository").
+		Short('d').
+		Default(".").
+		StringVar(&execOpts.Dir)
+	execCmd.Flag("file", "Pipeline file relative to the repository").
+		Short('f').
+		Default(".snake-ci.yml").
+		StringVar(&execOpts.Filename)
+	execCmd.Flag("pipelines-dir", "Override pipelines_dir of the configuration").
+		StringVar(&execOpts.PipelinesDir)
+	execCmd.Flag("artifacts-dir", "Save artifacts of jobs to the directory").
+		StringVar(&execOpts.ArtifactsDir)
+	execCmd.Arg("job", "Run only the specified jobs").
+		StringsVar(&execOpts.Jobs)
+
+	actions.register(
+		execCmd,
+		func() error {
+			return runExec(execOpts)
+		},
+	)
+
+	lintCmd := app.Command("lint", "Validate pipeline files")
+	lintFiles := lintCmd.Arg("file", "Pipeline files to validate").
+		Default(".snake-ci.yml").
+		Strings()
+
+	actions.register(
+		lintCmd,
+		func() error {
+			return runLint(*lintFiles)
+		},
+	)
+
 	err := actions.dispatch(app)
 	if err != nil {
 		log.Fatal(err)
@@ -119,7 +158,7 @@ func run(serviceShutdown chan struct{}) error {
 	snake := NewSnake(config)
 	snake.Start()
 
-	interrupts := make(chan os.Signal)
+	interrupts := make(chan os.Signal, 1)
 	signal.Notify(interrupts, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
 
 	select {
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"github.com/reconquest/karma-go"
+	"github.com/reconquest/snake-runner/internal/executor/podman"
+)
+
+//go:generate gonstructor --type=PodmanProbe
+type PodmanProbe struct {
+	podman *podman.Podman
+	host   string
+}
+
+func (probe *PodmanProbe) Probe() (*podman.Podman, error) {
+	err := probe.podman.Connect()
+	if err != nil {
+		return nil, karma.
+			Describe("host", probe.host).
+			Format(
+				err,
+				"Unable to connect to Podman. Is the podman.socket service running?\n"+
+					"Rootless Podman can be started with: "+
+					"systemctl --user enable --now podman.socket",
+			)
+	}
+
+	return probe.podman, nil
+}
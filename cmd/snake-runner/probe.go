@@ -6,7 +6,9 @@ import (
 	"github.com/reconquest/pkg/log"
 	"github.com/reconquest/snake-runner/internal/executor"
 	"github.com/reconquest/snake-runner/internal/executor/docker"
+	"github.com/reconquest/snake-runner/internal/executor/podman"
 	"github.com/reconquest/snake-runner/internal/executor/shell"
+	"github.com/reconquest/snake-runner/internal/executor/ssh"
 	"github.com/reconquest/snake-runner/internal/runner"
 )
 
@@ -27,10 +29,39 @@ func (factory *ProbeFactory) Probe() (executor.Executor, error) {
 			),
 		).Probe()
 
+	case runner.RUNNER_MODE_PODMAN:
+		log.Debugf(nil, "initializing podman provider")
+
+		return NewPodmanProbe(
+			podman.NewPodman(
+				factory.config.Podman.Host,
+				factory.config.Docker.Network,
+				factory.config.Docker.Volumes,
+			),
+			factory.config.Podman.Host,
+		).Probe()
+
 	case runner.RUNNER_MODE_SHELL:
 		log.Debugf(nil, "initializing shell provider")
 
-		return NewShellProbe(shell.NewShell()).Probe()
+		shellExecutor := shell.NewShell()
+		shellExecutor.SetEnv(factory.config.GetPassthroughEnv())
+
+		return NewShellProbe(shellExecutor, factory.config.Shell.User).Probe()
+
+	case runner.RUNNER_MODE_SSH:
+		log.Debugf(nil, "initializing ssh provider")
+
+		return NewSshProbe(
+			ssh.NewSsh(ssh.Options{
+				Name:           factory.config.Name,
+				Hosts:          factory.config.Ssh.Hosts,
+				User:           factory.config.Ssh.User,
+				IdentityFile:   factory.config.Ssh.IdentityFile,
+				KnownHostsFile: factory.config.Ssh.KnownHostsFile,
+				WorkspaceDir:   factory.config.Ssh.WorkspaceDir,
+			}),
+		).Probe()
 
 	default:
 		return nil, fmt.Errorf(
@@ -11,10 +11,13 @@ import (
 	"github.com/reconquest/pkg/log"
 	"github.com/reconquest/snake-runner/internal/api"
 	"github.com/reconquest/snake-runner/internal/audit"
+	"github.com/reconquest/snake-runner/internal/cache"
 	"github.com/reconquest/snake-runner/internal/executor"
+	"github.com/reconquest/snake-runner/internal/executor/docker"
 	"github.com/reconquest/snake-runner/internal/pipeline"
 	"github.com/reconquest/snake-runner/internal/runner"
 	"github.com/reconquest/snake-runner/internal/safemap"
+	"github.com/reconquest/snake-runner/internal/sidecar"
 	"github.com/reconquest/snake-runner/internal/signal"
 	"github.com/reconquest/snake-runner/internal/sshkey"
 	"github.com/reconquest/snake-runner/internal/tasks"
@@ -24,6 +27,7 @@ import (
 type Scheduler struct {
 	client         *api.Client
 	executor       executor.Executor
+	cache          *cache.Cache
 	pipelinesMap   safemap.IntToAny
 	pipelines      int64
 	pipelinesGroup sync.WaitGroup
@@ -53,6 +57,7 @@ func (snake *Snake) startScheduler() error {
 	scheduler := &Scheduler{
 		client:       snake.client,
 		executor:     executor,
+		cache:        cache.NewFromConfig(snake.config),
 		runnerConfig: snake.config,
 		sshKeyFactory: sshkey.NewFactory(
 			ctx,
@@ -76,10 +81,52 @@ func (snake *Snake) startScheduler() error {
 	snake.scheduler = scheduler
 
 	snake.scheduler.start()
+	snake.scheduler.startImageGC()
 
 	return nil
 }
 
+// imageCollector is implemented by the docker and podman executors.
+type imageCollector interface {
+	RunImageGC(context.Context, docker.ImageGCOptions)
+}
+
+// startImageGC starts removing images which are not used by the runner, it's
+// done only by the docker and podman executors.
+func (scheduler *Scheduler) startImageGC() {
+	config := scheduler.runnerConfig.Docker.GC
+	if !config.Enabled {
+		return
+	}
+
+	executor, ok := scheduler.executor.(imageCollector)
+	if !ok {
+		return
+	}
+
+	opts := docker.ImageGCOptions{
+		Interval:      config.Interval,
+		MaxUnused:     config.MaxUnused,
+		DiskThreshold: config.DiskThreshold,
+		Keep:          append([]string{sidecar.CLOUD_SIDECAR_IMAGE}, config.Keep...),
+		StatePath:     scheduler.runnerConfig.GetImageUsagePath(),
+	}
+
+	log.Infof(
+		nil,
+		"image gc started: max_unused=%v disk_threshold=%d%% keep=%v",
+		opts.MaxUnused, opts.DiskThreshold, opts.Keep,
+	)
+
+	scheduler.routines.Add(1)
+	go func() {
+		defer audit.Go("scheduler", "image gc")()
+		defer scheduler.routines.Done()
+
+		executor.RunImageGC(scheduler.context, opts)
+	}()
+}
+
 func (scheduler *Scheduler) start() {
 	scheduler.routines.Add(2)
 	go func() {
@@ -236,6 +283,7 @@ func (scheduler *Scheduler) startPipeline(
 		scheduler.runnerConfig,
 		task,
 		scheduler.executor,
+		scheduler.cache,
 		log.NewChildWithPrefix(fmt.Sprintf("[pipeline:%d]", task.Pipeline.ID)),
 		sshKey,
 		signal.NewCondition(),
@@ -16,9 +16,17 @@ import (
 //go:generate gonstructor --type=ShellProbe
 type ShellProbe struct {
 	shell *shell.Shell
+	user  string
 }
 
 func (probe *ShellProbe) Probe() (executor.Executor, error) {
+	if probe.user != "" {
+		err := probe.checkUser()
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	log.Debugf(nil, "checking shell executor prerequisites")
 
 	err := sidecar.NewShellSidecarBuilder().
@@ -101,3 +109,32 @@ func (probe *ShellProbe) Probe() (executor.Executor, error) {
 
 	return probe.shell, nil
 }
+
+// checkUser checks that the runner is able to run commands as the user.
+func (probe *ShellProbe) checkUser() error {
+	err := probe.shell.SetUser(probe.user)
+	if err != nil {
+		return err
+	}
+
+	container, err := probe.shell.Create(context.Background(), executor.CreateOptions{})
+	if err != nil {
+		return err
+	}
+
+	defer probe.shell.Destroy(context.Background(), container)
+
+	err = probe.shell.Exec(context.Background(), container, executor.ExecOptions{
+		Cmd: []string{"true"},
+	})
+	if err != nil {
+		return karma.Format(
+			err,
+			"Unable to run commands as user %q, "+
+				"the runner must be started by root to switch users",
+			probe.user,
+		)
+	}
+
+	return nil
+}
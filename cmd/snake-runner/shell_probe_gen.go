@@ -4,8 +4,9 @@ package main
 
 import "github.com/reconquest/snake-runner/internal/executor/shell"
 
-func NewShellProbe(shell *shell.Shell) *ShellProbe {
+func NewShellProbe(shell *shell.Shell, user string) *ShellProbe {
 	return &ShellProbe{
 		shell: shell,
+		user:  user,
 	}
 }
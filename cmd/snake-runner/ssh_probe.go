@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+
+	"github.com/reconquest/karma-go"
+	"github.com/reconquest/snake-runner/internal/executor/ssh"
+	"github.com/reconquest/snake-runner/internal/sidecar"
+)
+
+//go:generate gonstructor --type=SshProbe
+type SshProbe struct {
+	ssh *ssh.Ssh
+}
+
+func (probe *SshProbe) Probe() (*ssh.Ssh, error) {
+	err := probe.ssh.Connect()
+	if err != nil {
+		return nil, karma.Format(
+			err,
+			"Unable to connect to build hosts. "+
+				"Make sure that the identity file is authorized on the hosts "+
+				"and keys of the hosts are in the known hosts file",
+		)
+	}
+
+	err = sidecar.NewSshSidecarBuilder().
+		Executor(probe.ssh).
+		Build().
+		CheckPrerequisites(context.Background())
+	if err != nil {
+		return nil, karma.Format(
+			err,
+			"Prerequisites check failed while running snake-runner with SNAKE_EXEC_MODE=ssh;"+
+				" make sure that the specified binaries are installed on build hosts",
+		)
+	}
+
+	return probe.ssh, nil
+}
@@ -1,7 +1,9 @@
 package api
 
 import (
+	"io"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
 	"time"
@@ -10,6 +12,7 @@ import (
 	"github.com/reconquest/snake-runner/internal/requests"
 	"github.com/reconquest/snake-runner/internal/responses"
 	"github.com/reconquest/snake-runner/internal/runner"
+	"github.com/reconquest/snake-runner/internal/snake"
 	"github.com/reconquest/snake-runner/internal/sshkey"
 	"github.com/reconquest/snake-runner/internal/status"
 	"github.com/reconquest/snake-runner/internal/tasks"
@@ -155,3 +158,55 @@ func (client *Client) PushLogs(pipelineID, jobID int, text string) error {
 		}).
 		Do()
 }
+
+func (client *Client) ExpandJob(
+	pipelineID int,
+	jobID int,
+	names []string,
+) ([]snake.PipelineJob, error) {
+	var response []snake.PipelineJob
+	err := client.request().
+		POST().
+		Path(
+			"/gate/pipelines/" + strconv.Itoa(pipelineID) +
+				"/jobs/" + strconv.Itoa(jobID) +
+				"/expand",
+		).
+		Payload(&requests.JobExpand{
+			Names: names,
+		}).
+		Response(&response).
+		Do()
+	if err != nil {
+		return nil, err
+	}
+
+	return response, nil
+}
+
+// UploadArtifacts streams a gzipped tar archive of job artifacts to the
+// master, artifacts are removed by the master after expireIn if it's not zero.
+func (client *Client) UploadArtifacts(
+	pipelineID int,
+	jobID int,
+	expireIn time.Duration,
+	archive io.Reader,
+) error {
+	query := url.Values{}
+	if expireIn > 0 {
+		query.Set("expire_in", strconv.FormatInt(int64(expireIn.Seconds()), 10))
+	}
+
+	path := "/gate/pipelines/" + strconv.Itoa(pipelineID) +
+		"/jobs/" + strconv.Itoa(jobID) +
+		"/artifacts"
+	if len(query) > 0 {
+		path += "?" + query.Encode()
+	}
+
+	return client.request().
+		POST().
+		Path(path).
+		Body(archive, "application/gzip").
+		Do()
+}
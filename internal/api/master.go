@@ -0,0 +1,51 @@
+package api
+
+import (
+	"io"
+	"time"
+
+	"github.com/reconquest/snake-runner/internal/snake"
+	"github.com/reconquest/snake-runner/internal/status"
+)
+
+// Master is the part of the master API which is used by pipelines and jobs
+// to report their progress. It's implemented by Client, but it also can be
+// replaced with a local implementation when pipelines are running without
+// Bitbucket.
+type Master interface {
+	UpdatePipeline(
+		id int,
+		status status.Status,
+		startedAt *time.Time,
+		finishedAt *time.Time,
+	) error
+
+	UpdateJob(
+		pipelineID int,
+		jobID int,
+		status status.Status,
+		startedAt *time.Time,
+		finishedAt *time.Time,
+	) error
+
+	PushLogs(pipelineID, jobID int, text string) error
+
+	// ExpandJob reports that the job is expanded by matrix or parallel into
+	// jobs with the given names. The master renames the job to the first
+	// name and creates jobs for the rest of names, all of them are returned
+	// in the same order.
+	ExpandJob(
+		pipelineID int,
+		jobID int,
+		names []string,
+	) ([]snake.PipelineJob, error)
+
+	UploadArtifacts(
+		pipelineID int,
+		jobID int,
+		expireIn time.Duration,
+		archive io.Reader,
+	) error
+}
+
+var _ Master = (*Client)(nil)
@@ -3,6 +3,7 @@ package api
 import (
 	"bytes"
 	"encoding/json"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"strings"
@@ -27,6 +28,9 @@ type Request struct {
 	hasPayload bool
 	payload    interface{}
 
+	body        io.Reader
+	contentType string
+
 	expectedStatuses []int
 	dstResponse      interface{}
 
@@ -77,6 +81,14 @@ func (request *Request) Payload(body interface{}) *Request {
 	return request
 }
 
+// Body sets a raw request body which is streamed as is instead of a JSON
+// encoded payload.
+func (request *Request) Body(body io.Reader, contentType string) *Request {
+	request.body = body
+	request.contentType = contentType
+	return request
+}
+
 func (request *Request) Response(response interface{}) *Request {
 	request.dstResponse = response
 	return request
@@ -128,6 +140,10 @@ func (request *Request) Do() error {
 		)
 
 		httpRequest, err = http.NewRequest(request.method, url, buffer)
+	} else if request.body != nil {
+		request.headers["Content-Type"] = request.contentType
+
+		httpRequest, err = http.NewRequest(request.method, url, request.body)
 	} else {
 		httpRequest, err = http.NewRequest(request.method, url, nil)
 	}
@@ -0,0 +1,127 @@
+package artifacts
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/reconquest/snake-runner/internal/snake"
+)
+
+// Store keeps artifacts archives and dotenv variables of finished jobs of a
+// pipeline, so they can be passed to the jobs which depend on them. Archives
+// are stored in a temporary directory on the runner host.
+type Store struct {
+	mutex sync.Mutex
+	dir   string
+	jobs  map[int]*Job
+
+	restoring sync.Mutex
+}
+
+type Job struct {
+	Name    string
+	Archive string
+	Dotenv  map[string]string
+}
+
+func NewStore() *Store {
+	return &Store{
+		jobs: map[int]*Job{},
+	}
+}
+
+// Create creates a file for the archive of the given job, the archive is
+// available to other jobs only after SetArchive is called.
+func (store *Store) Create(id int) (*os.File, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if store.dir == "" {
+		dir, err := ioutil.TempDir("", "snake-runner-artifacts.*")
+		if err != nil {
+			return nil, err
+		}
+
+		store.dir = dir
+	}
+
+	return os.Create(filepath.Join(store.dir, fmt.Sprintf("%d.tar.gz", id)))
+}
+
+func (store *Store) SetArchive(job snake.PipelineJob, path string) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	store.get(job).Archive = path
+}
+
+func (store *Store) SetDotenv(job snake.PipelineJob, vars map[string]string) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	store.get(job).Dotenv = vars
+}
+
+func (store *Store) get(job snake.PipelineJob) *Job {
+	stored, ok := store.jobs[job.ID]
+	if !ok {
+		stored = &Job{Name: job.Name}
+		store.jobs[job.ID] = stored
+	}
+
+	return stored
+}
+
+// List returns stored artifacts of the given jobs in the given order, jobs
+// without artifacts are omitted.
+func (store *Store) List(ids []int) []Job {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	jobs := []Job{}
+	for _, id := range ids {
+		stored, ok := store.jobs[id]
+		if ok {
+			jobs = append(jobs, *stored)
+		}
+	}
+
+	return jobs
+}
+
+// Dotenv merges dotenv variables of the given jobs, variables of latter jobs
+// override variables of former ones.
+func (store *Store) Dotenv(ids []int) map[string]string {
+	vars := map[string]string{}
+	for _, job := range store.List(ids) {
+		for key, value := range job.Dotenv {
+			vars[key] = value
+		}
+	}
+
+	return vars
+}
+
+// LockRestore must be held while artifacts are extracted, jobs of a pipeline
+// share the workspace, so archives of parallel jobs are extracted one by one
+// instead of overwriting files of each other halfway.
+func (store *Store) LockRestore() (unlock func()) {
+	store.restoring.Lock()
+
+	return store.restoring.Unlock
+}
+
+// Destroy removes all stored archives.
+func (store *Store) Destroy() error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if store.dir == "" {
+		return nil
+	}
+
+	return os.RemoveAll(store.dir)
+}
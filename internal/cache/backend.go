@@ -0,0 +1,20 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"io"
+)
+
+var ErrNotFound = errors.New("cache not found")
+
+// Backend stores cache objects by names, an object is visible to readers only
+// after it's completely written.
+type Backend interface {
+	// Get returns ErrNotFound if there is no object with the given name.
+	Get(ctx context.Context, name string) (io.ReadCloser, error)
+
+	// Put writes the object of the given size, an existing object with the
+	// same name is replaced.
+	Put(ctx context.Context, name string, reader io.ReadSeeker, size int64) error
+}
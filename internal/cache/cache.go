@@ -0,0 +1,183 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/reconquest/karma-go"
+	"github.com/reconquest/snake-runner/internal/runner"
+)
+
+const (
+	ARCHIVE_SUFFIX = ".tar.gz"
+
+	keysDir  = "keys"
+	blobsDir = "blobs"
+
+	// maxNameLength limits the readable part of key names, the hash of the
+	// key keeps names unique
+	maxNameLength = 64
+)
+
+var (
+	unsafeChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+	blobName    = regexp.MustCompile(`^[0-9a-f]{64}$`)
+)
+
+// Cache stores cache archives in the backend. Archives are content-addressed:
+// an archive is stored as a blob named by the hash of its contents and the
+// key only points to the blob. The pointer is written after the blob is
+// uploaded, so a partially uploaded archive is never restored.
+type Cache struct {
+	backend Backend
+
+	mutex sync.Mutex
+	locks map[string]*sync.Mutex
+}
+
+func NewCache(backend Backend) *Cache {
+	return &Cache{
+		backend: backend,
+		locks:   map[string]*sync.Mutex{},
+	}
+}
+
+// Open opens the archive of the given key, ErrNotFound is returned if there
+// is no such archive.
+func (cache *Cache) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	pointer, err := cache.backend.Get(ctx, getKeyName(key))
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := ioutil.ReadAll(io.LimitReader(pointer, 1024))
+	pointer.Close()
+	if err != nil {
+		return nil, karma.Format(err, "unable to read cache key")
+	}
+
+	hash := strings.TrimSpace(string(data))
+	if !blobName.MatchString(hash) {
+		return nil, karma.Describe("key", key).Format(nil, "invalid cache key contents")
+	}
+
+	// the blob can be removed by the backend while the pointer is still
+	// there, it's the same as if there is no cache at all
+	return cache.backend.Get(ctx, getBlobName(hash))
+}
+
+// Save stores the archive produced by the write function with the given key.
+// Concurrent saves of the same key are serialized, the latest one wins.
+func (cache *Cache) Save(
+	ctx context.Context,
+	key string,
+	write func(io.Writer) error,
+) (int64, error) {
+	lock := cache.getLock(key)
+	lock.Lock()
+	defer lock.Unlock()
+
+	file, err := ioutil.TempFile("", "snake-runner-cache.*")
+	if err != nil {
+		return 0, karma.Format(err, "unable to create temporary file")
+	}
+
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	hasher := sha256.New()
+
+	err = write(io.MultiWriter(file, hasher))
+	if err != nil {
+		return 0, err
+	}
+
+	size, err := file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = file.Seek(0, io.SeekStart)
+	if err != nil {
+		return 0, err
+	}
+
+	hash := hex.EncodeToString(hasher.Sum(nil))
+
+	err = cache.backend.Put(ctx, getBlobName(hash), file, size)
+	if err != nil {
+		return 0, karma.Format(err, "unable to store cache archive")
+	}
+
+	err = cache.backend.Put(
+		ctx,
+		getKeyName(key),
+		bytes.NewReader([]byte(hash)),
+		int64(len(hash)),
+	)
+	if err != nil {
+		return 0, karma.Format(err, "unable to store cache key")
+	}
+
+	return size, nil
+}
+
+func (cache *Cache) getLock(key string) *sync.Mutex {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	lock, ok := cache.locks[key]
+	if !ok {
+		lock = &sync.Mutex{}
+		cache.locks[key] = lock
+	}
+
+	return lock
+}
+
+// getKeyName returns name of the pointer of the given key, the name is made
+// of the key with unsafe characters replaced and the hash of the key.
+func getKeyName(key string) string {
+	hash := sha256.Sum256([]byte(key))
+
+	name := unsafeChars.ReplaceAllString(key, "_")
+	if len(name) > maxNameLength {
+		name = name[:maxNameLength]
+	}
+
+	return keysDir + "/" + name + "-" + hex.EncodeToString(hash[:])[:16]
+}
+
+func getBlobName(hash string) string {
+	return blobsDir + "/" + hash + ARCHIVE_SUFFIX
+}
+
+// NewFromConfig creates the cache with the backend specified in the runner
+// config.
+func NewFromConfig(config *runner.Config) *Cache {
+	switch config.Cache.Backend {
+	case runner.CACHE_BACKEND_S3:
+		return NewCache(
+			NewS3(
+				config.Cache.S3.Endpoint,
+				config.Cache.S3.Bucket,
+				config.Cache.S3.Prefix,
+				config.Cache.S3.Region,
+				config.Cache.S3.AccessKey,
+				config.Cache.S3.SecretKey,
+			),
+		)
+	default:
+		return NewCache(
+			NewFilesystem(config.GetCacheDir(), config.Cache.MaxSize),
+		)
+	}
+}
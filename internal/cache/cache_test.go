@@ -0,0 +1,130 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCache_SaveOpen(t *testing.T) {
+	test := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "snake-runner-test.*")
+	test.NoError(err)
+	defer os.RemoveAll(dir)
+
+	cache := NewCache(NewFilesystem(dir, 0))
+
+	_, err = cache.Open(context.Background(), "proj/repo/go")
+	test.Equal(ErrNotFound, err)
+
+	size, err := cache.Save(
+		context.Background(),
+		"proj/repo/go",
+		func(writer io.Writer) error {
+			_, err := io.WriteString(writer, "archive")
+			return err
+		},
+	)
+	test.NoError(err)
+	test.EqualValues(7, size)
+
+	test.Equal("archive", readCache(t, cache, "proj/repo/go"))
+
+	_, err = cache.Open(context.Background(), "proj/repo_go")
+	test.Equal(ErrNotFound, err)
+}
+
+func TestCache_Save_KeepsPreviousOnError(t *testing.T) {
+	test := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "snake-runner-test.*")
+	test.NoError(err)
+	defer os.RemoveAll(dir)
+
+	cache := NewCache(NewFilesystem(dir, 0))
+
+	_, err = cache.Save(
+		context.Background(),
+		"key",
+		func(writer io.Writer) error {
+			_, err := io.WriteString(writer, "first")
+			return err
+		},
+	)
+	test.NoError(err)
+
+	_, err = cache.Save(
+		context.Background(),
+		"key",
+		func(writer io.Writer) error {
+			io.WriteString(writer, "partial")
+			return errors.New("broken")
+		},
+	)
+	test.EqualError(err, "broken")
+
+	test.Equal("first", readCache(t, cache, "key"))
+}
+
+type failingBackend struct {
+	Backend
+	fail string
+}
+
+func (backend *failingBackend) Put(
+	ctx context.Context,
+	name string,
+	reader io.ReadSeeker,
+	size int64,
+) error {
+	if strings.HasPrefix(name, backend.fail) {
+		return errors.New("upload failed")
+	}
+
+	return backend.Backend.Put(ctx, name, reader, size)
+}
+
+func TestCache_Save_KeyIsWrittenAfterBlob(t *testing.T) {
+	test := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "snake-runner-test.*")
+	test.NoError(err)
+	defer os.RemoveAll(dir)
+
+	cache := NewCache(
+		&failingBackend{Backend: NewFilesystem(dir, 0), fail: blobsDir},
+	)
+
+	_, err = cache.Save(
+		context.Background(),
+		"key",
+		func(writer io.Writer) error {
+			_, err := io.WriteString(writer, "archive")
+			return err
+		},
+	)
+	test.Error(err)
+
+	_, err = cache.Open(context.Background(), "key")
+	test.Equal(ErrNotFound, err)
+}
+
+func readCache(t *testing.T, cache *Cache, key string) string {
+	reader, err := cache.Open(context.Background(), key)
+	if !assert.NoError(t, err) {
+		return ""
+	}
+	defer reader.Close()
+
+	data, err := ioutil.ReadAll(reader)
+	assert.NoError(t, err)
+
+	return string(data)
+}
@@ -0,0 +1,181 @@
+package cache
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/reconquest/karma-go"
+	"github.com/reconquest/pkg/log"
+)
+
+const tempPrefix = ".tmp-"
+
+var _ Backend = (*Filesystem)(nil)
+
+// Filesystem stores objects in a local directory. Objects are written to
+// temporary files and renamed when they are complete. When the total size of
+// objects exceeds maxSize, the least recently used objects are removed, zero
+// maxSize means no limit.
+type Filesystem struct {
+	dir     string
+	maxSize int64
+
+	mutex sync.Mutex
+}
+
+func NewFilesystem(dir string, maxSize int64) *Filesystem {
+	return &Filesystem{
+		dir:     dir,
+		maxSize: maxSize,
+	}
+}
+
+func (filesystem *Filesystem) Get(
+	ctx context.Context,
+	name string,
+) (io.ReadCloser, error) {
+	path := filesystem.getPath(name)
+
+	file, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrNotFound
+		}
+
+		return nil, err
+	}
+
+	// modification time is used as access time for removing least recently
+	// used objects, atime is not reliable because of noatime mounts
+	now := time.Now()
+	err = os.Chtimes(path, now, now)
+	if err != nil {
+		log.Warningf(err, "unable to update cache access time: %s", path)
+	}
+
+	return file, nil
+}
+
+func (filesystem *Filesystem) Put(
+	ctx context.Context,
+	name string,
+	reader io.ReadSeeker,
+	size int64,
+) error {
+	path := filesystem.getPath(name)
+
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return karma.Format(err, "unable to create cache dir: %s", filepath.Dir(path))
+	}
+
+	file, err := ioutil.TempFile(filepath.Dir(path), tempPrefix+"*")
+	if err != nil {
+		return karma.Format(err, "unable to create temporary file")
+	}
+
+	defer os.Remove(file.Name())
+
+	_, err = io.Copy(file, reader)
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	err = file.Close()
+	if err != nil {
+		return err
+	}
+
+	err = os.Rename(file.Name(), path)
+	if err != nil {
+		return karma.Format(err, "unable to rename cache object")
+	}
+
+	err = filesystem.evict(path)
+	if err != nil {
+		log.Errorf(err, "unable to remove least recently used caches")
+	}
+
+	return nil
+}
+
+type fileEntry struct {
+	path string
+	info os.FileInfo
+}
+
+// evict removes least recently used objects until their total size fits into
+// maxSize, the object which has just been written is kept.
+func (filesystem *Filesystem) evict(keep string) error {
+	if filesystem.maxSize <= 0 {
+		return nil
+	}
+
+	filesystem.mutex.Lock()
+	defer filesystem.mutex.Unlock()
+
+	entries := []fileEntry{}
+	total := int64(0)
+
+	err := filepath.Walk(
+		filesystem.dir,
+		func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				if os.IsNotExist(err) {
+					return nil
+				}
+
+				return err
+			}
+
+			if !info.Mode().IsRegular() || strings.HasPrefix(info.Name(), tempPrefix) {
+				return nil
+			}
+
+			entries = append(entries, fileEntry{path: path, info: info})
+			total += info.Size()
+
+			return nil
+		},
+	)
+	if err != nil {
+		return err
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].info.ModTime().Before(entries[j].info.ModTime())
+	})
+
+	for _, entry := range entries {
+		if total <= filesystem.maxSize {
+			break
+		}
+
+		if entry.path == keep {
+			continue
+		}
+
+		log.Debugf(nil, "removing least recently used cache: %s", entry.path)
+
+		err := os.Remove(entry.path)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+
+		total -= entry.info.Size()
+	}
+
+	return nil
+}
+
+func (filesystem *Filesystem) getPath(name string) string {
+	return filepath.Join(filesystem.dir, filepath.FromSlash(name))
+}
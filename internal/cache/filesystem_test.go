@@ -0,0 +1,66 @@
+package cache
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFilesystem_Put_RemovesLeastRecentlyUsed(t *testing.T) {
+	test := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "snake-runner-test.*")
+	test.NoError(err)
+	defer os.RemoveAll(dir)
+
+	filesystem := NewFilesystem(dir, 10)
+
+	put := func(name string) {
+		err := filesystem.Put(
+			context.Background(),
+			name,
+			strings.NewReader("xxxx"),
+			4,
+		)
+		test.NoError(err)
+	}
+
+	has := func(name string) bool {
+		reader, err := filesystem.Get(context.Background(), name)
+		if err == ErrNotFound {
+			return false
+		}
+
+		test.NoError(err)
+		test.NoError(reader.Close())
+
+		return true
+	}
+
+	put("blobs/a")
+	put("blobs/b")
+
+	past := time.Now().Add(-time.Hour)
+	test.NoError(os.Chtimes(filesystem.getPath("blobs/a"), past, past))
+	test.NoError(
+		os.Chtimes(
+			filesystem.getPath("blobs/b"),
+			past.Add(time.Minute),
+			past.Add(time.Minute),
+		),
+	)
+
+	// a is used more recently than b after reading
+	test.True(has("blobs/a"))
+
+	put("keys/c")
+
+	test.False(has("blobs/b"))
+	test.True(has("blobs/a"))
+	test.True(has("keys/c"))
+}
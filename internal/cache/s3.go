@@ -0,0 +1,259 @@
+package cache
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/reconquest/karma-go"
+)
+
+const (
+	S3_DEFAULT_REGION = "us-east-1"
+
+	s3Algorithm = "AWS4-HMAC-SHA256"
+	s3Service   = "s3"
+
+	// s3ErrorBodyLimit limits how much of an error response is included in
+	// the error message
+	s3ErrorBodyLimit = 1024
+)
+
+var _ Backend = (*S3)(nil)
+
+// S3 stores objects in a bucket of an S3-compatible storage, such as AWS S3
+// or MinIO. Objects are addressed in the path style, so the endpoint is an
+// URL of the storage itself, e.g. http://localhost:9000. Requests are signed
+// with AWS Signature Version 4.
+type S3 struct {
+	endpoint  string
+	bucket    string
+	prefix    string
+	region    string
+	accessKey string
+	secretKey string
+	client    *http.Client
+}
+
+func NewS3(
+	endpoint string,
+	bucket string,
+	prefix string,
+	region string,
+	accessKey string,
+	secretKey string,
+) *S3 {
+	if region == "" {
+		region = S3_DEFAULT_REGION
+	}
+
+	return &S3{
+		endpoint:  strings.TrimRight(endpoint, "/"),
+		bucket:    bucket,
+		prefix:    strings.Trim(prefix, "/"),
+		region:    region,
+		accessKey: accessKey,
+		secretKey: secretKey,
+		client:    http.DefaultClient,
+	}
+}
+
+func (s3 *S3) Get(ctx context.Context, name string) (io.ReadCloser, error) {
+	request, err := http.NewRequestWithContext(
+		ctx, http.MethodGet, s3.getURL(name), nil,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := s3.do(request, emptyPayloadHash)
+	if err != nil {
+		return nil, err
+	}
+
+	switch response.StatusCode {
+	case http.StatusOK:
+		return response.Body, nil
+	case http.StatusNotFound:
+		response.Body.Close()
+		return nil, ErrNotFound
+	default:
+		defer response.Body.Close()
+		return nil, s3.getError(response, name)
+	}
+}
+
+func (s3 *S3) Put(
+	ctx context.Context,
+	name string,
+	reader io.ReadSeeker,
+	size int64,
+) error {
+	hash := sha256.New()
+
+	_, err := io.Copy(hash, reader)
+	if err != nil {
+		return err
+	}
+
+	_, err = reader.Seek(0, io.SeekStart)
+	if err != nil {
+		return err
+	}
+
+	request, err := http.NewRequestWithContext(
+		ctx, http.MethodPut, s3.getURL(name), ioutil.NopCloser(reader),
+	)
+	if err != nil {
+		return err
+	}
+
+	request.ContentLength = size
+
+	response, err := s3.do(request, hex.EncodeToString(hash.Sum(nil)))
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return s3.getError(response, name)
+	}
+
+	return nil
+}
+
+func (s3 *S3) do(request *http.Request, payloadHash string) (*http.Response, error) {
+	signV4(
+		request,
+		s3.accessKey, s3.secretKey,
+		s3.region, s3Service,
+		payloadHash,
+		time.Now(),
+	)
+
+	response, err := s3.client.Do(request)
+	if err != nil {
+		return nil, karma.Format(err, "s3 request failed")
+	}
+
+	return response, nil
+}
+
+func (s3 *S3) getURL(name string) string {
+	segments := []string{s3.bucket}
+	if s3.prefix != "" {
+		segments = append(segments, strings.Split(s3.prefix, "/")...)
+	}
+
+	segments = append(segments, strings.Split(name, "/")...)
+
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+
+	return s3.endpoint + "/" + strings.Join(segments, "/")
+}
+
+func (s3 *S3) getError(response *http.Response, name string) error {
+	body, _ := ioutil.ReadAll(io.LimitReader(response.Body, s3ErrorBodyLimit))
+
+	return karma.
+		Describe("object", name).
+		Describe("response", strings.TrimSpace(string(body))).
+		Format(nil, "unexpected s3 response status: %s", response.Status)
+}
+
+var emptyPayloadHash = hex.EncodeToString(sha256.New().Sum(nil))
+
+// signV4 adds AWS Signature Version 4 headers to the request, the host header
+// and all headers of the request are signed.
+func signV4(
+	request *http.Request,
+	accessKey, secretKey string,
+	region, service string,
+	payloadHash string,
+	now time.Time,
+) {
+	stamp := now.UTC().Format("20060102T150405Z")
+	date := stamp[:8]
+
+	request.Header.Set("X-Amz-Date", stamp)
+	request.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := map[string]string{
+		"host": request.URL.Host,
+	}
+	for key, values := range request.Header {
+		headers[strings.ToLower(key)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+
+	names := []string{}
+	for name := range headers {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+
+	signedHeaders := strings.Join(names, ";")
+
+	path := request.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+
+	canonicalRequest := strings.Join([]string{
+		request.Method,
+		path,
+		request.URL.Query().Encode(),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+
+	canonicalHash := sha256.Sum256([]byte(canonicalRequest))
+
+	stringToSign := strings.Join([]string{
+		s3Algorithm,
+		stamp,
+		scope,
+		hex.EncodeToString(canonicalHash[:]),
+	}, "\n")
+
+	key := []byte("AWS4" + secretKey)
+	for _, part := range []string{date, region, service, "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	request.Header.Set(
+		"Authorization",
+		fmt.Sprintf(
+			"%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+			s3Algorithm, accessKey, scope, signedHeaders, signature,
+		),
+	)
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -2,86 +2,640 @@ package config
 
 import (
 	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
 
 	"github.com/reconquest/karma-go"
+	"github.com/reconquest/snake-runner/internal/executor"
 	"github.com/reconquest/snake-runner/internal/mapslice"
 	"gopkg.in/yaml.v3"
 )
 
 type Pipeline struct {
-	Variables *mapslice.MapSlice `json:"variables" yaml:"variables"`
-	Shell     string             `json:"shell"     yaml:"shell"`
-	Image     string             `json:"image"     yaml:"image"`
-	Stages    []string           `json:"stages"    yaml:"stages"`
-	Jobs      map[string]Job     `json:"jobs"      yaml:"jobs"`
+	Variables      *mapslice.MapSlice `json:"variables"       yaml:"variables"`
+	Shell          string             `json:"shell"           yaml:"shell"`
+	Image          string             `json:"image"           yaml:"image"`
+	Stages         []string           `json:"stages"          yaml:"stages"`
+	Timeout        Duration           `json:"timeout"         yaml:"timeout"`
+	BeforeCommands []string           `json:"before_commands" yaml:"before_commands"`
+	AfterCommands  []string           `json:"after_commands"  yaml:"after_commands"`
+	Cache          *Cache             `json:"cache"           yaml:"cache"`
+	Include        []Include          `json:"include"         yaml:"include"`
+	Jobs           map[string]Job     `json:"jobs"            yaml:"jobs"`
+
+	// Expanded maps names of matrix and parallel jobs to names of the jobs
+	// they are expanded into
+	Expanded map[string][]string `json:"-" yaml:"-"`
 }
 
 type Job struct {
-	Variables *mapslice.MapSlice `json:"variables" yaml:"variables"`
-	Stage     string             `json:"stage"     yaml:"stage"`
-	Shell     string             `json:"shell"     yaml:"shell"`
+	Variables      *mapslice.MapSlice `json:"variables"       yaml:"variables"`
+	Stage          string             `json:"stage"           yaml:"stage"`
+	Shell          string             `json:"shell"           yaml:"shell"`
+	Image          string             `json:"image"           yaml:"image"`
+	PullPolicy     PullPolicy         `json:"pull_policy"     yaml:"pull_policy"`
+	Resources      *Resources         `json:"resources"       yaml:"resources"`
+	Privileged     bool               `json:"privileged"      yaml:"privileged"`
+	CapAdd         []string           `json:"cap_add"         yaml:"cap_add"`
+	Devices        []Device           `json:"devices"         yaml:"devices"`
+	BeforeCommands []string           `json:"before_commands" yaml:"before_commands"`
+	Commands       []string           `json:"commands"        yaml:"commands"`
+	AfterCommands  []string           `json:"after_commands"  yaml:"after_commands"`
+	Timeout        Duration           `json:"timeout"         yaml:"timeout"`
+	AllowFailure   bool               `json:"allow_failure"   yaml:"allow_failure"`
+	Retry          Retry              `json:"retry"           yaml:"retry"`
+	Needs          []string           `json:"needs"           yaml:"needs"`
+	Only           *Condition         `json:"only"            yaml:"only"`
+	Except         *Condition         `json:"except"          yaml:"except"`
+	Rules          []Rule             `json:"rules"           yaml:"rules"`
+	Services       []Service          `json:"services"        yaml:"services"`
+	Artifacts      *Artifacts         `json:"artifacts"       yaml:"artifacts"`
+	Cache          *Cache             `json:"cache"           yaml:"cache"`
+	Parallel       Parallel           `json:"parallel"        yaml:"parallel"`
+	Matrix         []MatrixEntry      `json:"matrix"          yaml:"matrix"`
+}
+
+// PullPolicy of the job overrides the pull policy of the runner for the job
+// image and images of its services.
+type PullPolicy string
+
+var _ yaml.Unmarshaler = (*PullPolicy)(nil)
+
+func (policy *PullPolicy) UnmarshalYAML(node *yaml.Node) error {
+	var value string
+	err := node.Decode(&value)
+	if err != nil {
+		return err
+	}
+
+	switch value {
+	case executor.PULL_POLICY_ALWAYS,
+		executor.PULL_POLICY_IF_NOT_PRESENT,
+		executor.PULL_POLICY_NEVER:
+	default:
+		return fmt.Errorf(
+			"unknown pull policy %q, must be one of: %s, %s, %s",
+			value,
+			executor.PULL_POLICY_ALWAYS,
+			executor.PULL_POLICY_IF_NOT_PRESENT,
+			executor.PULL_POLICY_NEVER,
+		)
+	}
+
+	*policy = PullPolicy(value)
+
+	return nil
+}
+
+// Resources are resource limits requested by the job, limits which are not
+// specified are taken from the runner and none of them can exceed maximums
+// of the runner.
+type Resources executor.ResourcesSpec
+
+var _ yaml.Unmarshaler = (*Resources)(nil)
+
+func (resources *Resources) UnmarshalYAML(node *yaml.Node) error {
+	type plain Resources
+
+	err := node.Decode((*plain)(resources))
+	if err != nil {
+		return err
+	}
+
+	_, err = resources.Parse()
+
+	return err
+}
+
+// Parse converts the requested limits to resources, only limits which are
+// specified are set.
+func (resources Resources) Parse() (executor.Resources, error) {
+	return executor.ResourcesSpec(resources).Parse()
+}
+
+// Device of the host requested by the job, the job must be allowed to
+// request privileges by the runner.
+type Device string
+
+var _ yaml.Unmarshaler = (*Device)(nil)
+
+func (device *Device) UnmarshalYAML(node *yaml.Node) error {
+	var value string
+	err := node.Decode(&value)
+	if err != nil {
+		return err
+	}
+
+	_, err = executor.ParseDevice(value)
+	if err != nil {
+		return err
+	}
+
+	*device = Device(value)
+
+	return nil
+}
+
+const (
+	ARTIFACTS_WHEN_ON_SUCCESS = "on_success"
+	ARTIFACTS_WHEN_ON_FAILURE = "on_failure"
+	ARTIFACTS_WHEN_ALWAYS     = "always"
+)
+
+// Artifacts are files of the job workspace which are uploaded to the master
+// after the job is finished. Paths are globs relative to the repository,
+// a matching directory is uploaded with all its contents. Artifacts are also
+// restored into the workspace of jobs which depend on the job. Jobs of a
+// pipeline share the workspace, so restored artifacts are visible to jobs
+// running at the same time as well.
+type Artifacts struct {
+	Paths    []string          `json:"paths"     yaml:"paths"`
+	When     string            `json:"when"      yaml:"when"`
+	ExpireIn Duration          `json:"expire_in" yaml:"expire_in"`
+	Reports  *ArtifactsReports `json:"reports"   yaml:"reports"`
+}
+
+// ArtifactsReports are files produced by the job which are interpreted by
+// the runner. Dotenv is a file with KEY=VALUE lines, its variables are
+// passed to jobs which depend on the job.
+type ArtifactsReports struct {
+	Dotenv string `json:"dotenv" yaml:"dotenv"`
+}
+
+var _ yaml.Unmarshaler = (*Artifacts)(nil)
+
+func (artifacts *Artifacts) UnmarshalYAML(node *yaml.Node) error {
+	type plain Artifacts
+
+	err := node.Decode((*plain)(artifacts))
+	if err != nil {
+		return err
+	}
+
+	if len(artifacts.Paths) == 0 &&
+		(artifacts.Reports == nil || artifacts.Reports.Dotenv == "") {
+		return errors.New("artifacts paths or reports are not specified")
+	}
+
+	switch artifacts.When {
+	case "":
+		artifacts.When = ARTIFACTS_WHEN_ON_SUCCESS
+	case ARTIFACTS_WHEN_ON_SUCCESS, ARTIFACTS_WHEN_ON_FAILURE, ARTIFACTS_WHEN_ALWAYS:
+	default:
+		return fmt.Errorf(
+			"invalid artifacts when %q, expected one of: %s, %s, %s",
+			artifacts.When,
+			ARTIFACTS_WHEN_ON_SUCCESS,
+			ARTIFACTS_WHEN_ON_FAILURE,
+			ARTIFACTS_WHEN_ALWAYS,
+		)
+	}
+
+	return nil
+}
+
+const (
+	CACHE_POLICY_PULL      = "pull"
+	CACHE_POLICY_PUSH      = "push"
+	CACHE_POLICY_PULL_PUSH = "pull-push"
+
+	CACHE_KEY_DEFAULT = "default"
+)
+
+// Cache is a set of workspace paths which is saved by the runner after a
+// successful job and restored before jobs of subsequent pipelines with the
+// same key. Paths are globs relative to the repository.
+type Cache struct {
+	Key    CacheKey `json:"key"    yaml:"key"`
+	Paths  []string `json:"paths"  yaml:"paths"`
+	Policy string   `json:"policy" yaml:"policy"`
+}
+
+var _ yaml.Unmarshaler = (*Cache)(nil)
+
+func (cache *Cache) UnmarshalYAML(node *yaml.Node) error {
+	type plain Cache
+
+	err := node.Decode((*plain)(cache))
+	if err != nil {
+		return err
+	}
+
+	if len(cache.Paths) == 0 {
+		return errors.New("cache paths are not specified")
+	}
+
+	switch cache.Policy {
+	case "":
+		cache.Policy = CACHE_POLICY_PULL_PUSH
+	case CACHE_POLICY_PULL, CACHE_POLICY_PUSH, CACHE_POLICY_PULL_PUSH:
+	default:
+		return fmt.Errorf(
+			"invalid cache policy %q, expected one of: %s, %s, %s",
+			cache.Policy,
+			CACHE_POLICY_PULL,
+			CACHE_POLICY_PUSH,
+			CACHE_POLICY_PULL_PUSH,
+		)
+	}
+
+	return nil
+}
+
+// CacheKey identifies the cache, the key is the prefix followed by a hash of
+// contents of the files, e.g. go.sum. A string can be specified instead of a
+// map as a shorthand for prefix.
+type CacheKey struct {
+	Prefix string   `json:"prefix" yaml:"prefix"`
+	Files  []string `json:"files"  yaml:"files"`
+}
+
+var _ yaml.Unmarshaler = (*CacheKey)(nil)
+
+func (key *CacheKey) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		return node.Decode(&key.Prefix)
+	}
+
+	type plain CacheKey
+
+	return node.Decode((*plain)(key))
+}
+
+// Service is a container started next to the job container, it's reachable
+// from the job by its alias. A string can be specified instead of a map as a
+// shorthand for image.
+type Service struct {
 	Image     string             `json:"image"     yaml:"image"`
-	Commands  []string           `json:"commands"  yaml:"commands"`
+	Alias     string             `json:"alias"     yaml:"alias"`
+	Variables *mapslice.MapSlice `json:"variables" yaml:"variables"`
+	Command   []string           `json:"command"   yaml:"command"`
 }
 
-func Unmarshal(data []byte) (Pipeline, error) {
-	var config Pipeline
+var _ yaml.Unmarshaler = (*Service)(nil)
 
-	raw := map[string]yaml.Node{}
-	err := yaml.Unmarshal(data, &raw)
+func (service *Service) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		err := node.Decode(&service.Image)
+		if err != nil {
+			return err
+		}
+	} else {
+		type plain Service
+
+		err := node.Decode((*plain)(service))
+		if err != nil {
+			return err
+		}
+	}
+
+	if service.Image == "" {
+		return errors.New("service image is not specified")
+	}
+
+	if service.Alias == "" {
+		service.Alias = getServiceAlias(service.Image)
+	}
+
+	return nil
+}
+
+// DIND_PORT is the port of the docker daemon of docker in docker services,
+// TLS is disabled because the daemon is reachable only from the job network.
+const DIND_PORT = 2375
+
+// IsDockerInDocker returns true if the service is a docker daemon which is
+// started from the official docker:dind image. Such services are started in
+// privileged mode and DOCKER_HOST of the job points to the daemon, so docker
+// commands in the job work without mounting the docker socket of the host.
+func (service Service) IsDockerInDocker() bool {
+	name := service.Image
+	tag := ""
+	if index := strings.LastIndex(name, ":"); index > strings.LastIndex(name, "/") {
+		name, tag = name[:index], name[index+1:]
+	}
+
+	switch strings.TrimPrefix(name, "docker.io/") {
+	case "docker", "library/docker":
+		return strings.Contains(tag, "dind")
+	default:
+		return false
+	}
+}
+
+// getServiceAlias returns the image name without registry, tag and digest
+// with slashes replaced by dashes, e.g. library/postgres:13 → library-postgres
+func getServiceAlias(image string) string {
+	name := image
+	if index := strings.Index(name, "@"); index >= 0 {
+		name = name[:index]
+	}
+
+	if index := strings.LastIndex(name, ":"); index > strings.LastIndex(name, "/") {
+		name = name[:index]
+	}
+
+	parts := strings.Split(name, "/")
+	if len(parts) > 1 && strings.ContainsAny(parts[0], ".:") {
+		parts = parts[1:]
+	}
+
+	return strings.Join(parts, "-")
+}
+
+const (
+	RULE_WHEN_ON_SUCCESS = "on_success"
+	RULE_WHEN_NEVER      = "never"
+)
+
+// Condition matches a pipeline by its ref, environment variables and files
+// changed by the pipeline commit. Every specified field must match, a field
+// matches if any of its patterns matches. Patterns are globs where ** matches
+// across slashes, patterns enclosed in slashes are regular expressions.
+//
+// A list can be specified instead of a map as a shorthand for refs.
+type Condition struct {
+	Refs      []string          `json:"refs"      yaml:"refs"`
+	RefTypes  []string          `json:"ref_types" yaml:"ref_types"`
+	Variables map[string]string `json:"variables" yaml:"variables"`
+	Changes   []string          `json:"changes"   yaml:"changes"`
+}
+
+var _ yaml.Unmarshaler = (*Condition)(nil)
+
+func (condition *Condition) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.SequenceNode {
+		err := node.Decode(&condition.Refs)
+		if err != nil {
+			return err
+		}
+	} else {
+		type plain Condition
+
+		err := node.Decode((*plain)(condition))
+		if err != nil {
+			return err
+		}
+	}
+
+	return condition.validate()
+}
+
+func (condition *Condition) validate() error {
+	patterns := append([]string{}, condition.Refs...)
+	for _, pattern := range condition.Variables {
+		patterns = append(patterns, pattern)
+	}
+
+	for _, pattern := range patterns {
+		if !IsRegexpPattern(pattern) {
+			continue
+		}
+
+		_, err := regexp.Compile(pattern[1 : len(pattern)-1])
+		if err != nil {
+			return fmt.Errorf("invalid regular expression %s: %s", pattern, err)
+		}
+	}
+
+	return nil
+}
+
+// IsRegexpPattern returns true if the pattern is enclosed in slashes.
+func IsRegexpPattern(pattern string) bool {
+	return len(pattern) > 1 &&
+		strings.HasPrefix(pattern, "/") &&
+		strings.HasSuffix(pattern, "/")
+}
+
+// Rule is a condition with an action, the first matching rule of the job
+// decides whether the job runs.
+type Rule struct {
+	Condition Condition `json:"condition" yaml:",inline"`
+
+	When string `json:"when" yaml:"when"`
+}
+
+var _ yaml.Unmarshaler = (*Rule)(nil)
+
+func (rule *Rule) UnmarshalYAML(node *yaml.Node) error {
+	type plain Rule
+
+	err := node.Decode((*plain)(rule))
 	if err != nil {
-		return config, err
+		return err
+	}
+
+	switch rule.When {
+	case "":
+		rule.When = RULE_WHEN_ON_SUCCESS
+	case RULE_WHEN_ON_SUCCESS, RULE_WHEN_NEVER:
+	default:
+		return fmt.Errorf(
+			"invalid rule when %q, expected one of: %s, %s",
+			rule.When, RULE_WHEN_ON_SUCCESS, RULE_WHEN_NEVER,
+		)
 	}
 
-	if node, ok := raw["image"]; ok {
-		err = node.Decode(&config.Image)
+	return rule.Condition.validate()
+}
+
+const (
+	RETRY_WHEN_IMAGE_PULL_FAILURE    = "image_pull_failure"
+	RETRY_WHEN_RUNNER_SYSTEM_FAILURE = "runner_system_failure"
+	RETRY_WHEN_SCRIPT_FAILURE        = "script_failure"
+
+	RETRY_MAX_LIMIT = 10
+)
+
+var retryWhen = map[string]struct{}{
+	RETRY_WHEN_IMAGE_PULL_FAILURE:    {},
+	RETRY_WHEN_RUNNER_SYSTEM_FAILURE: {},
+	RETRY_WHEN_SCRIPT_FAILURE:        {},
+}
+
+// Retry specifies how many times a failed job is restarted and which kinds
+// of failures cause the restart, all kinds of failures are retried if When is
+// empty. It can be specified either as a map or just as a number which
+// stands for Max. Failed attempts leave the workspace as is.
+type Retry struct {
+	Max  int      `json:"max"  yaml:"max"`
+	When []string `json:"when" yaml:"when"`
+}
+
+var _ yaml.Unmarshaler = (*Retry)(nil)
+
+func (retry *Retry) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		err := node.Decode(&retry.Max)
 		if err != nil {
-			return config, karma.Format(
-				err,
-				"invalid yaml field: 'image'",
+			return err
+		}
+	} else {
+		type plain Retry
+
+		err := node.Decode((*plain)(retry))
+		if err != nil {
+			return err
+		}
+	}
+
+	if retry.Max < 0 || retry.Max > RETRY_MAX_LIMIT {
+		return fmt.Errorf(
+			"invalid retry max %d, must be between 0 and %d",
+			retry.Max, RETRY_MAX_LIMIT,
+		)
+	}
+
+	for _, when := range retry.When {
+		if _, ok := retryWhen[when]; !ok {
+			return fmt.Errorf(
+				"invalid retry condition %q, expected one of: %s, %s, %s",
+				when,
+				RETRY_WHEN_IMAGE_PULL_FAILURE,
+				RETRY_WHEN_RUNNER_SYSTEM_FAILURE,
+				RETRY_WHEN_SCRIPT_FAILURE,
 			)
 		}
+	}
+
+	return nil
+}
 
-		delete(raw, "image")
+// Duration is a time.Duration specified in yaml as a string like "1h30m".
+type Duration time.Duration
+
+var _ yaml.Unmarshaler = (*Duration)(nil)
+
+func (duration *Duration) UnmarshalYAML(node *yaml.Node) error {
+	var raw string
+	err := node.Decode(&raw)
+	if err != nil {
+		return err
+	}
+
+	value, err := time.ParseDuration(raw)
+	if err != nil {
+		return fmt.Errorf("invalid duration %q, expected a value like 1h30m", raw)
+	}
+
+	if value < 0 {
+		return fmt.Errorf("invalid duration %q, must not be negative", raw)
+	}
+
+	*duration = Duration(value)
+
+	return nil
+}
+
+func (duration Duration) Duration() time.Duration {
+	return time.Duration(duration)
+}
+
+func Unmarshal(data []byte) (Pipeline, error) {
+	document, err := NewDocument(data)
+	if err != nil {
+		return Pipeline{}, err
+	}
+
+	return UnmarshalDocument(document)
+}
+
+// UnmarshalDocument decodes the pipeline document, errors of keys defined in
+// included files mention these files.
+func UnmarshalDocument(document *Document) (Pipeline, error) {
+	var config Pipeline
+
+	raw := map[string]yaml.Node{}
+	if root := document.getRoot(); root != nil {
+		err := root.Decode(&raw)
+		if err != nil {
+			return config, err
+		}
 	}
 
-	if node, ok := raw["stages"]; !ok {
+	if _, ok := raw["stages"]; !ok {
 		return config, errors.New("missing stages field")
-	} else {
-		err = node.Decode(&config.Stages)
+	}
+
+	// every top-level key which is not a field of the pipeline is a job
+	value := reflect.ValueOf(&config).Elem()
+	for name, field := range getFields(config) {
+		node, ok := raw[name]
+		if !ok {
+			continue
+		}
+
+		err := node.Decode(value.FieldByIndex(field.Index).Addr().Interface())
 		if err != nil {
 			return config, karma.Format(
 				err,
-				"invalid yaml field: 'stages'",
+				"invalid yaml field: '%s'%s", name, document.describeKey(name),
 			)
 		}
 
-		delete(raw, "stages")
+		delete(raw, name)
 	}
 
-	config.Jobs = map[string]Job{}
+	nodes := map[string]*yaml.Node{}
 	for jobName, node := range raw {
+		node := node
+		nodes[jobName] = &node
+	}
+
+	extender := newExtender(nodes)
+
+	// hidden jobs are only templates for other jobs
+	config.Jobs = map[string]Job{}
+	for jobName := range nodes {
+		if isHiddenJob(jobName) {
+			continue
+		}
+
+		node, err := extender.resolve(jobName)
+		if err != nil {
+			return config, err
+		}
+
 		var job Job
-		err := node.Decode(&job)
+		err = node.Decode(&job)
 		if err != nil {
 			return config, karma.Format(
 				err,
-				"invalid yaml job: '%s'", jobName,
+				"invalid yaml job: '%s'%s", jobName, document.describeKey(jobName),
 			)
 		}
 
 		config.Jobs[jobName] = job
 	}
 
-	if node, ok := raw["variables"]; ok {
-		config.Variables, err = mapslice.New(node)
-		if err != nil {
-			return config, karma.Format(
-				err,
-				"invalid yaml field: 'variables'",
-			)
-		}
+	err := expandJobs(&config)
+	if err != nil {
+		return config, err
 	}
 
 	return config, nil
 }
+
+// getFields returns struct fields by their yaml names, the jobs field is
+// skipped because jobs are specified as top-level keys.
+func getFields(value interface{}) map[string]reflect.StructField {
+	fields := map[string]reflect.StructField{}
+
+	structType := reflect.TypeOf(value)
+	for i := 0; i < structType.NumField(); i++ {
+		field := structType.Field(i)
+
+		name := strings.Split(field.Tag.Get("yaml"), ",")[0]
+		if name == "" || name == "-" || name == "jobs" {
+			continue
+		}
+
+		fields[name] = field
+	}
+
+	return fields
+}
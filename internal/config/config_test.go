@@ -12,7 +12,12 @@ import (
 	"github.com/stretchr/testify/assert"
 )
 
-var reAddress = regexp.MustCompile(`0x[a-h0-9]+`)
+var (
+	reAddress = regexp.MustCompile(`0x[a-h0-9]+`)
+
+	// jobs are stored in a map, so keys must be sorted to get stable dumps
+	dumper = spew.ConfigState{Indent: " ", SortKeys: true}
+)
 
 func TestUnmarshal(t *testing.T) {
 	test := assert.New(t)
@@ -59,7 +64,7 @@ func TestUnmarshal(t *testing.T) {
 			//    panic(err)
 			//}
 
-			encoded := reAddress.ReplaceAllString(spew.Sdump(pipeline), "0x")
+			encoded := reAddress.ReplaceAllString(dumper.Sdump(pipeline), "0x")
 			test.EqualValues(string(contents), string(encoded), match)
 			tested = true
 		}
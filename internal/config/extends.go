@@ -0,0 +1,237 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// HIDDEN_JOB_PREFIX is a prefix of jobs which are never run, such jobs
+	// are templates for other jobs.
+	HIDDEN_JOB_PREFIX = "."
+
+	EXTENDS_KEY = "extends"
+)
+
+func isHiddenJob(name string) bool {
+	return strings.HasPrefix(name, HIDDEN_JOB_PREFIX)
+}
+
+// extendsError is an error of the extends key of the job, the node points to
+// the exact place of the problem so the linter can report it.
+type extendsError struct {
+	job     string
+	node    *yaml.Node
+	message string
+}
+
+func (err *extendsError) Error() string {
+	return fmt.Sprintf("job %q: %s", err.job, err.message)
+}
+
+// extender resolves extends of jobs. Jobs are merged in order of the extends
+// list and the job itself is merged last: maps are merged key by key keeping
+// order of keys, any other values are replaced.
+type extender struct {
+	jobs     map[string]*yaml.Node
+	resolved map[string]*yaml.Node
+	failed   map[string]error
+	visiting map[string]bool
+	path     []string
+}
+
+func newExtender(jobs map[string]*yaml.Node) *extender {
+	return &extender{
+		jobs:     jobs,
+		resolved: map[string]*yaml.Node{},
+		failed:   map[string]error{},
+		visiting: map[string]bool{},
+	}
+}
+
+// resolve returns the job node with all extended jobs merged into it, the
+// extends key is removed from the result.
+func (extender *extender) resolve(name string) (*yaml.Node, error) {
+	if node, ok := extender.resolved[name]; ok {
+		return node, nil
+	}
+
+	if err, ok := extender.failed[name]; ok {
+		return nil, err
+	}
+
+	node, err := extender.merge(name)
+	if err != nil {
+		extender.failed[name] = err
+		return nil, err
+	}
+
+	extender.resolved[name] = node
+
+	return node, nil
+}
+
+func (extender *extender) merge(name string) (*yaml.Node, error) {
+	node := resolveAlias(extender.jobs[name])
+
+	extends, own, err := splitExtends(name, node)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(extends) == 0 {
+		return own, nil
+	}
+
+	extender.visiting[name] = true
+	extender.path = append(extender.path, name)
+	defer func() {
+		delete(extender.visiting, name)
+		extender.path = extender.path[:len(extender.path)-1]
+	}()
+
+	result := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	for _, item := range extends {
+		target := item.Value
+
+		if extender.visiting[target] {
+			cycle := []string{}
+			for i := len(extender.path) - 1; i >= 0; i-- {
+				cycle = append([]string{extender.path[i]}, cycle...)
+				if extender.path[i] == target {
+					break
+				}
+			}
+
+			return nil, &extendsError{
+				job:  name,
+				node: item,
+				message: fmt.Sprintf(
+					"extends cycle: %s -> %s",
+					strings.Join(cycle, " -> "), target,
+				),
+			}
+		}
+
+		if _, ok := extender.jobs[target]; !ok {
+			return nil, &extendsError{
+				job:     name,
+				node:    item,
+				message: fmt.Sprintf("extends unknown job %q", target),
+			}
+		}
+
+		base, err := extender.resolve(target)
+		if err != nil {
+			return nil, err
+		}
+
+		if base.Kind != yaml.MappingNode {
+			return nil, &extendsError{
+				job:     name,
+				node:    item,
+				message: fmt.Sprintf("extended job %q is not a map", target),
+			}
+		}
+
+		result = mergeNodes(result, base)
+	}
+
+	return mergeNodes(result, own), nil
+}
+
+// splitExtends returns names listed in the extends key of the job and the job
+// node without the extends key.
+func splitExtends(name string, node *yaml.Node) ([]*yaml.Node, *yaml.Node, error) {
+	if node.Kind != yaml.MappingNode {
+		return nil, node, nil
+	}
+
+	var extends *yaml.Node
+
+	own := *node
+	own.Content = []*yaml.Node{}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		if key.Value == EXTENDS_KEY {
+			extends = resolveAlias(value)
+			continue
+		}
+
+		own.Content = append(own.Content, key, value)
+	}
+
+	if extends == nil {
+		return nil, node, nil
+	}
+
+	items := []*yaml.Node{}
+	switch extends.Kind {
+	case yaml.ScalarNode:
+		items = append(items, extends)
+	case yaml.SequenceNode:
+		for _, item := range extends.Content {
+			items = append(items, resolveAlias(item))
+		}
+	}
+
+	valid := len(items) > 0
+	for _, item := range items {
+		if item.Kind != yaml.ScalarNode || item.Value == "" {
+			valid = false
+		}
+	}
+
+	if !valid {
+		return nil, nil, &extendsError{
+			job:     name,
+			node:    extends,
+			message: "extends: a job name or a list of job names expected",
+		}
+	}
+
+	return items, &own, nil
+}
+
+// mergeNodes merges the override node into the base node, maps are merged
+// recursively, any other node replaces the base one. Nodes are not modified.
+func mergeNodes(base *yaml.Node, override *yaml.Node) *yaml.Node {
+	base = resolveAlias(base)
+	override = resolveAlias(override)
+
+	if base.Kind != yaml.MappingNode || override.Kind != yaml.MappingNode {
+		return override
+	}
+
+	merged := *base
+	merged.Content = append([]*yaml.Node{}, base.Content...)
+
+	for i := 0; i+1 < len(override.Content); i += 2 {
+		key, value := override.Content[i], override.Content[i+1]
+
+		found := false
+		for j := 0; j+1 < len(merged.Content); j += 2 {
+			if merged.Content[j].Value == key.Value {
+				merged.Content[j+1] = mergeNodes(merged.Content[j+1], value)
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			merged.Content = append(merged.Content, key, value)
+		}
+	}
+
+	return &merged
+}
+
+func resolveAlias(node *yaml.Node) *yaml.Node {
+	for node.Kind == yaml.AliasNode && node.Alias != nil {
+		node = node.Alias
+	}
+
+	return node
+}
@@ -0,0 +1,262 @@
+package config
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+)
+
+func getExtender(test *assert.Assertions, data string) *extender {
+	raw := map[string]yaml.Node{}
+	test.NoError(yaml.Unmarshal([]byte(strings.TrimSpace(data)), &raw))
+
+	nodes := map[string]*yaml.Node{}
+	for name, node := range raw {
+		node := node
+		nodes[name] = &node
+	}
+
+	return newExtender(nodes)
+}
+
+func getResolved(test *assert.Assertions, extender *extender, name string) string {
+	node, err := extender.resolve(name)
+	test.NoError(err)
+	if node == nil {
+		return ""
+	}
+
+	data, err := yaml.Marshal(node)
+	test.NoError(err)
+
+	return strings.TrimSpace(string(data))
+}
+
+func TestExtender_Resolve(t *testing.T) {
+	test := assert.New(t)
+
+	extender := getExtender(test, `
+.base:
+  image: golang
+  variables:
+    GOOS: linux
+    GOARCH: amd64
+  cache:
+    key: go
+    paths: [.cache/go]
+  commands:
+    - make
+
+.race:
+  extends: .base
+  variables:
+    GOARCH: arm64
+    GOFLAGS: -race
+  cache:
+    policy: pull
+  commands:
+    - make test
+
+test:
+  extends: [.race, .lint]
+  variables:
+    GOOS: darwin
+  commands:
+    - make check
+
+.lint:
+  image: golangci
+  commands:
+    - make lint
+`)
+
+	// maps are merged key by key keeping order of keys of the first job,
+	// other values including commands are replaced by the latter jobs
+	test.Equal(strings.TrimSpace(`
+image: golangci
+variables:
+    GOOS: darwin
+    GOARCH: arm64
+    GOFLAGS: -race
+cache:
+    key: go
+    paths: [.cache/go]
+    policy: pull
+commands:
+    - make check
+`), getResolved(test, extender, "test"))
+
+	test.Equal(strings.TrimSpace(`
+image: golang
+variables:
+    GOOS: linux
+    GOARCH: arm64
+    GOFLAGS: -race
+cache:
+    key: go
+    paths: [.cache/go]
+    policy: pull
+commands:
+    - make test
+`), getResolved(test, extender, ".race"))
+
+	// extended jobs are not modified
+	test.Equal(strings.TrimSpace(`
+image: golang
+variables:
+    GOOS: linux
+    GOARCH: amd64
+cache:
+    key: go
+    paths: [.cache/go]
+commands:
+    - make
+`), getResolved(test, extender, ".base"))
+}
+
+func TestExtender_Resolve_ExtendsNotHiddenJobs(t *testing.T) {
+	test := assert.New(t)
+
+	extender := getExtender(test, `
+build:
+  stage: build
+  image: golang
+
+integration:
+  extends: build
+  stage: test
+`)
+
+	test.Equal(strings.TrimSpace(`
+stage: test
+image: golang
+`), getResolved(test, extender, "integration"))
+}
+
+func TestExtender_Resolve_Aliases(t *testing.T) {
+	test := assert.New(t)
+
+	extender := getExtender(test, `
+.base:
+  image: golang
+
+.template: &template
+  extends: &extends [.base]
+  stage: build
+
+build: *template
+
+test:
+  extends: *extends
+  stage: test
+`)
+
+	test.Equal(strings.TrimSpace(`
+image: golang
+stage: build
+`), getResolved(test, extender, "build"))
+
+	test.Equal(strings.TrimSpace(`
+image: golang
+stage: test
+`), getResolved(test, extender, "test"))
+}
+
+func TestExtender_Resolve_ReturnsErrors(t *testing.T) {
+	test := assert.New(t)
+
+	extender := getExtender(test, `
+.scalar: 1
+
+unknown:
+  extends: [.missing, .scalar]
+
+hidden:
+  extends: .missing
+
+scalar:
+  extends: .scalar
+
+self:
+  extends: self
+
+loop-a:
+  extends: loop-b
+
+loop-b:
+  extends: loop-c
+
+loop-c:
+  extends: [unknown, loop-a]
+
+invalid:
+  extends: [[build]]
+
+empty:
+  extends: []
+`)
+
+	testcases := map[string]string{
+		"unknown": `job "unknown": extends unknown job ".missing"`,
+		"hidden":  `job "hidden": extends unknown job ".missing"`,
+		"scalar":  `job "scalar": extended job ".scalar" is not a map`,
+		"self":    `job "self": extends cycle: self -> self`,
+		// errors of extended jobs are returned as is
+		"loop-a":  `job "unknown": extends unknown job ".missing"`,
+		"invalid": `job "invalid": extends: a job name or a list of job names expected`,
+		"empty":   `job "empty": extends: a job name or a list of job names expected`,
+	}
+
+	for name, expected := range testcases {
+		_, err := extender.resolve(name)
+		test.EqualError(err, expected, name)
+	}
+
+	extender = getExtender(test, `
+loop-a:
+  extends: loop-b
+
+loop-b:
+  extends: loop-c
+
+loop-c:
+  extends: loop-a
+`)
+
+	_, err := extender.resolve("loop-a")
+	test.EqualError(err, `job "loop-c": extends cycle: loop-a -> loop-b -> loop-c -> loop-a`)
+
+	// errors are cached, so every job of the cycle reports the same error
+	_, err = extender.resolve("loop-b")
+	test.EqualError(err, `job "loop-c": extends cycle: loop-a -> loop-b -> loop-c -> loop-a`)
+
+	extendsErr, ok := err.(*extendsError)
+	test.True(ok)
+	test.Equal(8, extendsErr.node.Line)
+	test.Equal(12, extendsErr.node.Column)
+}
+
+func TestMergeNodes(t *testing.T) {
+	test := assert.New(t)
+
+	var base, override yaml.Node
+	test.NoError(yaml.Unmarshal([]byte("{a: 1, b: {c: 2, d: [1]}}"), &base))
+	test.NoError(yaml.Unmarshal([]byte("{b: {d: [2], e: 3}, f: 4}"), &override))
+
+	merged := mergeNodes(base.Content[0], override.Content[0])
+
+	data, err := yaml.Marshal(merged)
+	test.NoError(err)
+	test.Equal("{a: 1, b: {c: 2, d: [2], e: 3}, f: 4}\n", string(data))
+
+	data, err = yaml.Marshal(&base)
+	test.NoError(err)
+	test.Equal("{a: 1, b: {c: 2, d: [1]}}\n", string(data))
+
+	// non-map values are replaced
+	var scalar yaml.Node
+	test.NoError(yaml.Unmarshal([]byte("value"), &scalar))
+	test.Equal(scalar.Content[0], mergeNodes(base.Content[0], scalar.Content[0]))
+}
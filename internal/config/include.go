@@ -0,0 +1,343 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/reconquest/karma-go"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	INCLUDE_KEY = "include"
+
+	// INCLUDE_MAX_FILES limits number of files included into a single
+	// pipeline, a file included several times is counted every time.
+	INCLUDE_MAX_FILES = 100
+)
+
+// Include is an entry of the include list of the pipeline file. Local files
+// are read from the same repository and revision as the including file,
+// a string is a shorthand for a local file.
+type Include struct {
+	Local   string `json:"local"   yaml:"local"`
+	Project string `json:"project" yaml:"project"`
+	Ref     string `json:"ref"     yaml:"ref"`
+	File    string `json:"file"    yaml:"file"`
+}
+
+var _ yaml.Unmarshaler = (*Include)(nil)
+
+func (include *Include) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		include.Local = node.Value
+		return nil
+	}
+
+	type plain Include
+
+	err := node.Decode((*plain)(include))
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case include.Local != "" && (include.Project != "" || include.File != ""):
+		return errors.New("local can't be used together with project and file")
+
+	case include.Local != "":
+		return nil
+
+	case include.Project == "" || include.File == "":
+		return errors.New("either local or project and file must be specified")
+	}
+
+	parts := strings.Split(include.Project, "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return fmt.Errorf(
+			"project must be specified as PROJECT_KEY/repository but got %q",
+			include.Project,
+		)
+	}
+
+	return nil
+}
+
+// IncludeSource identifies a pipeline file. Project is empty for files of the
+// repository of the pipeline, the empty ref stands for the default branch.
+type IncludeSource struct {
+	Project string
+	Ref     string
+	Path    string
+}
+
+func (source IncludeSource) String() string {
+	if source.Project == "" {
+		return source.Path
+	}
+
+	if source.Ref == "" {
+		return source.Project + ":" + source.Path
+	}
+
+	return source.Project + "@" + source.Ref + ":" + source.Path
+}
+
+// IncludeReader returns contents of the given pipeline file.
+type IncludeReader func(source IncludeSource) ([]byte, error)
+
+// Document is a parsed pipeline file. Included files are merged into the
+// document, nodes of included files are remembered so problems found in them
+// point to the right file.
+type Document struct {
+	node    *yaml.Node
+	origins map[*yaml.Node]string
+}
+
+// NewDocument parses the pipeline file, the document may be empty.
+func NewDocument(data []byte) (*Document, error) {
+	document := &Document{
+		node:    &yaml.Node{},
+		origins: map[*yaml.Node]string{},
+	}
+
+	err := yaml.Unmarshal(data, document.node)
+	if err != nil {
+		return document, err
+	}
+
+	return document, nil
+}
+
+func (document *Document) getRoot() *yaml.Node {
+	if len(document.node.Content) == 0 {
+		return nil
+	}
+
+	return document.node.Content[0]
+}
+
+// Include merges files listed in the include key of the document into it.
+// Included files are merged in order of the list, the including file is
+// merged last, so it can override anything defined by included files. Maps
+// are merged key by key, any other values are replaced.
+func (document *Document) Include(filename string, read IncludeReader) error {
+	root := document.getRoot()
+	if root == nil || root.Kind != yaml.MappingNode {
+		return nil
+	}
+
+	includer := &includer{
+		read:     read,
+		origins:  document.origins,
+		visiting: map[string]bool{},
+	}
+
+	source := IncludeSource{
+		Path: path.Clean(strings.TrimPrefix(filename, "/")),
+	}
+
+	merged, err := includer.resolve(source, root)
+	if err != nil {
+		return err
+	}
+
+	document.node.Content[0] = merged
+
+	return nil
+}
+
+// getOrigin returns the included file which the node is defined in, it's
+// empty for nodes of the pipeline file itself.
+func (document *Document) getOrigin(node *yaml.Node) string {
+	return document.origins[node]
+}
+
+// describeKey returns a suffix for error messages which mentions the included
+// file where the given top-level key is defined.
+func (document *Document) describeKey(key string) string {
+	root := document.getRoot()
+	if root == nil || root.Kind != yaml.MappingNode {
+		return ""
+	}
+
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if root.Content[i].Value != key {
+			continue
+		}
+
+		origin := document.getOrigin(root.Content[i])
+		if origin == "" {
+			return ""
+		}
+
+		return " (included from " + origin + ")"
+	}
+
+	return ""
+}
+
+type includer struct {
+	read     IncludeReader
+	origins  map[*yaml.Node]string
+	visiting map[string]bool
+	path     []string
+	files    int
+}
+
+func (includer *includer) resolve(
+	source IncludeSource,
+	root *yaml.Node,
+) (*yaml.Node, error) {
+	key := source.String()
+
+	includer.visiting[key] = true
+	includer.path = append(includer.path, key)
+	defer func() {
+		delete(includer.visiting, key)
+		includer.path = includer.path[:len(includer.path)-1]
+	}()
+
+	var list *yaml.Node
+
+	own := *root
+	own.Content = []*yaml.Node{}
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if root.Content[i].Value == INCLUDE_KEY {
+			list = root.Content[i+1]
+			continue
+		}
+
+		own.Content = append(own.Content, root.Content[i], root.Content[i+1])
+	}
+
+	if list == nil {
+		return root, nil
+	}
+
+	var includes []Include
+	if list.Kind == yaml.SequenceNode {
+		err := list.Decode(&includes)
+		if err != nil {
+			return nil, karma.Format(err, "%s: invalid include", key)
+		}
+	} else {
+		var include Include
+		err := list.Decode(&include)
+		if err != nil {
+			return nil, karma.Format(err, "%s: invalid include", key)
+		}
+
+		includes = append(includes, include)
+	}
+
+	result := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	for _, include := range includes {
+		target, err := getIncludeSource(source, include)
+		if err != nil {
+			return nil, karma.Format(err, "%s: invalid include", key)
+		}
+
+		node, err := includer.include(source, target)
+		if err != nil {
+			return nil, err
+		}
+
+		result = mergeNodes(result, node)
+	}
+
+	return mergeNodes(result, &own), nil
+}
+
+func (includer *includer) include(
+	source IncludeSource,
+	target IncludeSource,
+) (*yaml.Node, error) {
+	key := target.String()
+
+	if includer.visiting[key] {
+		return nil, fmt.Errorf(
+			"%s: include cycle: %s -> %s",
+			source, strings.Join(includer.path, " -> "), key,
+		)
+	}
+
+	includer.files++
+	if includer.files > INCLUDE_MAX_FILES {
+		return nil, fmt.Errorf(
+			"%s: too many included files, the limit is %d",
+			source, INCLUDE_MAX_FILES,
+		)
+	}
+
+	data, err := includer.read(target)
+	if err != nil {
+		return nil, karma.Format(
+			err,
+			"%s: unable to read included file %s",
+			source, key,
+		)
+	}
+
+	var document yaml.Node
+	err = yaml.Unmarshal(data, &document)
+	if err != nil {
+		return nil, karma.Format(err, "%s: invalid yaml", key)
+	}
+
+	if len(document.Content) == 0 {
+		return &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}, nil
+	}
+
+	root := document.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf(
+			"%s: a map expected but got %s node",
+			key, stringKind(root.Kind),
+		)
+	}
+
+	includer.track(root, key)
+
+	return includer.resolve(target, root)
+}
+
+// track remembers the origin of every node of the included file.
+func (includer *includer) track(node *yaml.Node, origin string) {
+	includer.origins[node] = origin
+	for _, child := range node.Content {
+		includer.track(child, origin)
+	}
+}
+
+// getIncludeSource returns the source of the include relative to the
+// including file, local includes of a file from another repository are read
+// from that repository.
+func getIncludeSource(source IncludeSource, include Include) (IncludeSource, error) {
+	target := IncludeSource{
+		Project: source.Project,
+		Ref:     source.Ref,
+		Path:    include.Local,
+	}
+
+	if include.Project != "" {
+		target = IncludeSource{
+			Project: include.Project,
+			Ref:     include.Ref,
+			Path:    include.File,
+		}
+	}
+
+	target.Path = path.Clean(strings.TrimPrefix(target.Path, "/"))
+	if target.Path == "." || target.Path == ".." ||
+		strings.HasPrefix(target.Path, "../") {
+		return target, fmt.Errorf(
+			"path must point to a file in the repository but got %q",
+			target.Path,
+		)
+	}
+
+	return target, nil
+}
@@ -0,0 +1,154 @@
+package config
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func getIncludeReader(files map[string]string) IncludeReader {
+	return func(source IncludeSource) ([]byte, error) {
+		data, ok := files[source.String()]
+		if !ok {
+			return nil, fmt.Errorf("no such file: %s", source)
+		}
+
+		return []byte(data), nil
+	}
+}
+
+func TestDocument_Include(t *testing.T) {
+	test := assert.New(t)
+
+	files := map[string]string{
+		"ci/go.yml": `
+include:
+  - project: CI/templates
+    ref: v1
+    file: go.yml
+
+build:
+  stage: build
+  variables:
+    GOOS: linux
+`,
+		"CI/templates@v1:go.yml": `
+include: base.yml
+
+.go:
+  image: golang
+`,
+		"CI/templates@v1:base.yml": `
+stages:
+  - build
+  - test
+
+variables:
+  GOFLAGS: -mod=vendor
+`,
+	}
+
+	document, err := NewDocument([]byte(`
+include:
+  - ci/go.yml
+
+build:
+  extends: .go
+  commands:
+    - make
+  variables:
+    CGO_ENABLED: "0"
+`))
+	test.NoError(err)
+
+	err = document.Include(".snake-ci.yml", getIncludeReader(files))
+	test.NoError(err)
+
+	test.Empty(LintDocument(document))
+
+	pipeline, err := UnmarshalDocument(document)
+	test.NoError(err)
+
+	test.Equal([]string{"build", "test"}, pipeline.Stages)
+	test.Equal("-mod=vendor", pipeline.Variables.Map()["GOFLAGS"])
+
+	build := pipeline.Jobs["build"]
+	test.Equal("build", build.Stage)
+	test.Equal("golang", build.Image)
+	test.Equal([]string{"make"}, build.Commands)
+	test.Equal(
+		map[string]string{"GOOS": "linux", "CGO_ENABLED": "0"},
+		build.Variables.Map(),
+	)
+	test.Equal("GOOS", build.Variables.Pairs()[0].Key)
+}
+
+func TestDocument_Include_ReportsProblemsOfIncludedFiles(t *testing.T) {
+	test := assert.New(t)
+
+	files := map[string]string{
+		"ci/jobs.yml": `
+test:
+  stage: test
+  comands:
+    - make test
+`,
+	}
+
+	document, err := NewDocument([]byte(`
+include: ci/jobs.yml
+
+stages:
+  - test
+`))
+	test.NoError(err)
+
+	err = document.Include(".snake-ci.yml", getIncludeReader(files))
+	test.NoError(err)
+
+	problems := []string{}
+	for _, problem := range LintDocument(document) {
+		problems = append(problems, problem.String())
+	}
+
+	test.Equal(
+		[]string{
+			`ci/jobs.yml:2:1: job "test": missing commands field`,
+			`ci/jobs.yml:4:3: job "test": unknown key "comands"`,
+		},
+		problems,
+	)
+}
+
+func TestDocument_Include_ReturnsErrorOnCycle(t *testing.T) {
+	test := assert.New(t)
+
+	files := map[string]string{
+		"a.yml": "include: b.yml",
+		"b.yml": "include: /.snake-ci.yml",
+	}
+
+	document, err := NewDocument([]byte("include: a.yml"))
+	test.NoError(err)
+
+	err = document.Include(".snake-ci.yml", getIncludeReader(files))
+	test.EqualError(
+		err,
+		"b.yml: include cycle: .snake-ci.yml -> a.yml -> b.yml -> .snake-ci.yml",
+	)
+}
+
+func TestDocument_Include_ReturnsErrorOnInvalidPath(t *testing.T) {
+	test := assert.New(t)
+
+	document, err := NewDocument([]byte("include: ../secret.yml"))
+	test.NoError(err)
+
+	err = document.Include(".snake-ci.yml", getIncludeReader(nil))
+	test.EqualError(
+		err,
+		".snake-ci.yml: invalid include\n"+
+			`└─ path must point to a file in the repository but got "../secret.yml"`,
+	)
+}
@@ -0,0 +1,506 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/reconquest/karma-go"
+	"github.com/reconquest/snake-runner/internal/mapslice"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	reYamlLine        = regexp.MustCompile(`^(yaml: )?line \d+: `)
+	reYamlSyntaxError = regexp.MustCompile(`^yaml: line (\d+): (.*)$`)
+)
+
+// Problem is a single issue found in a pipeline file. File is set only if
+// the problem is found in an included file.
+type Problem struct {
+	File    string
+	Line    int
+	Column  int
+	Message string
+}
+
+func (problem Problem) String() string {
+	if problem.File != "" {
+		return fmt.Sprintf(
+			"%s:%d:%d: %s",
+			problem.File, problem.Line, problem.Column, problem.Message,
+		)
+	}
+
+	return fmt.Sprintf("%d:%d: %s", problem.Line, problem.Column, problem.Message)
+}
+
+type Problems []Problem
+
+// Reason returns all problems as a single hierarchical error with every
+// problem as a separate branch.
+func (problems Problems) Reason(message string) error {
+	reasons := make([]karma.Reason, len(problems))
+	for i, problem := range problems {
+		reasons[i] = problem.String()
+	}
+
+	return karma.Push(message, reasons...)
+}
+
+type linter struct {
+	document *Document
+	problems Problems
+}
+
+type jobNode struct {
+	key   *yaml.Node
+	value *yaml.Node
+
+	// resolved is the job with extended jobs merged into it, it's nil if
+	// extends of the job are invalid.
+	resolved *yaml.Node
+}
+
+// Lint validates the given pipeline file and returns every problem found
+// in it, problems are ordered by their position in the file.
+func Lint(data []byte) Problems {
+	document, err := NewDocument(data)
+	if err != nil {
+		linter := &linter{}
+		linter.addError(document.node, err)
+
+		return linter.problems
+	}
+
+	return LintDocument(document)
+}
+
+// LintDocument validates the given pipeline document, problems of included
+// files go after problems of the pipeline file.
+func LintDocument(document *Document) Problems {
+	linter := &linter{document: document}
+	linter.lint()
+
+	sort.SliceStable(linter.problems, func(i, j int) bool {
+		a, b := linter.problems[i], linter.problems[j]
+		if a.File != b.File {
+			return a.File < b.File
+		}
+
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+
+		return a.Column < b.Column
+	})
+
+	return linter.problems
+}
+
+func (linter *linter) lint() {
+	root := linter.document.getRoot()
+	if root == nil {
+		linter.add(linter.document.node, "the pipeline file is empty")
+		return
+	}
+
+	if root.Kind != yaml.MappingNode {
+		linter.add(root, "a map expected but got %s node", stringKind(root.Kind))
+		return
+	}
+
+	pipelineFields := getFields(Pipeline{})
+
+	stages := map[string]int{}
+	hasStages := false
+
+	jobs := []jobNode{}
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		key, value := root.Content[i], root.Content[i+1]
+
+		if key.Value == "stages" {
+			hasStages = true
+
+			for i, stage := range linter.lintStages(value) {
+				stages[stage] = i
+			}
+
+			continue
+		}
+
+		if field, ok := pipelineFields[key.Value]; ok {
+			linter.lintField(key.Value, field.Type, value)
+			continue
+		}
+
+		jobs = append(jobs, jobNode{key: key, value: value})
+	}
+
+	if !hasStages {
+		linter.add(root, "missing stages field")
+	}
+
+	linter.lintExtends(jobs)
+
+	for _, job := range jobs {
+		linter.lintJob(job, stages, hasStages)
+	}
+
+	linter.lintNeeds(jobs, stages)
+}
+
+func (linter *linter) lintStages(node *yaml.Node) []string {
+	if node.Kind != yaml.SequenceNode {
+		linter.add(
+			node,
+			"stages: a list expected but got %s node",
+			stringKind(node.Kind),
+		)
+		return nil
+	}
+
+	stages := []string{}
+	seen := map[string]struct{}{}
+	for _, item := range node.Content {
+		if item.Kind != yaml.ScalarNode || item.Value == "" {
+			linter.add(item, "stages: stage name must be a non-empty string")
+			continue
+		}
+
+		if _, ok := seen[item.Value]; ok {
+			linter.add(item, "stages: duplicate stage %q", item.Value)
+			continue
+		}
+
+		seen[item.Value] = struct{}{}
+		stages = append(stages, item.Value)
+	}
+
+	return stages
+}
+
+// lintExtends resolves extends of all jobs, every problem is reported only
+// once for the job which has it.
+func (linter *linter) lintExtends(jobs []jobNode) {
+	nodes := map[string]*yaml.Node{}
+	for _, job := range jobs {
+		nodes[job.key.Value] = job.value
+	}
+
+	extender := newExtender(nodes)
+
+	for i, job := range jobs {
+		resolved, err := extender.resolve(job.key.Value)
+		if err != nil {
+			if err, ok := err.(*extendsError); ok && err.job == job.key.Value {
+				linter.add(err.node, "%s", err.Error())
+			}
+
+			continue
+		}
+
+		jobs[i].resolved = resolved
+	}
+}
+
+func (linter *linter) lintJob(
+	job jobNode,
+	stages map[string]int,
+	hasStages bool,
+) {
+	key, node := job.key, job.value
+	name := key.Value
+
+	if node.Kind != yaml.MappingNode {
+		linter.add(
+			node,
+			"job %q: a map expected but got %s node",
+			name, stringKind(node.Kind),
+		)
+		return
+	}
+
+	jobFields := getFields(Job{})
+
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+
+		if key.Value == EXTENDS_KEY {
+			continue
+		}
+
+		field, ok := jobFields[key.Value]
+		if !ok {
+			linter.add(key, "job %q: unknown key %q", name, key.Value)
+			continue
+		}
+
+		linter.lintField(
+			fmt.Sprintf("job %q: %s", name, key.Value),
+			field.Type,
+			value,
+		)
+	}
+
+	// hidden jobs are templates, so they may be incomplete, required fields
+	// can be inherited from extended jobs
+	if isHiddenJob(name) || job.resolved == nil {
+		return
+	}
+
+	if stage := findKey(job.resolved, "stage"); stage == nil {
+		linter.add(key, "job %q: missing stage field", name)
+	} else if stage.Kind == yaml.ScalarNode && hasStages {
+		if _, ok := stages[stage.Value]; !ok {
+			linter.add(
+				stage,
+				"job %q: stage %q is not listed in stages",
+				name, stage.Value,
+			)
+		}
+	}
+
+	if commands := findKey(job.resolved, "commands"); commands == nil {
+		linter.add(key, "job %q: missing commands field", name)
+	} else if commands.Kind == yaml.SequenceNode && len(commands.Content) == 0 {
+		linter.add(commands, "job %q: commands list is empty", name)
+	}
+}
+
+// lintNeeds checks that jobs need only existing jobs from the same or
+// previous stages and that there are no dependency cycles. Hidden jobs are
+// never run, so they can't be needed.
+func (linter *linter) lintNeeds(jobs []jobNode, stages map[string]int) {
+	byName := map[string]jobNode{}
+	for _, job := range jobs {
+		if isHiddenJob(job.key.Value) {
+			continue
+		}
+
+		byName[job.key.Value] = job
+	}
+
+	stageOf := func(job *yaml.Node) (int, bool) {
+		node := findKey(job, "stage")
+		if node == nil {
+			return 0, false
+		}
+
+		index, ok := stages[node.Value]
+		return index, ok
+	}
+
+	names := []string{}
+	graph := map[string][]string{}
+	for _, job := range jobs {
+		name := job.key.Value
+		if _, ok := byName[name]; !ok {
+			continue
+		}
+
+		names = append(names, name)
+
+		if job.resolved == nil || job.resolved.Kind != yaml.MappingNode {
+			continue
+		}
+
+		needs := findKey(job.resolved, "needs")
+		if needs == nil || needs.Kind != yaml.SequenceNode {
+			continue
+		}
+
+		stage, hasStage := stageOf(job.resolved)
+
+		for _, item := range needs.Content {
+			if item.Kind != yaml.ScalarNode {
+				continue
+			}
+
+			if item.Value == name {
+				linter.add(item, "job %q: needs itself", name)
+				continue
+			}
+
+			need, ok := byName[item.Value]
+			if !ok {
+				linter.add(item, "job %q: needs unknown job %q", name, item.Value)
+				continue
+			}
+
+			if needStage, ok := stageOf(need.resolved); ok && hasStage {
+				if needStage > stage {
+					linter.add(
+						item,
+						"job %q: needs job %q from a later stage",
+						name, item.Value,
+					)
+					continue
+				}
+			}
+
+			graph[name] = append(graph[name], item.Value)
+		}
+	}
+
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+
+	marks := map[string]int{}
+	path := []string{}
+
+	var visit func(name string)
+	visit = func(name string) {
+		marks[name] = visiting
+		path = append(path, name)
+
+		for _, need := range graph[name] {
+			switch marks[need] {
+			case visiting:
+				cycle := []string{}
+				for i := len(path) - 1; i >= 0; i-- {
+					cycle = append([]string{path[i]}, cycle...)
+					if path[i] == need {
+						break
+					}
+				}
+
+				linter.add(
+					byName[need].key,
+					"job %q: dependency cycle: %s -> %s",
+					need, strings.Join(cycle, " -> "), need,
+				)
+			case unvisited:
+				visit(need)
+			}
+		}
+
+		path = path[:len(path)-1]
+		marks[name] = visited
+	}
+
+	for _, name := range names {
+		if marks[name] == unvisited {
+			visit(name)
+		}
+	}
+}
+
+func (linter *linter) lintField(
+	prefix string,
+	field reflect.Type,
+	node *yaml.Node,
+) {
+	if field == reflect.TypeOf(&mapslice.MapSlice{}) {
+		linter.lintMap(prefix, node)
+		return
+	}
+
+	target := reflect.New(field)
+
+	err := node.Decode(target.Interface())
+	if err != nil {
+		if typeErr, ok := err.(*yaml.TypeError); ok {
+			for _, message := range typeErr.Errors {
+				linter.add(
+					node, "%s: %s",
+					prefix, reYamlLine.ReplaceAllString(message, ""),
+				)
+			}
+
+			return
+		}
+
+		linter.add(
+			node, "%s: %s",
+			prefix, reYamlLine.ReplaceAllString(err.Error(), ""),
+		)
+	}
+}
+
+func (linter *linter) lintMap(prefix string, node *yaml.Node) {
+	if node.Kind != yaml.MappingNode {
+		linter.add(
+			node,
+			"%s: a map expected but got %s node",
+			prefix, stringKind(node.Kind),
+		)
+		return
+	}
+
+	for _, item := range node.Content {
+		if item.Kind != yaml.ScalarNode {
+			linter.add(
+				item,
+				"%s: a scalar expected but got %s node",
+				prefix, stringKind(item.Kind),
+			)
+		}
+	}
+}
+
+func (linter *linter) add(node *yaml.Node, format string, args ...interface{}) {
+	file := ""
+	if linter.document != nil {
+		file = linter.document.getOrigin(node)
+	}
+
+	linter.problems = append(linter.problems, Problem{
+		File:    file,
+		Line:    node.Line,
+		Column:  node.Column,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+func (linter *linter) addError(node *yaml.Node, err error) {
+	matches := reYamlSyntaxError.FindStringSubmatch(err.Error())
+	if len(matches) == 0 {
+		linter.add(node, "%s", strings.TrimPrefix(err.Error(), "yaml: "))
+		return
+	}
+
+	line, _ := strconv.Atoi(matches[1])
+
+	linter.problems = append(linter.problems, Problem{
+		Line:    line,
+		Message: matches[2],
+	})
+}
+
+func findKey(node *yaml.Node, key string) *yaml.Node {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil
+	}
+
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+
+	return nil
+}
+
+func stringKind(kind yaml.Kind) string {
+	switch kind {
+	case yaml.AliasNode:
+		return "alias"
+	case yaml.DocumentNode:
+		return "document"
+	case yaml.MappingNode:
+		return "mapping"
+	case yaml.ScalarNode:
+		return "scalar"
+	case yaml.SequenceNode:
+		return "sequence"
+	}
+
+	return "unknown"
+}
@@ -0,0 +1,41 @@
+package config
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLint(t *testing.T) {
+	test := assert.New(t)
+
+	dir := "../../testdata/lint/"
+	matches, err := filepath.Glob(dir + "*.yaml")
+	if err != nil {
+		panic(err)
+	}
+
+	for _, match := range matches {
+		name := strings.TrimSuffix(filepath.Base(match), ".yaml")
+
+		contents, err := ioutil.ReadFile(match)
+		if err != nil {
+			panic(err)
+		}
+
+		expected, err := ioutil.ReadFile(dir + name + ".problems")
+		if err != nil {
+			panic(err)
+		}
+
+		actual := ""
+		for _, problem := range Lint(contents) {
+			actual += problem.String() + "\n"
+		}
+
+		test.Equal(string(expected), actual, match)
+	}
+}
@@ -0,0 +1,251 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/reconquest/snake-runner/internal/mapslice"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	PARALLEL_MAX_LIMIT = 200
+
+	NODE_INDEX_VAR = "CI_NODE_INDEX"
+	NODE_TOTAL_VAR = "CI_NODE_TOTAL"
+)
+
+// Parallel is the number of copies of the job, a job is expanded even if
+// there is only one copy, so it gets CI_NODE_INDEX and CI_NODE_TOTAL.
+type Parallel int
+
+var _ yaml.Unmarshaler = (*Parallel)(nil)
+
+func (parallel *Parallel) UnmarshalYAML(node *yaml.Node) error {
+	var value int
+	err := node.Decode(&value)
+	if err != nil {
+		return err
+	}
+
+	if value < 1 || value > PARALLEL_MAX_LIMIT {
+		return fmt.Errorf(
+			"parallel must be between 1 and %d but got %d",
+			PARALLEL_MAX_LIMIT, value,
+		)
+	}
+
+	*parallel = Parallel(value)
+
+	return nil
+}
+
+// MatrixEntry is a set of variables of a matrix job, a variable can have
+// several values. A job is created for every combination of values.
+type MatrixEntry []MatrixVariable
+
+type MatrixVariable struct {
+	Name   string
+	Values []string
+}
+
+var _ yaml.Unmarshaler = (*MatrixEntry)(nil)
+
+func (entry *MatrixEntry) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind != yaml.MappingNode {
+		return errors.New("matrix entry must be a map of variables")
+	}
+
+	for i := 0; i < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+
+		variable := MatrixVariable{Name: key.Value}
+
+		switch value.Kind {
+		case yaml.ScalarNode:
+			variable.Values = []string{value.Value}
+		case yaml.SequenceNode:
+			err := value.Decode(&variable.Values)
+			if err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf(
+				"matrix variable %q must be a value or a list of values",
+				key.Value,
+			)
+		}
+
+		if len(variable.Values) == 0 {
+			return fmt.Errorf("matrix variable %q has no values", key.Value)
+		}
+
+		*entry = append(*entry, variable)
+	}
+
+	return nil
+}
+
+// combinations returns all combinations of values of the entry variables,
+// values of the first variable change slowest.
+func (entry MatrixEntry) combinations() []*mapslice.MapSlice {
+	result := []*mapslice.MapSlice{mapslice.FromPairs()}
+
+	for _, variable := range entry {
+		next := []*mapslice.MapSlice{}
+		for _, combination := range result {
+			for _, value := range variable.Values {
+				vars := combination.Clone()
+				vars.Set(variable.Name, value)
+
+				next = append(next, vars)
+			}
+		}
+
+		result = next
+	}
+
+	return result
+}
+
+// expandJobs replaces matrix and parallel jobs with concrete jobs, needs of
+// other jobs are updated to point to all expanded jobs.
+func expandJobs(pipeline *Pipeline) error {
+	expanded := map[string][]string{}
+
+	names := []string{}
+	for name := range pipeline.Jobs {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		job := pipeline.Jobs[name]
+
+		jobs, err := expandJob(name, job)
+		if err != nil {
+			return fmt.Errorf("job %q: %s", name, err)
+		}
+
+		if jobs == nil {
+			continue
+		}
+
+		delete(pipeline.Jobs, name)
+
+		for _, concrete := range jobs {
+			if _, ok := pipeline.Jobs[concrete.name]; ok {
+				return fmt.Errorf(
+					"job %q: expanded job %q conflicts with another job",
+					name, concrete.name,
+				)
+			}
+
+			pipeline.Jobs[concrete.name] = concrete.job
+
+			expanded[name] = append(expanded[name], concrete.name)
+		}
+	}
+
+	if len(expanded) == 0 {
+		return nil
+	}
+
+	for name, job := range pipeline.Jobs {
+		if job.Needs == nil {
+			continue
+		}
+
+		needs := []string{}
+		for _, need := range job.Needs {
+			if jobs, ok := expanded[need]; ok {
+				needs = append(needs, jobs...)
+			} else {
+				needs = append(needs, need)
+			}
+		}
+
+		job.Needs = needs
+		pipeline.Jobs[name] = job
+	}
+
+	pipeline.Expanded = expanded
+
+	return nil
+}
+
+type namedJob struct {
+	name string
+	job  Job
+}
+
+// expandJob returns nil if the job has neither matrix nor parallel.
+func expandJob(name string, job Job) ([]namedJob, error) {
+	if job.Parallel != 0 && len(job.Matrix) > 0 {
+		return nil, errors.New("parallel and matrix can't be used together")
+	}
+
+	type node struct {
+		name string
+		vars *mapslice.MapSlice
+	}
+
+	nodes := []node{}
+
+	switch {
+	case job.Parallel > 0:
+		for i := 1; i <= int(job.Parallel); i++ {
+			nodes = append(nodes, node{
+				name: fmt.Sprintf("%s %d/%d", name, i, job.Parallel),
+				vars: mapslice.FromPairs(),
+			})
+		}
+
+	case len(job.Matrix) > 0:
+		for _, entry := range job.Matrix {
+			for _, vars := range entry.combinations() {
+				values := []string{}
+				for _, pair := range vars.Pairs() {
+					values = append(values, pair.Value)
+				}
+
+				nodes = append(nodes, node{
+					name: fmt.Sprintf("%s: [%s]", name, strings.Join(values, ", ")),
+					vars: vars,
+				})
+			}
+		}
+
+		if len(nodes) > PARALLEL_MAX_LIMIT {
+			return nil, fmt.Errorf(
+				"matrix produces %d jobs, the limit is %d",
+				len(nodes), PARALLEL_MAX_LIMIT,
+			)
+		}
+
+	default:
+		return nil, nil
+	}
+
+	jobs := []namedJob{}
+	for i, node := range nodes {
+		concrete := job
+		concrete.Parallel = 0
+		concrete.Matrix = nil
+
+		concrete.Variables = job.Variables.Clone()
+		for _, pair := range node.vars.Pairs() {
+			concrete.Variables.Set(pair.Key, pair.Value)
+		}
+
+		concrete.Variables.Set(NODE_INDEX_VAR, fmt.Sprint(i+1))
+		concrete.Variables.Set(NODE_TOTAL_VAR, fmt.Sprint(len(nodes)))
+
+		jobs = append(jobs, namedJob{name: node.name, job: concrete})
+	}
+
+	return jobs, nil
+}
@@ -0,0 +1,233 @@
+package console
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/reconquest/karma-go"
+	"github.com/reconquest/snake-runner/internal/api"
+	"github.com/reconquest/snake-runner/internal/snake"
+	"github.com/reconquest/snake-runner/internal/status"
+)
+
+var _ api.Master = (*Master)(nil)
+
+// Master stands in for the real master when pipelines are running locally:
+// logs and statuses of jobs are printed to the given writer instead of being
+// sent to Bitbucket.
+//
+//go:generate gonstructor --type=Master --init init
+type Master struct {
+	writer io.Writer
+	jobs   []snake.PipelineJob
+
+	// artifactsDir is a directory to save artifacts of jobs to, artifacts are
+	// discarded if it's empty
+	artifactsDir string
+
+	mutex   sync.Mutex            `gonstructor:"-"`
+	names   map[int]string        `gonstructor:"-"`
+	partial map[int]string        `gonstructor:"-"`
+	status  map[int]status.Status `gonstructor:"-"`
+}
+
+func (master *Master) init() {
+	master.names = map[int]string{}
+	master.partial = map[int]string{}
+	master.status = map[int]status.Status{}
+
+	for _, job := range master.jobs {
+		master.names[job.ID] = job.Name
+	}
+}
+
+func (master *Master) UpdatePipeline(
+	id int,
+	status status.Status,
+	startedAt *time.Time,
+	finishedAt *time.Time,
+) error {
+	master.mutex.Lock()
+	defer master.mutex.Unlock()
+
+	fmt.Fprintf(master.writer, ":: pipeline %d: %s\n", id, status)
+
+	return nil
+}
+
+func (master *Master) UpdateJob(
+	pipelineID int,
+	jobID int,
+	status status.Status,
+	startedAt *time.Time,
+	finishedAt *time.Time,
+) error {
+	master.mutex.Lock()
+	defer master.mutex.Unlock()
+
+	if finishedAt != nil {
+		master.flush(jobID)
+	}
+
+	master.status[jobID] = status
+
+	fmt.Fprintf(master.writer, ":: job %s: %s\n", master.names[jobID], status)
+
+	return nil
+}
+
+func (master *Master) PushLogs(pipelineID, jobID int, text string) error {
+	master.mutex.Lock()
+	defer master.mutex.Unlock()
+
+	lines := strings.Split(master.partial[jobID]+text, "\n")
+
+	for _, line := range lines[:len(lines)-1] {
+		master.writeLine(jobID, line)
+	}
+
+	master.partial[jobID] = lines[len(lines)-1]
+
+	return nil
+}
+
+// ExpandJob renames the job and creates jobs with the next free IDs, jobs of
+// local pipelines are usually expanded before the pipeline is started.
+func (master *Master) ExpandJob(
+	pipelineID int,
+	jobID int,
+	names []string,
+) ([]snake.PipelineJob, error) {
+	master.mutex.Lock()
+	defer master.mutex.Unlock()
+
+	var original *snake.PipelineJob
+	for i := range master.jobs {
+		if master.jobs[i].ID == jobID {
+			original = &master.jobs[i]
+			break
+		}
+	}
+
+	if original == nil {
+		return nil, fmt.Errorf("no such job: %d", jobID)
+	}
+
+	nextID := 0
+	for id := range master.names {
+		if id > nextID {
+			nextID = id
+		}
+	}
+
+	fmt.Fprintf(
+		master.writer,
+		":: job %s: expanded into %d jobs\n",
+		original.Name, len(names),
+	)
+
+	jobs := []snake.PipelineJob{}
+	for i, name := range names {
+		job := *original
+		job.Name = name
+
+		if i > 0 {
+			nextID++
+			job.ID = nextID
+		}
+
+		master.names[job.ID] = job.Name
+
+		jobs = append(jobs, job)
+	}
+
+	*original = jobs[0]
+
+	master.jobs = append(master.jobs, jobs[1:]...)
+
+	return jobs, nil
+}
+
+// UploadArtifacts saves the archive as <job name>.tar.gz in the artifacts
+// directory.
+func (master *Master) UploadArtifacts(
+	pipelineID int,
+	jobID int,
+	expireIn time.Duration,
+	archive io.Reader,
+) error {
+	if master.artifactsDir == "" {
+		_, err := io.Copy(ioutil.Discard, archive)
+		return err
+	}
+
+	path := filepath.Join(master.artifactsDir, master.names[jobID]+".tar.gz")
+
+	err := os.MkdirAll(master.artifactsDir, 0o755)
+	if err != nil {
+		return karma.Format(err, "unable to create directory for artifacts")
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return karma.Format(err, "unable to create artifacts file")
+	}
+
+	_, err = io.Copy(file, archive)
+	if err != nil {
+		file.Close()
+		return karma.Format(err, "unable to write artifacts file")
+	}
+
+	err = file.Close()
+	if err != nil {
+		return karma.Format(err, "unable to write artifacts file")
+	}
+
+	master.mutex.Lock()
+	defer master.mutex.Unlock()
+
+	fmt.Fprintf(
+		master.writer,
+		":: job %s: artifacts saved to %s\n",
+		master.names[jobID], path,
+	)
+
+	return nil
+}
+
+// Status returns the last status reported for the given job.
+func (master *Master) Status(jobID int) status.Status {
+	master.mutex.Lock()
+	defer master.mutex.Unlock()
+
+	result, ok := master.status[jobID]
+	if !ok {
+		return status.UNKNOWN
+	}
+
+	return result
+}
+
+func (master *Master) flush(jobID int) {
+	if master.partial[jobID] != "" {
+		master.writeLine(jobID, master.partial[jobID])
+	}
+
+	delete(master.partial, jobID)
+}
+
+func (master *Master) writeLine(jobID int, line string) {
+	fmt.Fprintf(
+		master.writer,
+		"[%s] %s\n",
+		master.names[jobID],
+		strings.TrimRight(line, "\r"),
+	)
+}
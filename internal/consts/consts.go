@@ -3,6 +3,7 @@ package consts
 const (
 	SUBDIR_GIT                   = `git`
 	SUBDIR_SSH                   = `ssh`
+	SUBDIR_REMOTE                = `remote`
 	SSH_AUTH_SOCK_VAR            = `SSH_AUTH_SOCK`
 	SSH_SOCKET_FILENAME          = `ssh-agent.sock`
 	GIT_SSH_COMMAND_VAR          = `GIT_SSH_COMMAND`
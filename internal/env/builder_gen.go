@@ -15,6 +15,7 @@ func NewBuilder(
 	job snake.PipelineJob,
 	config config.Pipeline,
 	configJob config.Job,
+	inherited map[string]string,
 	runnerConfig *runner.Config,
 	gitDir string,
 	sshSocketPath string,
@@ -26,6 +27,7 @@ func NewBuilder(
 		job:               job,
 		config:            config,
 		configJob:         configJob,
+		inherited:         inherited,
 		runnerConfig:      runnerConfig,
 		gitDir:            gitDir,
 		sshSocketPath:     sshSocketPath,
@@ -0,0 +1,57 @@
+package env
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var dotenvKey = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// ParseDotenv parses KEY=VALUE lines, empty lines and lines starting with #
+// are ignored. Values can be enclosed in single or double quotes, escape
+// sequences are interpreted only in double quotes. Values are never expanded.
+func ParseDotenv(data string) (map[string]string, error) {
+	vars := map[string]string{}
+
+	for number, line := range strings.Split(data, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		line = strings.TrimPrefix(line, "export ")
+
+		equal := strings.Index(line, "=")
+		if equal == -1 {
+			return nil, fmt.Errorf("line %d: expected KEY=VALUE", number+1)
+		}
+
+		key := strings.TrimSpace(line[:equal])
+		if !dotenvKey.MatchString(key) {
+			return nil, fmt.Errorf("line %d: invalid variable name %q", number+1, key)
+		}
+
+		value := strings.TrimSpace(line[equal+1:])
+		if len(value) >= 2 {
+			switch {
+			case value[0] == '"' && value[len(value)-1] == '"':
+				unquoted, err := strconv.Unquote(value)
+				if err != nil {
+					return nil, fmt.Errorf(
+						"line %d: invalid quoted value of %q", number+1, key,
+					)
+				}
+
+				value = unquoted
+			case value[0] == '\'' && value[len(value)-1] == '\'':
+				value = value[1 : len(value)-1]
+			}
+		}
+
+		vars[key] = value
+	}
+
+	return vars, nil
+}
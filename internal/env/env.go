@@ -20,6 +20,7 @@ type Builder struct {
 	job               snake.PipelineJob
 	config            config.Pipeline
 	configJob         config.Job
+	inherited         map[string]string
 	runnerConfig      *runner.Config
 	gitDir            string
 	sshSocketPath     string
@@ -121,15 +122,27 @@ func (builder *Builder) build() map[string]string {
 	// running in sidecar
 	vars[consts.SSH_AUTH_SOCK_VAR] = builder.sshSocketPath
 
+	// only variables of the runner which are available to jobs are
+	// expanded, so secrets of the runner don't leak into variables
 	expand := func(key string) string {
 		result, ok := vars[key]
 		if !ok {
-			return os.Getenv(key)
+			result, _ = builder.runnerConfig.LookupPassthroughEnv(key)
 		}
 
 		return result
 	}
 
+	// docker commands of the job use the daemon of the docker in docker
+	// service unless DOCKER_HOST is specified in variables
+	for _, service := range builder.configJob.Services {
+		if service.IsDockerInDocker() {
+			vars["DOCKER_HOST"] = fmt.Sprintf(
+				"tcp://%s:%d", service.Alias, config.DIND_PORT,
+			)
+		}
+	}
+
 	for key, value := range builder.task.Env {
 		vars[key] = os.Expand(value, expand)
 	}
@@ -140,6 +153,12 @@ func (builder *Builder) build() map[string]string {
 		}
 	}
 
+	// variables from dotenv reports of jobs the job depends on, they are
+	// already final values so they are not expanded
+	for key, value := range builder.inherited {
+		vars[key] = value
+	}
+
 	if builder.configJob.Variables != nil {
 		for _, pair := range builder.configJob.Variables.Pairs() {
 			vars[pair.Key] = os.Expand(pair.Value, expand)
@@ -1,6 +1,7 @@
 package env
 
 import (
+	"os"
 	"testing"
 
 	"github.com/reconquest/snake-runner/internal/builtin"
@@ -48,6 +49,7 @@ func TestEnvBuilder(t *testing.T) {
 
 	configPipeline := config.Pipeline{}
 	configJob := config.Job{}
+	inherited := map[string]string{}
 
 	builder := func(pipeline snake.Pipeline) *Builder {
 		return NewBuilder(
@@ -56,6 +58,7 @@ func TestEnvBuilder(t *testing.T) {
 			job,
 			configPipeline,
 			configJob,
+			inherited,
 			&runnerConfig,
 			"/git",
 			"/ssh/ssh-agent.sock",
@@ -210,6 +213,84 @@ func TestEnvBuilder(t *testing.T) {
 
 		test.EqualValues(expected, builder(basicPipeline).build())
 	}
+
+	{
+		inherited["VERSION"] = "1.0.0"
+		inherited["qux"] = "quxdotenv"
+		inherited["raw"] = "$foo"
+		configJob.Variables = mapslice.FromPairs(
+			"qux", "quxjob",
+			"release", "v$VERSION",
+		)
+
+		expected := clone(expected)
+		expected["foo"] = "globalfoo"
+		expected["bar"] = "globalbar"
+		expected["VERSION"] = "1.0.0"
+		expected["qux"] = "quxjob"
+		expected["raw"] = "$foo"
+		expected["release"] = "v1.0.0"
+
+		test.EqualValues(expected, builder(basicPipeline).build())
+	}
+
+	{
+		configPipeline.Variables = nil
+		configJob.Variables = nil
+		inherited = map[string]string{}
+
+		configJob.Services = []config.Service{
+			{Image: "postgres:13", Alias: "postgres"},
+			{Image: "docker:19.03-dind", Alias: "docker"},
+		}
+
+		expected := clone(expected)
+		expected["DOCKER_HOST"] = "tcp://docker:2375"
+
+		test.EqualValues(expected, builder(basicPipeline).build())
+
+		configJob.Variables = mapslice.FromPairs(
+			"DOCKER_HOST", "tcp://docker:2376",
+		)
+
+		expected["DOCKER_HOST"] = "tcp://docker:2376"
+
+		test.EqualValues(expected, builder(basicPipeline).build())
+	}
+
+	{
+		os.Setenv("SNAKE_ACCESS_TOKEN", "secret")
+		defer os.Unsetenv("SNAKE_ACCESS_TOKEN")
+
+		os.Setenv("DEPLOY_HOST", "example.com")
+		defer os.Unsetenv("DEPLOY_HOST")
+
+		configJob.Services = nil
+		configJob.Variables = mapslice.FromPairs(
+			"token", "$SNAKE_ACCESS_TOKEN",
+			"path", "$PATH",
+			"host", "$DEPLOY_HOST",
+		)
+
+		expected := clone(expected)
+		expected["token"] = ""
+		expected["path"] = os.Getenv("PATH")
+		expected["host"] = ""
+
+		test.EqualValues(expected, builder(basicPipeline).build())
+
+		runnerConfig.Env.Passthrough = []string{"DEPLOY_*"}
+
+		expected["host"] = "example.com"
+
+		test.EqualValues(expected, builder(basicPipeline).build())
+
+		runnerConfig.Env.InheritAll = true
+
+		expected["token"] = "secret"
+
+		test.EqualValues(expected, builder(basicPipeline).build())
+	}
 }
 
 func clone(original map[string]string) map[string]string {
@@ -219,3 +300,32 @@ func clone(original map[string]string) map[string]string {
 	}
 	return result
 }
+
+func TestParseDotenv(t *testing.T) {
+	test := assert.New(t)
+
+	vars, err := ParseDotenv(`
+# comment
+VERSION=1.0.0
+export TAG = v1
+QUOTED="a b\tc"
+SINGLE='$HOME\n'
+EMPTY=
+URL=http://host/?a=b
+`)
+	test.NoError(err)
+	test.EqualValues(map[string]string{
+		"VERSION": "1.0.0",
+		"TAG":     "v1",
+		"QUOTED":  "a b\tc",
+		"SINGLE":  `$HOME\n`,
+		"EMPTY":   "",
+		"URL":     "http://host/?a=b",
+	}, vars)
+
+	_, err = ParseDotenv("VERSION")
+	test.EqualError(err, "line 1: expected KEY=VALUE")
+
+	_, err = ParseDotenv("\n1VERSION=1")
+	test.EqualError(err, `line 2: invalid variable name "1VERSION"`)
+}
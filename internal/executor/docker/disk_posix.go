@@ -0,0 +1,22 @@
+// +build !windows
+
+package docker
+
+import (
+	"syscall"
+)
+
+// getDiskUsage returns usage of the disk with the given dir in percents.
+func getDiskUsage(dir string) (int, error) {
+	var stat syscall.Statfs_t
+	err := syscall.Statfs(dir, &stat)
+	if err != nil {
+		return 0, err
+	}
+
+	if stat.Blocks == 0 {
+		return 0, nil
+	}
+
+	return int((stat.Blocks - stat.Bavail) * 100 / stat.Blocks), nil
+}
@@ -0,0 +1,13 @@
+// +build windows
+
+package docker
+
+import (
+	"errors"
+)
+
+// getDiskUsage is not implemented on windows, so the image gc removes only
+// images which are unused for too long.
+func getDiskUsage(dir string) (int, error) {
+	return 0, errors.New("disk usage is not supported on windows")
+}
@@ -6,18 +6,21 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"strings"
 
 	"github.com/docker/cli/cli/trust"
 	docker_reference "github.com/docker/distribution/reference"
 	docker_types "github.com/docker/docker/api/types"
 	docker_container "github.com/docker/docker/api/types/container"
+	docker_network "github.com/docker/docker/api/types/network"
 	docker_registrytypes "github.com/docker/docker/api/types/registry"
 	docker_client "github.com/docker/docker/client"
 	"github.com/docker/docker/pkg/jsonmessage"
 	"github.com/docker/docker/pkg/stdcopy"
 	"github.com/docker/docker/pkg/term"
 	"github.com/docker/docker/registry"
+	units "github.com/docker/go-units"
 	"github.com/reconquest/karma-go"
 	"github.com/reconquest/pkg/log"
 	"github.com/reconquest/snake-runner/internal/executor"
@@ -26,11 +29,19 @@ import (
 
 const (
 	IMAGE_LABEL_KEY = "io.reconquest.snake"
+
+	// EXIT_CODE_KILLED is the exit code of processes killed by SIGKILL, the
+	// OOM killer uses it
+	EXIT_CODE_KILLED = 128 + 9
 )
 
 type Container struct {
 	id   string
 	name string
+
+	// memoryLimited is true if the container is created with a memory limit,
+	// only such containers are checked for being out of memory
+	memoryLimited bool
 }
 
 func (container Container) ID() string {
@@ -42,8 +53,9 @@ func (container Container) String() string {
 }
 
 type Image struct {
-	ID   string
-	Tags []string
+	ID      string
+	Tags    []string
+	Digests []string
 }
 
 type Docker struct {
@@ -51,20 +63,36 @@ type Docker struct {
 
 	network string
 	volumes []string
+
+	// rootDir is the docker root dir on the host, it's used by the image gc
+	// to check disk usage
+	rootDir string
+
+	usage *imageUsage
 }
 
 func NewDocker(network string, volumes []string) *Docker {
 	return &Docker{
 		network: network,
 		volumes: volumes,
+		usage:   newImageUsage(),
 	}
 }
 
 func (docker *Docker) Connect() error {
+	return docker.connect(docker_client.FromEnv)
+}
+
+// ConnectHost connects to the given host instead of the one from DOCKER_HOST,
+// it's used for other engines with the Docker compatible API.
+func (docker *Docker) ConnectHost(host string) error {
+	return docker.connect(docker_client.FromEnv, docker_client.WithHost(host))
+}
+
+func (docker *Docker) connect(opts ...docker_client.Opt) error {
 	var err error
 	docker.client, err = docker_client.NewClientWithOpts(
-		docker_client.FromEnv,
-		docker_client.WithAPIVersionNegotiation(),
+		append(opts, docker_client.WithAPIVersionNegotiation())...,
 	)
 	if err != nil {
 		return err
@@ -75,9 +103,12 @@ func (docker *Docker) Connect() error {
 		return err
 	}
 
+	docker.rootDir = info.DockerRootDir
+
 	log.Infof(
 		nil,
-		"docker: server_version=%s kernel_version=%s oom_kill_disable=%v",
+		"docker: host=%s server_version=%s kernel_version=%s oom_kill_disable=%v",
+		docker.client.DaemonHost(),
 		info.ServerVersion,
 		info.KernelVersion,
 		info.OomKillDisable,
@@ -209,20 +240,60 @@ func (docker *Docker) Create(
 	}
 
 	hostConfig := &docker_container.HostConfig{
-		Binds: append([]string{}, docker.volumes...),
+		Binds:     append([]string{}, docker.volumes...),
+		Resources: getResources(opts.Resources),
+		ShmSize:   opts.Resources.ShmSize,
+
+		Privileged: opts.Privileges.Privileged,
+		CapAdd:     opts.Privileges.CapAdd,
+		CapDrop:    opts.Privileges.CapDrop,
+	}
+
+	for _, device := range opts.Privileges.Devices {
+		hostConfig.Devices = append(
+			hostConfig.Devices,
+			docker_container.DeviceMapping{
+				PathOnHost:        device.PathOnHost,
+				PathInContainer:   device.PathInContainer,
+				CgroupPermissions: device.CgroupPermissions,
+			},
+		)
 	}
 
 	for _, vol := range opts.Volumes {
 		hostConfig.Binds = append(hostConfig.Binds, string(vol))
 	}
 
-	if docker.network != "" {
+	networks := []executor.Network{}
+	for _, network := range opts.Networks {
+		if network != nil {
+			networks = append(networks, network)
+		}
+	}
+
+	var aliases []string
+	if opts.Alias != "" {
+		aliases = []string{opts.Alias}
+	}
+
+	var networkingConfig *docker_network.NetworkingConfig
+
+	switch {
+	case len(networks) > 0:
+		hostConfig.NetworkMode = docker_container.NetworkMode(networks[0].ID())
+
+		networkingConfig = &docker_network.NetworkingConfig{
+			EndpointsConfig: map[string]*docker_network.EndpointSettings{
+				networks[0].ID(): {Aliases: aliases},
+			},
+		}
+	case docker.network != "":
 		hostConfig.NetworkMode = docker_container.NetworkMode(docker.network)
 	}
 
 	created, err := docker.client.ContainerCreate(
 		ctx, config,
-		hostConfig, nil, opts.Name,
+		hostConfig, networkingConfig, opts.Name,
 	)
 	if err != nil {
 		return nil, err
@@ -230,6 +301,55 @@ func (docker *Docker) Create(
 
 	id := created.ID
 
+	// the container is removed if it can't be set up, otherwise it would be
+	// left until the next start of the runner
+	defer func() {
+		if err == nil {
+			return
+		}
+
+		removeErr := docker.client.ContainerRemove(
+			context.Background(), id,
+			docker_types.ContainerRemoveOptions{Force: true},
+		)
+		if removeErr != nil {
+			log.Errorf(
+				karma.Describe("id", id).Reason(removeErr),
+				"unable to remove container which failed to start",
+			)
+		}
+	}()
+
+	for i, network := range networks {
+		// the primary network is already connected on creation
+		if i == 0 {
+			continue
+		}
+
+		err = docker.client.NetworkConnect(
+			ctx, network.ID(), id,
+			&docker_network.EndpointSettings{Aliases: aliases},
+		)
+		if err != nil {
+			return nil, karma.Format(
+				err,
+				"unable to connect container to network %q", network.String(),
+			)
+		}
+	}
+
+	// the container is attached to the runner network as well, so
+	// everything that is reachable in the runner network is still reachable
+	if len(networks) > 0 && docker.network != "" {
+		err = docker.client.NetworkConnect(ctx, docker.network, id, nil)
+		if err != nil {
+			return nil, karma.Format(
+				err,
+				"unable to connect container to network %q", docker.network,
+			)
+		}
+	}
+
 	err = docker.client.ContainerStart(ctx, id, docker_types.ContainerStartOptions{})
 	if err != nil {
 		return nil, karma.Format(
@@ -238,7 +358,11 @@ func (docker *Docker) Create(
 		)
 	}
 
-	return Container{id: id, name: opts.Name}, nil
+	return Container{
+		id:            id,
+		name:          opts.Name,
+		memoryLimited: opts.Resources.Memory > 0,
+	}, nil
 }
 
 func (docker *Docker) Destroy(
@@ -267,10 +391,24 @@ func (docker *Docker) Exec(
 	container executor.Container,
 	opts executor.ExecOptions,
 ) error {
+	// the OOM flag belongs to the container and it's never reset, so only
+	// its change tells that the process of this exec has been killed
+	checkOOM := isMemoryLimited(container)
+
+	var oomKilled bool
+	if checkOOM {
+		var err error
+		oomKilled, err = docker.isOOMKilled(ctx, container)
+		if err != nil {
+			return karma.Format(err, "unable to inspect container")
+		}
+	}
+
 	exec, err := docker.client.ContainerExecCreate(
 		ctx,
 		container.ID(),
 		docker_types.ExecConfig{
+			AttachStdin:  opts.Stdin != nil,
 			AttachStderr: opts.AttachStderr,
 			AttachStdout: opts.AttachStdout,
 			Env:          opts.Env,
@@ -289,6 +427,18 @@ func (docker *Docker) Exec(
 	if err != nil {
 		return err
 	}
+	defer response.Close()
+
+	if opts.Stdin != nil {
+		go func() {
+			_, err := io.Copy(response.Conn, opts.Stdin)
+			if err != nil {
+				log.Errorf(err, "unable to write stdin of exec/attach")
+			}
+
+			response.CloseWrite()
+		}()
+	}
 
 	writer := callbackWriter{ctx: ctx, callback: opts.OutputConsumer}
 
@@ -305,6 +455,20 @@ func (docker *Docker) Exec(
 		)
 	}
 	if info.ExitCode > 0 {
+		if checkOOM && info.ExitCode == EXIT_CODE_KILLED && !oomKilled {
+			state, err := docker.client.ContainerInspect(ctx, container.ID())
+			if err == nil && state.State != nil && state.State.OOMKilled {
+				return karma.
+					Describe("exitcode", info.ExitCode).
+					Describe("memory", formatMemoryLimit(state.HostConfig)).
+					Format(
+						nil,
+						"container ran out of memory, "+
+							"the process has been killed by the kernel",
+					)
+			}
+		}
+
 		return karma.
 			Describe("exitcode", info.ExitCode).
 			Format(
@@ -316,8 +480,53 @@ func (docker *Docker) Exec(
 	return nil
 }
 
+func isMemoryLimited(container executor.Container) bool {
+	created, ok := container.(Container)
+	return ok && created.memoryLimited
+}
+
+func (docker *Docker) isOOMKilled(
+	ctx context.Context,
+	container executor.Container,
+) (bool, error) {
+	state, err := docker.client.ContainerInspect(ctx, container.ID())
+	if err != nil {
+		return false, err
+	}
+
+	return state.State != nil && state.State.OOMKilled, nil
+}
+
+// getResources converts resource limits to the docker ones, zero values
+// are not limited by docker either.
+func getResources(resources executor.Resources) docker_container.Resources {
+	result := docker_container.Resources{
+		NanoCPUs:   resources.NanoCPUs,
+		Memory:     resources.Memory,
+		MemorySwap: resources.MemorySwap,
+	}
+
+	if resources.PidsLimit > 0 {
+		pidsLimit := resources.PidsLimit
+		result.PidsLimit = &pidsLimit
+	}
+
+	return result
+}
+
+func formatMemoryLimit(hostConfig *docker_container.HostConfig) string {
+	if hostConfig == nil || hostConfig.Memory == 0 {
+		return "unlimited"
+	}
+
+	return units.BytesSize(float64(hostConfig.Memory))
+}
+
+// Cleanup removes containers and networks which are left after the previous
+// run of the runner. Stopped containers are removed as well, otherwise their
+// networks can't be removed.
 func (docker *Docker) Cleanup() error {
-	options := docker_types.ContainerListOptions{}
+	options := docker_types.ContainerListOptions{All: true}
 
 	containers, err := docker.client.ContainerList(context.Background(), options)
 	if err != nil {
@@ -355,7 +564,7 @@ func (docker *Docker) Cleanup() error {
 
 	log.Infof(nil, "cleanup: destroyed %d containers", destroyed)
 
-	return nil
+	return docker.cleanupNetworks()
 }
 
 func (docker *Docker) getImageByTag(
@@ -375,8 +584,9 @@ func (docker *Docker) getImageByTag(
 			if repoTag == query ||
 				(!strings.Contains(query, ":") && strings.HasPrefix(repoTag, query+":")) {
 				return &Image{
-					Tags: image.RepoTags,
-					ID:   image.ID,
+					Tags:    image.RepoTags,
+					ID:      image.ID,
+					Digests: image.RepoDigests,
 				}, nil
 			}
 		}
@@ -439,34 +649,53 @@ func (docker *Docker) Prepare(
 		return err
 	}
 
-	if image == nil {
-		if opts.InfoConsumer != nil {
-			opts.InfoConsumer(
-				fmt.Sprintf("\n:: pulling docker image: %s\n", tag),
+	policy := opts.PullPolicy
+	if policy == "" {
+		policy = executor.PULL_POLICY_IF_NOT_PRESENT
+	}
+
+	switch {
+	case policy == executor.PULL_POLICY_NEVER:
+		if image == nil {
+			return fmt.Errorf(
+				"image %s is not present locally and the pull policy is %q",
+				tag, policy,
 			)
 		}
 
-		err := docker.PullImage(ctx, tag, opts.OutputConsumer, opts.Auths)
-		if err != nil {
-			return err
-		}
+	case policy == executor.PULL_POLICY_ALWAYS || image == nil:
+		previous := image
 
-		image, err = docker.getImageByTag(ctx, tag)
+		image, err = docker.pull(ctx, tag, opts)
 		if err != nil {
-			return karma.Format(err, "unable to get image after pulling")
+			return err
 		}
 
-		if image == nil {
-			return karma.Format(err, "image not found after pulling")
+		// the registry digest changes only if a new image is pushed while
+		// the ID can be different for the same image pulled from a mirror
+		if previous != nil && opts.InfoConsumer != nil {
+			before := getDigest(tag, previous.Digests)
+			after := getDigest(tag, image.Digests)
+			if before != after {
+				opts.InfoConsumer(
+					fmt.Sprintf(
+						"\n:: Docker image %s is updated: %s -> %s\n",
+						tag, formatNone(before), formatNone(after),
+					),
+				)
+			}
 		}
 	}
 
+	docker.usage.touch(image.ID)
+
 	if opts.InfoConsumer != nil {
 		opts.InfoConsumer(
 			fmt.Sprintf(
-				"\n:: Using docker image: %s @ %s\n",
+				"\n:: Using docker image: %s @ %s%s\n",
 				strings.Join(image.Tags, ", "),
 				image.ID,
+				formatDigest(getDigest(tag, image.Digests)),
 			),
 		)
 	}
@@ -474,6 +703,78 @@ func (docker *Docker) Prepare(
 	return nil
 }
 
+func (docker *Docker) pull(
+	ctx context.Context,
+	tag string,
+	opts executor.PrepareOptions,
+) (*Image, error) {
+	if opts.InfoConsumer != nil {
+		opts.InfoConsumer(
+			fmt.Sprintf("\n:: pulling docker image: %s\n", tag),
+		)
+	}
+
+	err := docker.PullImage(ctx, tag, opts.OutputConsumer, opts.Auths)
+	if err != nil {
+		return nil, err
+	}
+
+	image, err := docker.getImageByTag(ctx, tag)
+	if err != nil {
+		return nil, karma.Format(err, "unable to get image after pulling")
+	}
+
+	if image == nil {
+		return nil, fmt.Errorf("image %s not found after pulling", tag)
+	}
+
+	return image, nil
+}
+
+// getDigest returns the registry digest of the image pulled by the given tag,
+// the digest of the repository of the tag is preferred because the same
+// image can be pulled from several repositories. Images which are built
+// locally have no digests.
+func getDigest(tag string, digests []string) string {
+	repository := tag
+	if index := strings.LastIndex(tag, ":"); index > strings.LastIndex(tag, "/") {
+		repository = tag[:index]
+	}
+
+	for _, digest := range digests {
+		if strings.HasPrefix(digest, repository+"@") {
+			return strings.TrimPrefix(digest, repository+"@")
+		}
+	}
+
+	if len(digests) == 0 {
+		return ""
+	}
+
+	digest := digests[0]
+	if index := strings.LastIndex(digest, "@"); index >= 0 {
+		digest = digest[index+1:]
+	}
+
+	return digest
+}
+
+func formatDigest(digest string) string {
+	if digest == "" {
+		return ""
+	}
+
+	return " (digest: " + digest + ")"
+}
+
+func formatNone(digest string) string {
+	if digest == "" {
+		return "<none>"
+	}
+
+	return digest
+}
+
 type callbackWriter struct {
 	ctx      context.Context
 	callback executor.OutputConsumer
@@ -0,0 +1,25 @@
+package docker
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetDigest(t *testing.T) {
+	test := assert.New(t)
+
+	digests := []string{
+		"mirror.local:5000/golang@sha256:aaa",
+		"golang@sha256:bbb",
+	}
+
+	test.Equal("sha256:bbb", getDigest("golang:1.14", digests))
+	test.Equal("sha256:aaa", getDigest("mirror.local:5000/golang:1.14", digests))
+
+	// digests of other repositories are used if the tag is not found
+	test.Equal("sha256:aaa", getDigest("example/golang:1.14", digests))
+
+	// locally built images have no digests
+	test.Equal("", getDigest("golang:1.14", nil))
+}
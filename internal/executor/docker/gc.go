@@ -0,0 +1,372 @@
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	docker_types "github.com/docker/docker/api/types"
+	"github.com/reconquest/karma-go"
+	"github.com/reconquest/pkg/log"
+)
+
+// IMAGE_GC_MIN_UNUSED protects recently used images from removal when the
+// disk is almost full, such images are likely to be used by jobs which are
+// starting right now.
+const IMAGE_GC_MIN_UNUSED = time.Hour
+
+type ImageGCOptions struct {
+	Interval time.Duration
+
+	// MaxUnused is how long an image can be unused before it's removed.
+	MaxUnused time.Duration
+
+	// DiskThreshold is usage of the disk with the docker root dir in
+	// percents, the least recently used images are removed while usage is
+	// above the threshold. Zero disables the check, it's also disabled if
+	// the root dir is not accessible by the runner.
+	DiskThreshold int
+
+	// Keep is a list of image patterns which are never removed, a pattern
+	// without a tag matches all tags of the image.
+	Keep []string
+
+	// StatePath is the file where times of image usage are saved, so they
+	// survive runner restarts.
+	StatePath string
+}
+
+// imageUsage keeps when images were used by the runner the last time, images
+// are identified by their IDs. Images which are not recorded are not managed
+// by the runner and must never be removed.
+type imageUsage struct {
+	mutex sync.Mutex
+	used  map[string]time.Time
+}
+
+func newImageUsage() *imageUsage {
+	return &imageUsage{used: map[string]time.Time{}}
+}
+
+func (usage *imageUsage) touch(id string) {
+	usage.mutex.Lock()
+	defer usage.mutex.Unlock()
+
+	usage.used[id] = time.Now()
+}
+
+// get returns when the image was used the last time, false is returned if
+// the image has never been used by the runner.
+func (usage *imageUsage) get(id string) (time.Time, bool) {
+	usage.mutex.Lock()
+	defer usage.mutex.Unlock()
+
+	used, ok := usage.used[id]
+
+	return used, ok
+}
+
+// isUsedSince returns true if the image has been used after the given time.
+func (usage *imageUsage) isUsedSince(id string, since time.Time) bool {
+	used, ok := usage.get(id)
+
+	return ok && used.After(since)
+}
+
+// retain forgets images which are not present anymore.
+func (usage *imageUsage) retain(ids map[string]struct{}) {
+	usage.mutex.Lock()
+	defer usage.mutex.Unlock()
+
+	for id := range usage.used {
+		if _, ok := ids[id]; !ok {
+			delete(usage.used, id)
+		}
+	}
+}
+
+func (usage *imageUsage) load(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+
+		return err
+	}
+
+	usage.mutex.Lock()
+	defer usage.mutex.Unlock()
+
+	return json.Unmarshal(data, &usage.used)
+}
+
+func (usage *imageUsage) save(path string) error {
+	usage.mutex.Lock()
+	data, err := json.Marshal(usage.used)
+	usage.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), 0o755)
+	if err != nil {
+		return err
+	}
+
+	temp := path + ".tmp"
+
+	err = ioutil.WriteFile(temp, data, 0o644)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(temp, path)
+}
+
+type gcImage struct {
+	id       string
+	tags     []string
+	lastUsed time.Time
+}
+
+// RunImageGC removes unused images periodically until the context is done.
+func (docker *Docker) RunImageGC(ctx context.Context, opts ImageGCOptions) {
+	err := docker.usage.load(opts.StatePath)
+	if err != nil {
+		log.Errorf(
+			karma.Describe("path", opts.StatePath).Reason(err),
+			"image gc: unable to load image usage, starting from scratch",
+		)
+	}
+
+	if opts.DiskThreshold > 0 && !docker.isRootDirLocal() {
+		log.Warningf(
+			nil,
+			"image gc: disk threshold is disabled because docker root dir %q "+
+				"of %s is not accessible by the runner",
+			docker.rootDir, docker.client.DaemonHost(),
+		)
+
+		opts.DiskThreshold = 0
+	}
+
+	for {
+		err := docker.collectImages(ctx, opts)
+		if err != nil {
+			log.Errorf(err, "image gc: unable to remove unused images")
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(opts.Interval):
+		}
+	}
+}
+
+func (docker *Docker) collectImages(ctx context.Context, opts ImageGCOptions) error {
+	images, err := docker.ListImages(ctx)
+	if err != nil {
+		return karma.Format(err, "unable to list images")
+	}
+
+	// images of existing containers can't be removed anyway
+	containers, err := docker.client.ContainerList(
+		ctx,
+		docker_types.ContainerListOptions{All: true},
+	)
+	if err != nil {
+		return karma.Format(err, "unable to list containers")
+	}
+
+	used := map[string]struct{}{}
+	for _, container := range containers {
+		used[container.ImageID] = struct{}{}
+	}
+
+	now := time.Now()
+
+	present := map[string]struct{}{}
+	candidates := []gcImage{}
+	for _, image := range images {
+		present[image.ID] = struct{}{}
+
+		lastUsed, ok := docker.usage.get(image.ID)
+		if !ok {
+			continue
+		}
+
+		if _, ok := used[image.ID]; ok {
+			continue
+		}
+
+		if isKeptImage(image.RepoTags, opts.Keep) {
+			continue
+		}
+
+		candidates = append(candidates, gcImage{
+			id:       image.ID,
+			tags:     image.RepoTags,
+			lastUsed: lastUsed,
+		})
+	}
+
+	docker.usage.retain(present)
+
+	expired, evictable := planImageRemoval(candidates, now, opts.MaxUnused)
+
+	for _, image := range expired {
+		docker.removeImage(ctx, image, now, "unused for too long")
+	}
+
+	if opts.DiskThreshold > 0 && len(evictable) > 0 {
+		for _, image := range evictable {
+			usage, err := getDiskUsage(docker.rootDir)
+			if err != nil {
+				return karma.
+					Describe("dir", docker.rootDir).
+					Format(err, "unable to get disk usage")
+			}
+
+			if usage <= opts.DiskThreshold {
+				break
+			}
+
+			docker.removeImage(
+				ctx,
+				image,
+				now,
+				fmt.Sprintf(
+					"disk usage %d%% is above the threshold %d%%",
+					usage, opts.DiskThreshold,
+				),
+			)
+		}
+	}
+
+	err = docker.usage.save(opts.StatePath)
+	if err != nil {
+		return karma.
+			Describe("path", opts.StatePath).
+			Format(err, "unable to save image usage")
+	}
+
+	return nil
+}
+
+func (docker *Docker) removeImage(
+	ctx context.Context,
+	image gcImage,
+	now time.Time,
+	reason string,
+) {
+	// the list of images is a snapshot, a job could start using the image
+	// after it has been taken
+	if docker.usage.isUsedSince(image.id, image.lastUsed) {
+		log.Debugf(nil, "image gc: image %s is used again, skipping", image.id)
+
+		return
+	}
+
+	_, err := docker.client.ImageRemove(
+		ctx,
+		image.id,
+		docker_types.ImageRemoveOptions{PruneChildren: true},
+	)
+	if err != nil {
+		log.Errorf(
+			karma.Describe("id", image.id).Describe("tags", image.tags).Reason(err),
+			"image gc: unable to remove image",
+		)
+
+		return
+	}
+
+	log.Infof(
+		nil,
+		"image gc: removed image %s %v, last used %v ago: %s",
+		image.id,
+		image.tags,
+		now.Sub(image.lastUsed).Truncate(time.Minute),
+		reason,
+	)
+}
+
+// isRootDirLocal returns true if the docker root dir is on the same host as
+// the runner, so its disk usage can be checked. It's not the case for remote
+// daemons and for runners in containers without the root dir mounted.
+func (docker *Docker) isRootDirLocal() bool {
+	if docker.rootDir == "" || !isLocalDaemon(docker.client.DaemonHost()) {
+		return false
+	}
+
+	_, err := os.Stat(docker.rootDir)
+
+	return err == nil
+}
+
+func isLocalDaemon(host string) bool {
+	return strings.HasPrefix(host, "unix://") ||
+		strings.HasPrefix(host, "npipe://")
+}
+
+// planImageRemoval returns images which are unused for longer than maxUnused
+// and images which can be removed if the disk is almost full, the latter are
+// ordered from the least recently used.
+func planImageRemoval(
+	images []gcImage,
+	now time.Time,
+	maxUnused time.Duration,
+) ([]gcImage, []gcImage) {
+	sorted := append([]gcImage{}, images...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].lastUsed.Before(sorted[j].lastUsed)
+	})
+
+	expired := []gcImage{}
+	evictable := []gcImage{}
+	for _, image := range sorted {
+		unused := now.Sub(image.lastUsed)
+
+		switch {
+		case maxUnused > 0 && unused >= maxUnused:
+			expired = append(expired, image)
+		case unused >= IMAGE_GC_MIN_UNUSED:
+			evictable = append(evictable, image)
+		}
+	}
+
+	return expired, evictable
+}
+
+// isKeptImage returns true if any tag of the image matches any of the
+// patterns, a pattern without a tag matches all tags of the image.
+func isKeptImage(tags []string, patterns []string) bool {
+	for _, tag := range tags {
+		name := tag
+		if index := strings.LastIndex(tag, ":"); index > strings.LastIndex(tag, "/") {
+			name = tag[:index]
+		}
+
+		for _, pattern := range patterns {
+			if matched, _ := path.Match(pattern, tag); matched {
+				return true
+			}
+
+			if matched, _ := path.Match(pattern, name); matched {
+				return true
+			}
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,111 @@
+package docker
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPlanImageRemoval(t *testing.T) {
+	test := assert.New(t)
+
+	now := time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)
+	day := 24 * time.Hour
+
+	images := []gcImage{
+		{id: "recent", lastUsed: now.Add(-time.Minute)},
+		{id: "week", lastUsed: now.Add(-8 * day)},
+		{id: "day", lastUsed: now.Add(-day)},
+		{id: "month", lastUsed: now.Add(-30 * day)},
+		{id: "hours", lastUsed: now.Add(-2 * time.Hour)},
+	}
+
+	ids := func(images []gcImage) []string {
+		result := []string{}
+		for _, image := range images {
+			result = append(result, image.id)
+		}
+
+		return result
+	}
+
+	expired, evictable := planImageRemoval(images, now, 7*day)
+	test.Equal([]string{"month", "week"}, ids(expired))
+	test.Equal([]string{"day", "hours"}, ids(evictable))
+
+	// only the disk threshold is used if max unused is not specified
+	expired, evictable = planImageRemoval(images, now, 0)
+	test.Empty(expired)
+	test.Equal([]string{"month", "week", "day", "hours"}, ids(evictable))
+}
+
+func TestIsKeptImage(t *testing.T) {
+	test := assert.New(t)
+
+	patterns := []string{
+		"reconquest/snake-runner-sidecar",
+		"golang:1.*",
+		"registry:5000/base/*",
+	}
+
+	test.True(isKeptImage([]string{"reconquest/snake-runner-sidecar:latest"}, patterns))
+	test.True(isKeptImage([]string{"alpine:3.12", "golang:1.14"}, patterns))
+	test.True(isKeptImage([]string{"registry:5000/base/go:1"}, patterns))
+	test.False(isKeptImage([]string{"golang:latest"}, patterns))
+	test.False(isKeptImage([]string{"registry:5000/other:1"}, patterns))
+	test.False(isKeptImage([]string{"<none>:<none>"}, patterns))
+	test.False(isKeptImage(nil, patterns))
+}
+
+func TestImageUsage_SaveLoad(t *testing.T) {
+	test := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "snake-runner-test.*")
+	test.NoError(err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "images.json")
+
+	usage := newImageUsage()
+	test.NoError(usage.load(path))
+
+	usage.touch("a")
+	usage.touch("b")
+
+	used, ok := usage.get("a")
+	test.True(ok)
+
+	// images which have never been used by the runner are not managed by it
+	_, ok = usage.get("c")
+	test.False(ok)
+
+	usage.retain(map[string]struct{}{"a": {}})
+	test.NoError(usage.save(path))
+
+	loaded := newImageUsage()
+	test.NoError(loaded.load(path))
+
+	loadedUsed, ok := loaded.get("a")
+	test.True(ok)
+	test.True(used.Equal(loadedUsed))
+
+	_, ok = loaded.get("b")
+	test.False(ok)
+
+	test.False(loaded.isUsedSince("a", used))
+	test.True(loaded.isUsedSince("a", used.Add(-time.Second)))
+	test.False(loaded.isUsedSince("c", used))
+}
+
+func TestIsLocalDaemon(t *testing.T) {
+	test := assert.New(t)
+
+	test.True(isLocalDaemon("unix:///var/run/docker.sock"))
+	test.True(isLocalDaemon("npipe:////./pipe/docker_engine"))
+	test.False(isLocalDaemon("tcp://docker:2375"))
+	test.False(isLocalDaemon("ssh://build@docker"))
+}
@@ -0,0 +1,316 @@
+package docker
+
+import (
+	"context"
+	"time"
+
+	docker_types "github.com/docker/docker/api/types"
+	docker_container "github.com/docker/docker/api/types/container"
+	docker_filters "github.com/docker/docker/api/types/filters"
+	docker_network "github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/reconquest/karma-go"
+	"github.com/reconquest/pkg/log"
+	"github.com/reconquest/snake-runner/internal/executor"
+)
+
+const (
+	SERVICE_POLL_INTERVAL = 500 * time.Millisecond
+	SERVICE_DIAL_TIMEOUT  = time.Second
+
+	SERVICE_HEALTHCHECK_INTERVAL     = time.Second
+	SERVICE_HEALTHCHECK_TIMEOUT      = 5 * time.Second
+	SERVICE_HEALTHCHECK_START_PERIOD = time.Minute
+)
+
+var _ executor.ServiceExecutor = (*Docker)(nil)
+
+type Network struct {
+	id   string
+	name string
+}
+
+func (network Network) ID() string {
+	return network.id
+}
+
+func (network Network) String() string {
+	return network.name
+}
+
+func (docker *Docker) CreateNetwork(
+	ctx context.Context,
+	name string,
+) (executor.Network, error) {
+	created, err := docker.client.NetworkCreate(
+		ctx,
+		name,
+		docker_types.NetworkCreate{
+			CheckDuplicate: true,
+			Labels: map[string]string{
+				IMAGE_LABEL_KEY: "true",
+			},
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return Network{id: created.ID, name: name}, nil
+}
+
+func (docker *Docker) DestroyNetwork(
+	ctx context.Context,
+	network executor.Network,
+) error {
+	if network == nil {
+		return nil
+	}
+
+	return docker.client.NetworkRemove(ctx, network.ID())
+}
+
+// CreateService starts the service container in the given network, the
+// service is reachable in the network by its alias.
+func (docker *Docker) CreateService(
+	ctx context.Context,
+	opts executor.ServiceOptions,
+) (executor.Container, error) {
+	config := &docker_container.Config{
+		Image: opts.Image,
+		Labels: map[string]string{
+			IMAGE_LABEL_KEY: "true",
+		},
+		Env: opts.Env,
+		Cmd: opts.Cmd,
+	}
+
+	// failures during the start period are not counted, so the service
+	// becomes unhealthy only if it doesn't start in time
+	if len(opts.Healthcheck) > 0 {
+		config.Healthcheck = &docker_container.HealthConfig{
+			Test:        append([]string{"CMD"}, opts.Healthcheck...),
+			Interval:    SERVICE_HEALTHCHECK_INTERVAL,
+			Timeout:     SERVICE_HEALTHCHECK_TIMEOUT,
+			StartPeriod: SERVICE_HEALTHCHECK_START_PERIOD,
+		}
+	}
+
+	hostConfig := &docker_container.HostConfig{
+		NetworkMode: docker_container.NetworkMode(opts.Network.ID()),
+		Resources:   getResources(opts.Resources),
+		ShmSize:     opts.Resources.ShmSize,
+		Privileged:  opts.Privileged,
+	}
+
+	networkingConfig := &docker_network.NetworkingConfig{
+		EndpointsConfig: map[string]*docker_network.EndpointSettings{
+			opts.Network.ID(): {
+				Aliases: []string{opts.Alias},
+			},
+		},
+	}
+
+	created, err := docker.client.ContainerCreate(
+		ctx, config,
+		hostConfig, networkingConfig, opts.Name,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	container := Container{id: created.ID, name: opts.Name}
+
+	err = docker.client.ContainerStart(
+		ctx, created.ID,
+		docker_types.ContainerStartOptions{},
+	)
+	if err != nil {
+		return container, karma.Format(
+			err,
+			"unable to start service container",
+		)
+	}
+
+	return container, nil
+}
+
+// WaitService waits until the service container is healthy if it has a
+// healthcheck, otherwise until all exposed tcp ports accept connections from
+// the probe container.
+func (docker *Docker) WaitService(
+	ctx context.Context,
+	container executor.Container,
+	opts executor.WaitServiceOptions,
+) error {
+	ctx, cancel := context.WithTimeout(ctx, opts.Timeout)
+	defer cancel()
+
+	for {
+		ready, err := docker.isServiceReady(ctx, container, opts.Probe)
+		if err != nil {
+			return err
+		}
+
+		if ready {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return karma.Format(
+				ctx.Err(),
+				"service is not ready after %s", opts.Timeout,
+			)
+		case <-time.After(SERVICE_POLL_INTERVAL):
+		}
+	}
+}
+
+func (docker *Docker) isServiceReady(
+	ctx context.Context,
+	container executor.Container,
+	probe executor.Container,
+) (bool, error) {
+	info, err := docker.client.ContainerInspect(ctx, container.ID())
+	if err != nil {
+		return false, karma.Format(err, "unable to inspect service container")
+	}
+
+	if !info.State.Running {
+		return false, karma.
+			Describe("exitcode", info.State.ExitCode).
+			Format(nil, "service container is not running: %s", info.State.Status)
+	}
+
+	if info.State.Health != nil {
+		switch info.State.Health.Status {
+		case docker_types.Healthy:
+			return true, nil
+		case docker_types.Unhealthy:
+			return false, karma.Format(nil, "service container is unhealthy")
+		default:
+			return false, nil
+		}
+	}
+
+	var address string
+	for _, endpoint := range info.NetworkSettings.Networks {
+		if endpoint.IPAddress != "" {
+			address = endpoint.IPAddress
+			break
+		}
+	}
+
+	if address == "" {
+		return false, nil
+	}
+
+	for port := range info.Config.ExposedPorts {
+		if port.Proto() != "tcp" {
+			continue
+		}
+
+		if !docker.isPortOpen(ctx, probe, address, port.Port()) {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+// isPortOpen connects to the port from the probe container, so the port is
+// checked in the network of the service.
+func (docker *Docker) isPortOpen(
+	ctx context.Context,
+	probe executor.Container,
+	address string,
+	port string,
+) bool {
+	ctx, cancel := context.WithTimeout(ctx, SERVICE_DIAL_TIMEOUT)
+	defer cancel()
+
+	err := docker.Exec(ctx, probe, executor.ExecOptions{
+		Cmd: []string{"bash", "-c", `exec 3<>"/dev/tcp/$0/$1"`, address, port},
+	})
+
+	return err == nil
+}
+
+// ServiceLogs writes stdout and stderr of the service container to the given
+// consumer.
+func (docker *Docker) ServiceLogs(
+	ctx context.Context,
+	container executor.Container,
+	consumer executor.OutputConsumer,
+) error {
+	reader, err := docker.client.ContainerLogs(
+		ctx,
+		container.ID(),
+		docker_types.ContainerLogsOptions{
+			ShowStdout: true,
+			ShowStderr: true,
+		},
+	)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	writer := callbackWriter{ctx: ctx, callback: consumer}
+
+	_, err = stdcopy.StdCopy(writer, writer, reader)
+	if err != nil {
+		return karma.Format(err, "unable to read logs of service container")
+	}
+
+	return nil
+}
+
+func (docker *Docker) cleanupNetworks() error {
+	networks, err := docker.client.NetworkList(
+		context.Background(),
+		docker_types.NetworkListOptions{
+			Filters: docker_filters.NewArgs(
+				docker_filters.Arg("label", IMAGE_LABEL_KEY),
+			),
+		},
+	)
+	if err != nil {
+		return karma.Format(
+			err,
+			"unable to list networks",
+		)
+	}
+
+	destroyed := 0
+	for _, network := range networks {
+		log.Infof(
+			nil,
+			"cleanup: destroying network %q %q",
+			network.ID,
+			network.Name,
+		)
+
+		err := docker.DestroyNetwork(
+			context.Background(),
+			Network{id: network.ID, name: network.Name},
+		)
+		if err != nil {
+			log.Errorf(
+				karma.
+					Describe("id", network.ID).
+					Describe("name", network.Name).
+					Reason(err),
+				"unable to destroy network",
+			)
+			continue
+		}
+
+		destroyed++
+	}
+
+	log.Infof(nil, "cleanup: destroyed %d networks", destroyed)
+
+	return nil
+}
@@ -3,13 +3,33 @@ package executor
 import (
 	"context"
 	"io"
+	"time"
+
+	"github.com/reconquest/snake-runner/internal/sshkey"
 )
 
 type ExecutorType string
 
 const (
 	EXECUTOR_DOCKER ExecutorType = "EXECUTOR_DOCKER"
+	EXECUTOR_PODMAN ExecutorType = "EXECUTOR_PODMAN"
 	EXECUTOR_SHELL  ExecutorType = "EXECUTOR_SHELL"
+	EXECUTOR_SSH    ExecutorType = "EXECUTOR_SSH"
+)
+
+// Pull policies define when images are pulled from registries.
+const (
+	// PULL_POLICY_ALWAYS pulls the image every time, so mutable tags such as
+	// latest are kept up to date.
+	PULL_POLICY_ALWAYS = "always"
+
+	// PULL_POLICY_IF_NOT_PRESENT pulls the image only if there is no local
+	// image with the same tag.
+	PULL_POLICY_IF_NOT_PRESENT = "if-not-present"
+
+	// PULL_POLICY_NEVER uses only local images, it's useful for hosts
+	// without access to registries.
+	PULL_POLICY_NEVER = "never"
 )
 
 type Executor interface {
@@ -28,6 +48,30 @@ type Container interface {
 	ID() string
 }
 
+// ServiceExecutor is implemented by executors which are able to run service
+// containers next to the job container in a private network.
+type ServiceExecutor interface {
+	CreateNetwork(context.Context, string) (Network, error)
+	DestroyNetwork(context.Context, Network) error
+	CreateService(context.Context, ServiceOptions) (Container, error)
+	WaitService(context.Context, Container, WaitServiceOptions) error
+	ServiceLogs(context.Context, Container, OutputConsumer) error
+}
+
+// HostPool is implemented by executors which run containers on one of
+// several hosts. Containers of a pipeline share directories of the sidecar,
+// so all of them must run on the same host.
+type HostPool interface {
+	// Acquire returns the executor of the least busy host, release must be
+	// called when the pipeline is finished.
+	Acquire() (executor Executor, release func())
+}
+
+type Network interface {
+	String() string
+	ID() string
+}
+
 type (
 	Volume string
 )
@@ -43,6 +87,54 @@ type CreateOptions struct {
 	Name    string
 	Image   string
 	Volumes []Volume
+
+	// ChownVolumes makes bind mounts owned by the user of the container if
+	// the executor maps users of containers, so images running as non-root
+	// users are able to write into the repository
+	ChownVolumes bool
+
+	// Networks the container is attached to, the first one is the primary
+	// network of the container. Nil networks are skipped.
+	Networks []Network
+
+	// Alias is the DNS name of the container in its networks
+	Alias string
+
+	Resources  Resources
+	Privileges Privileges
+
+	// SshKey is added to the ssh-agent which is forwarded to the container
+	// by executors which run containers on remote hosts, so the private key
+	// never leaves the runner
+	SshKey *sshkey.Key
+}
+
+type ServiceOptions struct {
+	Name    string
+	Image   string
+	Alias   string
+	Network Network
+	Env     []string
+	Cmd     []string
+
+	Resources Resources
+
+	// Privileged is required by services like docker in docker
+	Privileged bool
+
+	// Healthcheck is a command which checks that the service is ready, by
+	// default the service is ready when its exposed ports accept connections
+	Healthcheck []string
+}
+
+type WaitServiceOptions struct {
+	Timeout time.Duration
+
+	// Probe is the container in the network of the service which checks
+	// that ports of the service accept connections, it must have bash. The
+	// runner can't reach the network itself if it's running in a container
+	// or the daemon is remote.
+	Probe Container
 }
 
 type ExecOptions struct {
@@ -60,6 +152,10 @@ type PrepareOptions struct {
 	OutputConsumer OutputConsumer
 	InfoConsumer   OutputConsumer
 	Auths          []Auths
+
+	// PullPolicy is one of PULL_POLICY_* constants, the image is pulled only
+	// if it's not present if the policy is not specified.
+	PullPolicy string
 }
 
 type Auths map[string]AuthConfig
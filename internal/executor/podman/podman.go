@@ -0,0 +1,140 @@
+package podman
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/reconquest/karma-go"
+	"github.com/reconquest/snake-runner/internal/executor"
+	"github.com/reconquest/snake-runner/internal/executor/docker"
+)
+
+var (
+	_ executor.Executor        = (*Podman)(nil)
+	_ executor.ServiceExecutor = (*Podman)(nil)
+)
+
+// Podman runs containers with Podman through the Docker compatible REST API
+// of the Podman socket, so hosts which allow only rootless Podman can run
+// pipelines in containers as well.
+type Podman struct {
+	*docker.Docker
+
+	host string
+}
+
+func NewPodman(host string, network string, volumes []string) *Podman {
+	return &Podman{
+		Docker: docker.NewDocker(network, volumes),
+		host:   host,
+	}
+}
+
+func (podman *Podman) Connect() error {
+	return podman.Docker.ConnectHost(podman.host)
+}
+
+func (podman *Podman) Type() executor.ExecutorType {
+	return executor.EXECUTOR_PODMAN
+}
+
+// Create creates directories of bind mounts before the container is created
+// because unlike Docker, Podman doesn't create missing directories. The
+// directories are owned by the runner user which is root in user namespaces
+// of rootless containers, so the sidecar is able to write into them.
+//
+// Bind mounts are relabeled with the shared SELinux label because the
+// sidecar and containers of jobs use the same directories. Bind mounts of
+// jobs are chowned to the user of the container if the image runs as
+// non-root user which is mapped to a subordinate uid in rootless Podman.
+func (podman *Podman) Create(
+	ctx context.Context,
+	opts executor.CreateOptions,
+) (executor.Container, error) {
+	if podman.isLocal() {
+		for _, volume := range opts.Volumes {
+			err := createBindDir(volume)
+			if err != nil {
+				return nil, karma.
+					Describe("volume", volume).
+					Format(err, "unable to create directory of bind mount")
+			}
+		}
+	}
+
+	opts.Volumes = getBindVolumes(opts.Volumes, opts.ChownVolumes)
+
+	return podman.Docker.Create(ctx, opts)
+}
+
+// isLocal returns true if the Podman service is running on the same host,
+// directories can't be created for remote services.
+func (podman *Podman) isLocal() bool {
+	return strings.HasPrefix(podman.host, "unix://")
+}
+
+// createBindDir creates the source directory of the bind mount if it doesn't
+// exist, named volumes and files are skipped.
+func createBindDir(volume executor.Volume) error {
+	source := strings.SplitN(string(volume), ":", 2)[0]
+	if !filepath.IsAbs(source) {
+		return nil
+	}
+
+	_, err := os.Stat(source)
+	if err == nil || !os.IsNotExist(err) {
+		return err
+	}
+
+	return os.MkdirAll(source, 0o755)
+}
+
+// getBindVolumes adds the z option to bind mounts and the U option as well if
+// chown is true, named volumes are returned as is.
+func getBindVolumes(volumes []executor.Volume, chown bool) []executor.Volume {
+	options := []string{"z"}
+	if chown {
+		options = append(options, "U")
+	}
+
+	result := make([]executor.Volume, len(volumes))
+	for i, volume := range volumes {
+		result[i] = withBindOptions(volume, options)
+	}
+
+	return result
+}
+
+func withBindOptions(volume executor.Volume, options []string) executor.Volume {
+	parts := strings.SplitN(string(volume), ":", 3)
+	if len(parts) < 2 || !filepath.IsAbs(parts[0]) {
+		return volume
+	}
+
+	existing := []string{}
+	if len(parts) == 3 && parts[2] != "" {
+		existing = strings.Split(parts[2], ",")
+	}
+
+	for _, option := range options {
+		if !hasOption(existing, option) {
+			existing = append(existing, option)
+		}
+	}
+
+	return executor.Volume(
+		parts[0] + ":" + parts[1] + ":" + strings.Join(existing, ","),
+	)
+}
+
+func hasOption(options []string, option string) bool {
+	for _, item := range options {
+		if item == option {
+			return true
+		}
+	}
+
+	return false
+}
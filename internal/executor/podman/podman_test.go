@@ -0,0 +1,61 @@
+package podman
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/reconquest/snake-runner/internal/executor"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCreateBindDir(t *testing.T) {
+	test := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "snake-runner-test.*")
+	test.NoError(err)
+	defer os.RemoveAll(dir)
+
+	source := filepath.Join(dir, "pipeline-1", "git")
+
+	test.NoError(createBindDir(executor.Volume(source + ":/pipeline:rw")))
+	test.DirExists(source)
+
+	// existing files are mounted as is
+	file := filepath.Join(dir, "known_hosts")
+	test.NoError(ioutil.WriteFile(file, []byte{}, 0o644))
+	test.NoError(createBindDir(executor.Volume(file + ":/known_hosts:ro")))
+	test.FileExists(file)
+
+	// named volumes are created by podman
+	test.NoError(createBindDir(executor.Volume("cache:/cache")))
+	test.NoFileExists("cache")
+}
+
+func TestGetBindVolumes(t *testing.T) {
+	test := assert.New(t)
+
+	volumes := []executor.Volume{
+		"/pipelines/1/git/repo:/pipeline/git/repo",
+		"/pipelines/1/ssh:/pipeline/ssh:ro",
+		"/pipelines/1/cache:/cache:rw,z",
+		"cache:/cache",
+	}
+
+	test.Equal(
+		[]executor.Volume{
+			"/pipelines/1/git/repo:/pipeline/git/repo:z,U",
+			"/pipelines/1/ssh:/pipeline/ssh:ro,z,U",
+			"/pipelines/1/cache:/cache:rw,z,U",
+			"cache:/cache",
+		},
+		getBindVolumes(volumes, true),
+	)
+
+	// the sidecar mounts the pipelines dir, it must not be chowned
+	test.Equal(
+		[]executor.Volume{"/pipelines:/host:rw,z"},
+		getBindVolumes([]executor.Volume{"/pipelines:/host:rw"}, false),
+	)
+}
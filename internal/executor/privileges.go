@@ -0,0 +1,82 @@
+package executor
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Privileges grant a container more permissions than it has by default.
+type Privileges struct {
+	Privileged bool
+	CapAdd     []string
+	CapDrop    []string
+	Devices    []Device
+}
+
+// Merge returns privileges with the other ones added.
+func (privileges Privileges) Merge(other Privileges) Privileges {
+	return Privileges{
+		Privileged: privileges.Privileged || other.Privileged,
+		CapAdd:     append(append([]string{}, privileges.CapAdd...), other.CapAdd...),
+		CapDrop:    append(append([]string{}, privileges.CapDrop...), other.CapDrop...),
+		Devices:    append(append([]Device{}, privileges.Devices...), other.Devices...),
+	}
+}
+
+// IsEmpty returns true if no extra permissions are granted, dropped
+// capabilities are not permissions.
+func (privileges Privileges) IsEmpty() bool {
+	return !privileges.Privileged &&
+		len(privileges.CapAdd) == 0 &&
+		len(privileges.Devices) == 0
+}
+
+// Device is a device of the host which is available in a container.
+type Device struct {
+	PathOnHost        string
+	PathInContainer   string
+	CgroupPermissions string
+}
+
+// ParseDevice parses a device in the docker format:
+// /dev/host[:/dev/container[:permissions]], permissions are rwm by default.
+func ParseDevice(spec string) (Device, error) {
+	parts := strings.Split(spec, ":")
+	if len(parts) > 3 || parts[0] == "" {
+		return Device{}, fmt.Errorf(
+			"a device like /dev/host[:/dev/container[:rwm]] expected but got %q",
+			spec,
+		)
+	}
+
+	device := Device{
+		PathOnHost:        parts[0],
+		PathInContainer:   parts[0],
+		CgroupPermissions: "rwm",
+	}
+
+	if len(parts) > 1 && parts[1] != "" {
+		device.PathInContainer = parts[1]
+	}
+
+	if len(parts) > 2 {
+		if parts[2] == "" || strings.Trim(parts[2], "rwm") != "" {
+			return Device{}, fmt.Errorf(
+				"device permissions must be a combination of r, w and m "+
+					"but got %q",
+				parts[2],
+			)
+		}
+
+		device.CgroupPermissions = parts[2]
+	}
+
+	if !strings.HasPrefix(device.PathOnHost, "/") ||
+		!strings.HasPrefix(device.PathInContainer, "/") {
+		return Device{}, fmt.Errorf(
+			"device paths must be absolute but got %q", spec,
+		)
+	}
+
+	return device, nil
+}
@@ -0,0 +1,57 @@
+package executor
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseDevice(t *testing.T) {
+	test := assert.New(t)
+
+	device, err := ParseDevice("/dev/fuse")
+	test.NoError(err)
+	test.Equal(Device{"/dev/fuse", "/dev/fuse", "rwm"}, device)
+
+	device, err = ParseDevice("/dev/sdb:/dev/disk:r")
+	test.NoError(err)
+	test.Equal(Device{"/dev/sdb", "/dev/disk", "r"}, device)
+
+	device, err = ParseDevice("/dev/sdb::rw")
+	test.NoError(err)
+	test.Equal(Device{"/dev/sdb", "/dev/sdb", "rw"}, device)
+
+	_, err = ParseDevice("/dev/sdb:/dev/disk:rx")
+	test.EqualError(
+		err,
+		`device permissions must be a combination of r, w and m but got "rx"`,
+	)
+
+	_, err = ParseDevice("dev/fuse")
+	test.EqualError(err, `device paths must be absolute but got "dev/fuse"`)
+
+	_, err = ParseDevice("")
+	test.Error(err)
+}
+
+func TestPrivileges_Merge(t *testing.T) {
+	test := assert.New(t)
+
+	runner := Privileges{CapDrop: []string{"NET_RAW"}}
+	test.True(runner.IsEmpty())
+
+	job := Privileges{
+		CapAdd:  []string{"SYS_ADMIN"},
+		Devices: []Device{{"/dev/fuse", "/dev/fuse", "rwm"}},
+	}
+	test.False(job.IsEmpty())
+
+	test.Equal(
+		Privileges{
+			CapAdd:  []string{"SYS_ADMIN"},
+			CapDrop: []string{"NET_RAW"},
+			Devices: []Device{{"/dev/fuse", "/dev/fuse", "rwm"}},
+		},
+		runner.Merge(job),
+	)
+}
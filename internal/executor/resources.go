@@ -0,0 +1,187 @@
+package executor
+
+import (
+	"fmt"
+	"strconv"
+
+	units "github.com/docker/go-units"
+	"github.com/reconquest/karma-go"
+)
+
+// ResourcesSpec is the human readable form of resource limits as they are
+// written in configs, sizes are specified like 512m or 2g.
+type ResourcesSpec struct {
+	CPUs       string `json:"cpus"        yaml:"cpus"`
+	Memory     string `json:"memory"      yaml:"memory"`
+	MemorySwap string `json:"memory_swap" yaml:"memory_swap"`
+	PidsLimit  int64  `json:"pids_limit"  yaml:"pids_limit"`
+	ShmSize    string `json:"shm_size"    yaml:"shm_size"`
+}
+
+// Resources limit resources of a container, zero values mean that the limit
+// is not set. Sizes are in bytes, MemorySwap is -1 for unlimited swap.
+type Resources struct {
+	NanoCPUs   int64
+	Memory     int64
+	MemorySwap int64
+	PidsLimit  int64
+	ShmSize    int64
+}
+
+// Parse converts the spec to resources, empty values are left unlimited.
+// Limits are not validated against each other because they can be merged
+// with other limits later.
+func (spec ResourcesSpec) Parse() (Resources, error) {
+	var resources Resources
+
+	if spec.CPUs != "" {
+		cpus, err := strconv.ParseFloat(spec.CPUs, 64)
+		if err != nil || cpus <= 0 {
+			return resources, fmt.Errorf(
+				"cpus: a positive number expected but got %q", spec.CPUs,
+			)
+		}
+
+		resources.NanoCPUs = int64(cpus * 1e9)
+	}
+
+	sizes := []struct {
+		name   string
+		value  string
+		target *int64
+	}{
+		{"memory", spec.Memory, &resources.Memory},
+		{"memory_swap", spec.MemorySwap, &resources.MemorySwap},
+		{"shm_size", spec.ShmSize, &resources.ShmSize},
+	}
+
+	for _, size := range sizes {
+		if size.value == "" {
+			continue
+		}
+
+		if size.name == "memory_swap" && size.value == "-1" {
+			*size.target = -1
+			continue
+		}
+
+		value, err := units.RAMInBytes(size.value)
+		if err != nil || value <= 0 {
+			return resources, fmt.Errorf(
+				"%s: a size like 512m or 2g expected but got %q",
+				size.name, size.value,
+			)
+		}
+
+		*size.target = value
+	}
+
+	if spec.PidsLimit < 0 {
+		return resources, fmt.Errorf(
+			"pids_limit: a positive number expected but got %d", spec.PidsLimit,
+		)
+	}
+
+	resources.PidsLimit = spec.PidsLimit
+
+	return resources, nil
+}
+
+// Validate checks that limits are consistent with each other, docker
+// refuses to create a container with swap limit less than memory limit.
+func (resources Resources) Validate() error {
+	if resources.MemorySwap > 0 {
+		if resources.Memory == 0 {
+			return fmt.Errorf("memory_swap can't be specified without memory")
+		}
+
+		if resources.MemorySwap < resources.Memory {
+			return fmt.Errorf(
+				"memory_swap %s must not be less than memory %s",
+				formatSize(resources.MemorySwap),
+				formatSize(resources.Memory),
+			)
+		}
+	}
+
+	return nil
+}
+
+// Merge returns the resources with limits which are set in override
+// replaced.
+func (resources Resources) Merge(override Resources) Resources {
+	if override.NanoCPUs != 0 {
+		resources.NanoCPUs = override.NanoCPUs
+	}
+
+	if override.Memory != 0 {
+		resources.Memory = override.Memory
+	}
+
+	if override.MemorySwap != 0 {
+		resources.MemorySwap = override.MemorySwap
+	}
+
+	if override.PidsLimit != 0 {
+		resources.PidsLimit = override.PidsLimit
+	}
+
+	if override.ShmSize != 0 {
+		resources.ShmSize = override.ShmSize
+	}
+
+	return resources
+}
+
+// CheckLimits returns an error if any of the resources is above the maximum
+// or is not limited while the maximum is set.
+func (resources Resources) CheckLimits(max Resources) error {
+	limits := []struct {
+		name   string
+		value  int64
+		max    int64
+		format func(int64) string
+	}{
+		{"cpus", resources.NanoCPUs, max.NanoCPUs, formatCPUs},
+		{"memory", resources.Memory, max.Memory, formatSize},
+		{"memory_swap", resources.MemorySwap, max.MemorySwap, formatSize},
+		{"pids_limit", resources.PidsLimit, max.PidsLimit, formatNumber},
+		{"shm_size", resources.ShmSize, max.ShmSize, formatSize},
+	}
+
+	for _, limit := range limits {
+		if limit.max == 0 {
+			continue
+		}
+
+		if limit.value <= 0 {
+			return karma.
+				Describe("max", limit.format(limit.max)).
+				Format(nil, "%s must be limited on this runner", limit.name)
+		}
+
+		if limit.value > limit.max {
+			return karma.
+				Describe("max", limit.format(limit.max)).
+				Format(
+					nil,
+					"%s %s is above the maximum of this runner",
+					limit.name, limit.format(limit.value),
+				)
+		}
+	}
+
+	return nil
+}
+
+func formatCPUs(value int64) string {
+	return strconv.FormatFloat(float64(value)/1e9, 'f', -1, 64)
+}
+
+func formatSize(value int64) string {
+	return units.BytesSize(float64(value))
+}
+
+func formatNumber(value int64) string {
+	return strconv.FormatInt(value, 10)
+}
@@ -0,0 +1,78 @@
+package executor
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResourcesSpec_Parse(t *testing.T) {
+	test := assert.New(t)
+
+	resources, err := ResourcesSpec{
+		CPUs:       "1.5",
+		Memory:     "512m",
+		MemorySwap: "-1",
+		PidsLimit:  100,
+		ShmSize:    "64m",
+	}.Parse()
+	test.NoError(err)
+	test.Equal(
+		Resources{
+			NanoCPUs:   1500000000,
+			Memory:     512 * 1024 * 1024,
+			MemorySwap: -1,
+			PidsLimit:  100,
+			ShmSize:    64 * 1024 * 1024,
+		},
+		resources,
+	)
+
+	resources, err = ResourcesSpec{}.Parse()
+	test.NoError(err)
+	test.Equal(Resources{}, resources)
+
+	_, err = ResourcesSpec{CPUs: "0"}.Parse()
+	test.EqualError(err, `cpus: a positive number expected but got "0"`)
+
+	_, err = ResourcesSpec{ShmSize: "-1"}.Parse()
+	test.EqualError(err, `shm_size: a size like 512m or 2g expected but got "-1"`)
+}
+
+func TestResources_CheckLimits(t *testing.T) {
+	test := assert.New(t)
+
+	max := Resources{NanoCPUs: 2000000000, Memory: 1024 * 1024 * 1024}
+
+	defaults := Resources{NanoCPUs: 1000000000, Memory: 256 * 1024 * 1024}
+	test.NoError(defaults.CheckLimits(max))
+
+	requested := defaults.Merge(Resources{Memory: 2 * 1024 * 1024 * 1024})
+	test.Equal(int64(1000000000), requested.NanoCPUs)
+	test.EqualError(
+		requested.CheckLimits(max),
+		"memory 2GiB is above the maximum of this runner\n└─ max: 1GiB",
+	)
+
+	test.EqualError(
+		Resources{Memory: 1024}.CheckLimits(max),
+		"cpus must be limited on this runner\n└─ max: 2",
+	)
+
+	test.NoError(Resources{PidsLimit: 10}.CheckLimits(Resources{}))
+}
+
+func TestResources_Validate(t *testing.T) {
+	test := assert.New(t)
+
+	test.NoError(Resources{Memory: 1024, MemorySwap: 2048}.Validate())
+	test.NoError(Resources{Memory: 1024, MemorySwap: -1}.Validate())
+	test.EqualError(
+		Resources{Memory: 2048, MemorySwap: 1024}.Validate(),
+		"memory_swap 1KiB must not be less than memory 2KiB",
+	)
+	test.EqualError(
+		Resources{MemorySwap: 1024}.Validate(),
+		"memory_swap can't be specified without memory",
+	)
+}
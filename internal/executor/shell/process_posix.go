@@ -0,0 +1,86 @@
+// +build !windows
+
+package shell
+
+import (
+	"os/exec"
+	"os/user"
+	"strconv"
+	"syscall"
+
+	"github.com/reconquest/karma-go"
+)
+
+// setProcessAttributes starts the command in its own process group, so the
+// command can be killed along with all its children, and switches the user
+// if it's specified.
+func setProcessAttributes(cmd *exec.Cmd, user *User) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
+	if user != nil {
+		cmd.SysProcAttr.Credential = &syscall.Credential{
+			Uid:    user.Uid,
+			Gid:    user.Gid,
+			Groups: user.Groups,
+		}
+	}
+}
+
+// killProcessGroup kills all processes of the group, processes which have
+// left the group such as daemons are not killed.
+func killProcessGroup(pid int) error {
+	err := syscall.Kill(-pid, syscall.SIGKILL)
+	if err == syscall.ESRCH {
+		return nil
+	}
+
+	return err
+}
+
+// isProcessGroupAlive returns true if any process of the group is running.
+func isProcessGroupAlive(pid int) bool {
+	return syscall.Kill(-pid, 0) != syscall.ESRCH
+}
+
+func lookupUser(name string) (*User, error) {
+	found, err := user.Lookup(name)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &User{
+		Name:    found.Username,
+		HomeDir: found.HomeDir,
+	}
+
+	result.Uid, err = parseID(found.Uid)
+	if err != nil {
+		return nil, karma.Format(err, "unable to parse uid")
+	}
+
+	result.Gid, err = parseID(found.Gid)
+	if err != nil {
+		return nil, karma.Format(err, "unable to parse gid")
+	}
+
+	groups, err := found.GroupIds()
+	if err != nil {
+		return nil, karma.Format(err, "unable to obtain groups of user")
+	}
+
+	for _, group := range groups {
+		id, err := parseID(group)
+		if err != nil {
+			return nil, karma.Format(err, "unable to parse group id")
+		}
+
+		result.Groups = append(result.Groups, id)
+	}
+
+	return result, nil
+}
+
+func parseID(id string) (uint32, error) {
+	value, err := strconv.ParseUint(id, 10, 32)
+	return uint32(value), err
+}
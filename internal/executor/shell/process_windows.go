@@ -0,0 +1,29 @@
+package shell
+
+import (
+	"errors"
+	"os/exec"
+	"strconv"
+	"syscall"
+)
+
+func setProcessAttributes(cmd *exec.Cmd, user *User) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		CreationFlags: syscall.CREATE_NEW_PROCESS_GROUP,
+	}
+}
+
+// killProcessGroup kills the process and all its children.
+func killProcessGroup(pid int) error {
+	return exec.Command("taskkill", "/T", "/F", "/PID", strconv.Itoa(pid)).Run()
+}
+
+// isProcessGroupAlive returns false because the process tree can't be found
+// when the process has exited, its pid may be reused.
+func isProcessGroupAlive(pid int) bool {
+	return false
+}
+
+func lookupUser(name string) (*User, error) {
+	return nil, errors.New("running commands as another user is not supported on Windows")
+}
@@ -6,22 +6,46 @@ import (
 	"fmt"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"sync"
 
 	"github.com/reconquest/karma-go"
 	"github.com/reconquest/pkg/log"
 	"github.com/reconquest/snake-runner/internal/executor"
-	"github.com/reconquest/snake-runner/internal/set"
 	"github.com/reconquest/snake-runner/internal/utils"
 )
 
 var _ executor.Executor = (*Shell)(nil)
 
-type Shell struct{}
+// Shell runs commands on the local host, every command is started in its
+// own process group, so canceled commands are killed with all their
+// children. The environment of the runner isn't passed to commands except
+// for variables specified with SetEnv.
+type Shell struct {
+	// user runs commands if it's specified, the runner must be started by
+	// root to switch users
+	user *User
+
+	// env is the base environment of commands
+	env []string
+}
+
+// User is the unprivileged user which runs commands.
+type User struct {
+	Name    string
+	HomeDir string
+	Uid     uint32
+	Gid     uint32
+	Groups  []uint32
+}
 
 type Box struct {
-	id        string
-	processes *set.ExecCmdSet
+	id string
+
+	mutex sync.Mutex
+	// groups are process groups of commands which may have running
+	// processes, background processes are killed when the box is destroyed
+	groups map[int]struct{}
 }
 
 func (box *Box) String() string {
@@ -32,10 +56,53 @@ func (box *Box) ID() string {
 	return box.id
 }
 
+func (box *Box) addGroup(pid int) {
+	box.mutex.Lock()
+	defer box.mutex.Unlock()
+
+	box.groups[pid] = struct{}{}
+}
+
+func (box *Box) deleteGroup(pid int) {
+	box.mutex.Lock()
+	defer box.mutex.Unlock()
+
+	delete(box.groups, pid)
+}
+
+func (box *Box) listGroups() []int {
+	box.mutex.Lock()
+	defer box.mutex.Unlock()
+
+	groups := []int{}
+	for pid := range box.groups {
+		groups = append(groups, pid)
+	}
+
+	return groups
+}
+
 func NewShell() *Shell {
 	return &Shell{}
 }
 
+// SetUser specifies the user which runs commands.
+func (shell *Shell) SetUser(name string) error {
+	user, err := lookupUser(name)
+	if err != nil {
+		return karma.Format(err, "unable to find user %q", name)
+	}
+
+	shell.user = user
+
+	return nil
+}
+
+// SetEnv specifies variables of the runner which are passed to commands.
+func (shell *Shell) SetEnv(env []string) {
+	shell.env = env
+}
+
 func (shell *Shell) Type() executor.ExecutorType {
 	return executor.EXECUTOR_SHELL
 }
@@ -45,25 +112,30 @@ func (shell *Shell) Create(
 	opts executor.CreateOptions,
 ) (executor.Container, error) {
 	return &Box{
-		id:        opts.Name,
-		processes: set.NewExecCmdSet(),
+		id:     opts.Name,
+		groups: map[int]struct{}{},
 	}, nil
 }
 
+// Destroy kills process groups of all commands of the box including
+// background processes of commands which have exited.
 func (shell *Shell) Destroy(
 	ctx context.Context,
 	container executor.Container,
 ) error {
 	box := box(container)
-	cmds := box.processes.List()
-	for _, cmd := range cmds {
-		fact := karma.Describe("cmd", cmd.Args)
-		log.Tracef(fact, "destroying process")
+	for _, pid := range box.listGroups() {
+		log.Tracef(nil, "destroying process group: %d", pid)
 
-		err := cmd.Process.Kill()
+		err := killProcessGroup(pid)
 		if err != nil {
-			log.Tracef(fact.Describe("error", err), "sent kill signal")
+			log.Tracef(
+				karma.Describe("pid", pid).Describe("error", err),
+				"unable to kill process group",
+			)
 		}
+
+		box.deleteGroup(pid)
 	}
 	return nil
 }
@@ -84,7 +156,9 @@ func (shell *Shell) Exec(
 
 	log.Tracef(nil, "shell exec: %s %s", name, args)
 
-	cmd := exec.CommandContext(ctx, name, args...)
+	cmd := exec.Command(name, args...)
+
+	setProcessAttributes(cmd, shell.user)
 
 	writer := &callbackWriter{callback: opts.OutputConsumer}
 	if opts.AttachStdout {
@@ -98,7 +172,7 @@ func (shell *Shell) Exec(
 		cmd.Stdin = opts.Stdin
 	}
 
-	cmd.Env = append(os.Environ(), opts.Env...)
+	cmd.Env = shell.getEnv(opts.Env)
 	cmd.Dir = opts.WorkingDir
 
 	err := cmd.Start()
@@ -106,13 +180,102 @@ func (shell *Shell) Exec(
 		return err
 	}
 
-	defer func() {
-		box.processes.Delete(cmd)
+	pid := cmd.Process.Pid
+
+	box.addGroup(pid)
+
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			err := killProcessGroup(pid)
+			if err != nil {
+				log.Tracef(
+					karma.Describe("pid", pid).Describe("error", err),
+					"unable to kill process group",
+				)
+			}
+		case <-done:
+		}
 	}()
 
-	box.processes.Put(cmd)
+	err = cmd.Wait()
+
+	if !isProcessGroupAlive(pid) {
+		box.deleteGroup(pid)
+	}
+
+	if err != nil && ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	return err
+}
+
+// getEnv returns the base environment and the given variables, HOME, USER
+// and LOGNAME belong to the user of commands.
+func (shell *Shell) getEnv(extra []string) []string {
+	env := append([]string{}, shell.env...)
+
+	if shell.user != nil {
+		env = append(
+			env,
+			"HOME="+shell.user.HomeDir,
+			"USER="+shell.user.Name,
+			"LOGNAME="+shell.user.Name,
+		)
+	}
+
+	return append(env, extra...)
+}
+
+// MkdirAll creates the directory with parents which are owned by the user of
+// commands.
+func (shell *Shell) MkdirAll(dir string) error {
+	// the first directory which doesn't exist is passed to the user with
+	// all its subdirectories
+	first := ""
+	for parent := dir; ; parent = filepath.Dir(parent) {
+		_, err := os.Stat(parent)
+		if err == nil {
+			break
+		}
+
+		first = parent
+
+		if filepath.Dir(parent) == parent {
+			break
+		}
+	}
+
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return err
+	}
+
+	if first == "" {
+		return nil
+	}
+
+	return shell.Chown(first)
+}
+
+// Chown passes the file or the directory with all its contents to the user
+// of commands, it does nothing if the user is not specified.
+func (shell *Shell) Chown(path string) error {
+	if shell.user == nil {
+		return nil
+	}
+
+	return filepath.Walk(path, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
 
-	return cmd.Wait()
+		return os.Lchown(path, int(shell.user.Uid), int(shell.user.Gid))
+	})
 }
 
 func (shell *Shell) Cleanup() error {
@@ -0,0 +1,163 @@
+// +build linux
+
+package shell
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/reconquest/snake-runner/internal/executor"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShell_Exec_KillsProcessGroupOnCancel(t *testing.T) {
+	test := assert.New(t)
+
+	shell := NewShell()
+
+	box, err := shell.Create(context.Background(), executor.CreateOptions{Name: "test"})
+	test.NoError(err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	pids := make(chan int, 1)
+
+	err = shell.Exec(ctx, box, executor.ExecOptions{
+		Cmd:          []string{"sh", "-c", "sleep 100 & echo $!; wait"},
+		AttachStdout: true,
+		OutputConsumer: func(text string) {
+			pid, _ := strconv.Atoi(strings.TrimSpace(text))
+			pids <- pid
+
+			cancel()
+		},
+	})
+	test.Equal(context.Canceled, err)
+
+	test.True(isKilled(<-pids))
+}
+
+func TestShell_Destroy_KillsBackgroundProcesses(t *testing.T) {
+	test := assert.New(t)
+
+	shell := NewShell()
+
+	ctx := context.Background()
+
+	box, err := shell.Create(ctx, executor.CreateOptions{Name: "test"})
+	test.NoError(err)
+
+	var output strings.Builder
+
+	err = shell.Exec(ctx, box, executor.ExecOptions{
+		Cmd:          []string{"sh", "-c", "sleep 100 >/dev/null 2>&1 & echo $!"},
+		AttachStdout: true,
+		OutputConsumer: func(text string) {
+			output.WriteString(text)
+		},
+	})
+	test.NoError(err)
+
+	pid, err := strconv.Atoi(strings.TrimSpace(output.String()))
+	test.NoError(err)
+	test.True(isRunning(pid))
+
+	test.NoError(shell.Destroy(ctx, box))
+	test.True(isKilled(pid))
+}
+
+func TestShell_Exec_ScrubsEnvironment(t *testing.T) {
+	test := assert.New(t)
+
+	os.Setenv("SNAKE_ACCESS_TOKEN", "secret")
+	defer os.Unsetenv("SNAKE_ACCESS_TOKEN")
+
+	shell := NewShell()
+	shell.SetEnv([]string{"PATH=" + os.Getenv("PATH")})
+
+	ctx := context.Background()
+
+	box, err := shell.Create(ctx, executor.CreateOptions{Name: "test"})
+	test.NoError(err)
+
+	var output strings.Builder
+
+	err = shell.Exec(ctx, box, executor.ExecOptions{
+		Cmd:          []string{"env"},
+		Env:          []string{"CI=true"},
+		AttachStdout: true,
+		OutputConsumer: func(text string) {
+			output.WriteString(text)
+		},
+	})
+	test.NoError(err)
+
+	test.Equal("PATH="+os.Getenv("PATH")+"\nCI=true\n", output.String())
+}
+
+func TestShell_Exec_SwitchesUser(t *testing.T) {
+	test := assert.New(t)
+
+	if os.Getuid() != 0 {
+		t.Skip("the runner must be started by root to switch users")
+	}
+
+	shell := NewShell()
+
+	err := shell.SetUser("nobody")
+	if err != nil {
+		t.Skip(err)
+	}
+
+	ctx := context.Background()
+
+	box, err := shell.Create(ctx, executor.CreateOptions{Name: "test"})
+	test.NoError(err)
+
+	var output strings.Builder
+
+	err = shell.Exec(ctx, box, executor.ExecOptions{
+		Cmd:          []string{"sh", "-c", `id -u; echo "$USER"`},
+		AttachStdout: true,
+		OutputConsumer: func(text string) {
+			output.WriteString(text)
+		},
+	})
+	test.NoError(err)
+	test.Equal(
+		strconv.Itoa(int(shell.user.Uid))+"\nnobody\n",
+		output.String(),
+	)
+}
+
+// isRunning returns false if the process has exited, zombies are not
+// reaped by init in containers, so they are considered as exited.
+func isRunning(pid int) bool {
+	stat, err := ioutil.ReadFile("/proc/" + strconv.Itoa(pid) + "/stat")
+	if err != nil {
+		return false
+	}
+
+	fields := strings.Fields(string(stat[strings.LastIndex(string(stat), ")")+1:]))
+
+	return fields[0] != "Z" && fields[0] != "X"
+}
+
+// isKilled waits for the process to exit after it has been killed.
+func isKilled(pid int) bool {
+	for i := 0; i < 100; i++ {
+		if !isRunning(pid) {
+			return true
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	return false
+}
@@ -0,0 +1,494 @@
+package ssh
+
+import (
+	"context"
+	"errors"
+	"io"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/gonuts/go-shellquote"
+	"github.com/reconquest/karma-go"
+	"github.com/reconquest/pkg/log"
+	"github.com/reconquest/snake-runner/internal/executor"
+	"github.com/reconquest/snake-runner/internal/sshkey"
+	"github.com/reconquest/snake-runner/internal/utils"
+	crypto_ssh "golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+const (
+	PREFERRED_SHELL = "bash"
+	DEFAULT_SHELL   = "sh"
+
+	// PIDS_DIR is the subdir of the container workspace with IDs of process
+	// groups of commands
+	PIDS_DIR = ".pids"
+)
+
+// workspacePatterns match workspaces of containers which are created by the
+// runner: jobs and sidecars.
+var workspacePatterns = []string{"pipeline-*", "snake-runner-sidecar-*"}
+
+var reEnvName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+var _ executor.Executor = (*Host)(nil)
+
+// Host is a single build host, every container has its own connection to
+// the host.
+type Host struct {
+	address string
+	config  *crypto_ssh.ClientConfig
+
+	// workspace is the absolute path of the workspace dir on the host
+	workspace string
+}
+
+type Box struct {
+	id     string
+	host   *Host
+	dir    string
+	client *crypto_ssh.Client
+
+	// agent is true if the ssh-agent is forwarded to every session
+	agent bool
+}
+
+func (box *Box) String() string {
+	return box.id + "@" + box.host.address
+}
+
+func (box *Box) ID() string {
+	return box.id
+}
+
+func (host *Host) connect(workspace string) error {
+	client, err := host.dial()
+	if err != nil {
+		return err
+	}
+
+	defer client.Close()
+
+	var output strings.Builder
+
+	err = command{
+		cmd: shellquote.Join("mkdir", "-p", "--", workspace) +
+			" && cd -- " + shellquote.Join(workspace) +
+			" && pwd",
+		stdout: &output,
+	}.run(context.Background(), client)
+	if err != nil {
+		return karma.
+			Describe("dir", workspace).
+			Format(err, "unable to create workspace dir")
+	}
+
+	host.workspace = strings.TrimSpace(output.String())
+
+	return nil
+}
+
+func (host *Host) dial() (*crypto_ssh.Client, error) {
+	return crypto_ssh.Dial("tcp", host.address, host.config)
+}
+
+func (host *Host) Type() executor.ExecutorType {
+	return executor.EXECUTOR_SSH
+}
+
+// Create opens a new connection to the host and creates the workspace of
+// the container, it's the default working dir of commands.
+func (host *Host) Create(
+	ctx context.Context,
+	opts executor.CreateOptions,
+) (executor.Container, error) {
+	client, err := host.dial()
+	if err != nil {
+		return nil, karma.
+			Describe("host", host.address).
+			Format(err, "unable to connect to build host")
+	}
+
+	box := &Box{
+		id:     opts.Name,
+		host:   host,
+		dir:    path.Join(host.workspace, opts.Name),
+		client: client,
+	}
+
+	err = command{
+		cmd: shellquote.Join("mkdir", "-p", "--", box.dir),
+	}.run(ctx, client)
+	if err != nil {
+		client.Close()
+
+		return nil, karma.
+			Describe("dir", box.dir).
+			Format(err, "unable to create container workspace")
+	}
+
+	if opts.SshKey != nil {
+		err = box.forwardAgent(*opts.SshKey)
+		if err != nil {
+			host.destroy(box)
+
+			return nil, karma.Format(err, "unable to forward ssh-agent")
+		}
+	}
+
+	return box, nil
+}
+
+// forwardAgent serves the in-memory ssh-agent with the given key to sessions
+// of the box.
+func (box *Box) forwardAgent(key sshkey.Key) error {
+	private, err := crypto_ssh.ParseRawPrivateKey([]byte(key.Private))
+	if err != nil {
+		return karma.Format(err, "unable to parse private key")
+	}
+
+	keyring := agent.NewKeyring()
+
+	err = keyring.Add(agent.AddedKey{PrivateKey: private})
+	if err != nil {
+		return karma.Format(err, "unable to add key to ssh-agent")
+	}
+
+	err = agent.ForwardToAgent(box.client, keyring)
+	if err != nil {
+		return err
+	}
+
+	box.agent = true
+
+	return nil
+}
+
+// Destroy kills process groups of all commands of the container, removes its
+// workspace and closes its connection. Closing the connection is not enough
+// because sshd doesn't kill processes of sessions without a pty.
+func (host *Host) Destroy(
+	ctx context.Context,
+	container executor.Container,
+) error {
+	return host.destroy(box(container))
+}
+
+func (host *Host) destroy(box *Box) error {
+	defer box.client.Close()
+
+	err := command{
+		cmd: getKillScript(shellquote.Join(path.Join(box.dir, PIDS_DIR))+"/*") +
+			"; " + shellquote.Join("rm", "-rf", "--", box.dir),
+	}.run(context.Background(), box.client)
+	if err != nil {
+		return karma.
+			Describe("dir", box.dir).
+			Format(err, "unable to remove container workspace")
+	}
+
+	return nil
+}
+
+func (host *Host) Prepare(
+	ctx context.Context,
+	opts executor.PrepareOptions,
+) error {
+	return nil
+}
+
+// Exec runs the command in a new session. Environment variables are not
+// passed with the session because sshd accepts only variables listed in
+// AcceptEnv, they are written to a file which is readable only by the user
+// and removed right after it's sourced.
+//
+// The command runs in its own process group which ID is saved in the pids dir
+// of the container, the group is killed when the command is canceled and
+// when the container is destroyed.
+func (host *Host) Exec(
+	ctx context.Context,
+	container executor.Container,
+	opts executor.ExecOptions,
+) error {
+	box := box(container)
+
+	if len(opts.Cmd) == 0 {
+		return errors.New("an empty command specified")
+	}
+
+	log.Tracef(nil, "ssh exec: %s %s", box.String(), opts.Cmd)
+
+	dir := opts.WorkingDir
+	if dir == "" {
+		dir = box.dir
+	}
+
+	script := "cd -- " + shellquote.Join(dir) +
+		" && exec " + shellquote.Join(opts.Cmd...)
+
+	if len(opts.Env) > 0 {
+		envFile := path.Join(box.dir, ".env-"+utils.RandString(10))
+
+		err := command{
+			cmd: shellquote.Join(
+				"sh", "-c", `umask 077 && cat > "$0"`, envFile,
+			),
+			stdin: strings.NewReader(formatEnv(opts.Env)),
+		}.run(ctx, box.client)
+		if err != nil {
+			return karma.Format(err, "unable to write environment variables")
+		}
+
+		script = ". " + shellquote.Join(envFile) +
+			" && rm -f -- " + shellquote.Join(envFile) +
+			" && " + script
+	}
+
+	pidFile := path.Join(box.dir, PIDS_DIR, utils.RandString(10))
+
+	script = "mkdir -p -- " + shellquote.Join(path.Dir(pidFile)) +
+		" && echo $$ > " + shellquote.Join(pidFile) +
+		" && " + script
+
+	writer := &callbackWriter{callback: opts.OutputConsumer}
+
+	// setsid is run in a subshell, so it's not a process group leader and
+	// starts the new session without forking
+	cmd := command{
+		cmd:   "(exec " + shellquote.Join("setsid", "sh", "-c", script) + ")",
+		stdin: opts.Stdin,
+		agent: box.agent,
+	}
+
+	if opts.AttachStdout {
+		cmd.stdout = writer
+	}
+
+	if opts.AttachStderr {
+		cmd.stderr = writer
+	}
+
+	err := cmd.run(ctx, box.client)
+	if err != nil {
+		if ctx.Err() != nil {
+			box.killGroup(pidFile)
+
+			return err
+		}
+
+		if err, ok := err.(*crypto_ssh.ExitError); ok {
+			return karma.
+				Describe("exitcode", err.ExitStatus()).
+				Format(
+					nil,
+					"exitcode is greater than zero",
+				)
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+// killGroup kills the process group of the command, a new connection is not
+// needed because the connection is closed only when the box is destroyed.
+func (box *Box) killGroup(pidFile string) {
+	err := command{
+		cmd: getKillScript(shellquote.Join(pidFile)),
+	}.run(context.Background(), box.client)
+	if err != nil {
+		log.Errorf(
+			karma.Describe("box", box.String()).Reason(err),
+			"unable to kill process group of canceled command",
+		)
+	}
+}
+
+// getKillScript returns the script which kills process groups which IDs are
+// in the given files, files can be a glob.
+func getKillScript(files string) string {
+	return "for file in " + files + "; do " +
+		`test -f "$file" && kill -s KILL -- "-$(cat "$file")"; ` +
+		"done 2>/dev/null; true"
+}
+
+func (host *Host) DetectShell(
+	ctx context.Context,
+	container executor.Container,
+) (string, error) {
+	err := command{
+		cmd: "command -v " + PREFERRED_SHELL,
+	}.run(ctx, box(container).client)
+	if err != nil {
+		if _, ok := err.(*crypto_ssh.ExitError); ok {
+			return DEFAULT_SHELL, nil
+		}
+
+		return "", err
+	}
+
+	return PREFERRED_SHELL, nil
+}
+
+func (host *Host) LookPath(ctx context.Context, name string) (string, error) {
+	client, err := host.dial()
+	if err != nil {
+		return "", karma.Format(err, "unable to connect to build host")
+	}
+
+	defer client.Close()
+
+	var output strings.Builder
+
+	err = command{
+		cmd:    "command -v " + shellquote.Join(name),
+		stdout: &output,
+	}.run(ctx, client)
+	if err != nil {
+		if _, ok := err.(*crypto_ssh.ExitError); ok {
+			return "", karma.Format(nil, "%s: executable file not found", name)
+		}
+
+		return "", err
+	}
+
+	return strings.TrimSpace(output.String()), nil
+}
+
+func (host *Host) Cleanup() error {
+	client, err := host.dial()
+	if err != nil {
+		return karma.Format(err, "unable to connect to build host")
+	}
+
+	defer client.Close()
+
+	cmd := []string{"rm", "-rf", "--"}
+	for _, pattern := range workspacePatterns {
+		cmd = append(cmd, shellquote.Join(host.workspace)+"/"+pattern)
+	}
+
+	err = command{
+		cmd: strings.Join(cmd, " "),
+	}.run(context.Background(), client)
+	if err != nil {
+		return karma.
+			Describe("dir", host.workspace).
+			Format(err, "unable to remove old workspaces")
+	}
+
+	return nil
+}
+
+// command is a command which is run in a new session of the client.
+type command struct {
+	cmd    string
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
+
+	// agent requests the ssh-agent forwarding for the session
+	agent bool
+}
+
+func (command command) run(
+	ctx context.Context,
+	client *crypto_ssh.Client,
+) error {
+	session, err := client.NewSession()
+	if err != nil {
+		return karma.Format(err, "unable to open ssh session")
+	}
+
+	defer session.Close()
+
+	if command.agent {
+		err = agent.RequestAgentForwarding(session)
+		if err != nil {
+			return karma.Format(err, "unable to request ssh-agent forwarding")
+		}
+	}
+
+	session.Stdin = command.stdin
+	session.Stdout = command.stdout
+	session.Stderr = command.stderr
+
+	err = session.Start(command.cmd)
+	if err != nil {
+		return karma.Format(err, "unable to start command")
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- session.Wait()
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		// signals are supported only by OpenSSH 7.9+ and the signal is sent
+		// only to the process started by sshd, so children of the command
+		// keep running, callers have to kill the whole process group
+		_ = session.Signal(crypto_ssh.SIGKILL)
+
+		return ctx.Err()
+	}
+}
+
+// formatEnv returns the script which exports the given variables, variables
+// with names which can't be used in shell are skipped.
+func formatEnv(env []string) string {
+	vars := map[string]string{}
+	for _, pair := range env {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+
+		if !reEnvName.MatchString(parts[0]) {
+			log.Tracef(nil, "skipping environment variable: %s", parts[0])
+			continue
+		}
+
+		vars[parts[0]] = parts[1]
+	}
+
+	names := []string{}
+	for name := range vars {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	var script strings.Builder
+	for _, name := range names {
+		script.WriteString(
+			"export " + name + "=" + shellquote.Join(vars[name]) + "\n",
+		)
+	}
+
+	return script.String()
+}
+
+type callbackWriter struct {
+	mutex    sync.Mutex
+	callback executor.OutputConsumer
+}
+
+func (callbackWriter *callbackWriter) Write(data []byte) (int, error) {
+	if callbackWriter.callback == nil {
+		return len(data), nil
+	}
+
+	callbackWriter.mutex.Lock()
+	defer callbackWriter.mutex.Unlock()
+	callbackWriter.callback(string(data))
+
+	return len(data), nil
+}
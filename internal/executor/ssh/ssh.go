@@ -0,0 +1,267 @@
+package ssh
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/reconquest/karma-go"
+	"github.com/reconquest/pkg/log"
+	"github.com/reconquest/snake-runner/internal/executor"
+	crypto_ssh "golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+const (
+	DEFAULT_PORT = "22"
+	DIAL_TIMEOUT = 30 * time.Second
+)
+
+var reUnsafeDirName = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+var (
+	_ executor.Executor = (*Ssh)(nil)
+	_ executor.HostPool = (*Ssh)(nil)
+)
+
+// Ssh runs jobs on a pool of remote build hosts over SSH. There are no
+// images and containers, every container is a directory in the workspace
+// dir of the host which is removed when the container is destroyed.
+type Ssh struct {
+	opts Options
+
+	hosts []*Host
+
+	mutex sync.Mutex
+	// usage is the number of pipelines which have acquired the host
+	usage map[*Host]int
+}
+
+type Options struct {
+	// Name is the name of the runner, every runner has its own subdir in
+	// WorkspaceDir, so several runners can share build hosts
+	Name string
+
+	// Hosts are addresses of build hosts, the port is 22 by default
+	Hosts []string
+	User  string
+
+	// IdentityFile is the private key the runner authenticates with
+	IdentityFile string
+
+	// KnownHostsFile must contain keys of all build hosts
+	KnownHostsFile string
+
+	// WorkspaceDir is created on every host, relative paths are relative
+	// to the home dir of the user
+	WorkspaceDir string
+}
+
+func NewSsh(opts Options) *Ssh {
+	return &Ssh{
+		opts:  opts,
+		usage: map[*Host]int{},
+	}
+}
+
+// Connect connects to all build hosts and creates workspace dirs, the runner
+// doesn't start if any host is unreachable.
+func (pool *Ssh) Connect() error {
+	config, err := getClientConfig(pool.opts)
+	if err != nil {
+		return err
+	}
+
+	for _, address := range pool.opts.Hosts {
+		host := &Host{
+			address: withDefaultPort(address),
+			config:  config,
+		}
+
+		err := host.connect(
+			path.Join(pool.opts.WorkspaceDir, getRunnerDir(pool.opts.Name)),
+		)
+		if err != nil {
+			return karma.
+				Describe("host", address).
+				Format(err, "unable to connect to build host")
+		}
+
+		log.Infof(
+			nil,
+			"connected to build host: %s workspace=%s",
+			host.address, host.workspace,
+		)
+
+		pool.hosts = append(pool.hosts, host)
+	}
+
+	return nil
+}
+
+// Acquire returns the host with the least number of running pipelines.
+func (pool *Ssh) Acquire() (executor.Executor, func()) {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+
+	host := pool.getLeastBusy()
+
+	pool.usage[host]++
+
+	once := sync.Once{}
+
+	return host, func() {
+		once.Do(func() {
+			pool.mutex.Lock()
+			defer pool.mutex.Unlock()
+
+			pool.usage[host]--
+		})
+	}
+}
+
+func (pool *Ssh) getLeastBusy() *Host {
+	var result *Host
+	for _, host := range pool.hosts {
+		if result == nil || pool.usage[host] < pool.usage[result] {
+			result = host
+		}
+	}
+
+	return result
+}
+
+func (pool *Ssh) Type() executor.ExecutorType {
+	return executor.EXECUTOR_SSH
+}
+
+func (pool *Ssh) Create(
+	ctx context.Context,
+	opts executor.CreateOptions,
+) (executor.Container, error) {
+	pool.mutex.Lock()
+	host := pool.getLeastBusy()
+	pool.mutex.Unlock()
+
+	return host.Create(ctx, opts)
+}
+
+func (pool *Ssh) Destroy(
+	ctx context.Context,
+	container executor.Container,
+) error {
+	return box(container).host.Destroy(ctx, container)
+}
+
+func (pool *Ssh) Prepare(
+	ctx context.Context,
+	opts executor.PrepareOptions,
+) error {
+	return nil
+}
+
+func (pool *Ssh) Exec(
+	ctx context.Context,
+	container executor.Container,
+	opts executor.ExecOptions,
+) error {
+	return box(container).host.Exec(ctx, container, opts)
+}
+
+func (pool *Ssh) DetectShell(
+	ctx context.Context,
+	container executor.Container,
+) (string, error) {
+	return box(container).host.DetectShell(ctx, container)
+}
+
+// LookPath looks for the binary on all hosts, it returns the path on the
+// first host.
+func (pool *Ssh) LookPath(ctx context.Context, name string) (string, error) {
+	var result string
+	for i, host := range pool.hosts {
+		path, err := host.LookPath(ctx, name)
+		if err != nil {
+			return "", karma.Describe("host", host.address).Reason(err)
+		}
+
+		if i == 0 {
+			result = path
+		}
+	}
+
+	return result, nil
+}
+
+// Cleanup removes workspaces of containers which were not destroyed because
+// the runner has been stopped, workspaces of other runners are kept.
+func (pool *Ssh) Cleanup() error {
+	for _, host := range pool.hosts {
+		err := host.Cleanup()
+		if err != nil {
+			return karma.Describe("host", host.address).Reason(err)
+		}
+	}
+
+	return nil
+}
+
+func getClientConfig(opts Options) (*crypto_ssh.ClientConfig, error) {
+	identity, err := ioutil.ReadFile(opts.IdentityFile)
+	if err != nil {
+		return nil, karma.Format(err, "unable to read identity file")
+	}
+
+	signer, err := crypto_ssh.ParsePrivateKey(identity)
+	if err != nil {
+		return nil, karma.
+			Describe("path", opts.IdentityFile).
+			Format(err, "unable to parse identity file")
+	}
+
+	hostKeyCallback, err := knownhosts.New(opts.KnownHostsFile)
+	if err != nil {
+		return nil, karma.Format(err, "unable to read known hosts file")
+	}
+
+	return &crypto_ssh.ClientConfig{
+		User:            opts.User,
+		Auth:            []crypto_ssh.AuthMethod{crypto_ssh.PublicKeys(signer)},
+		HostKeyCallback: hostKeyCallback,
+		Timeout:         DIAL_TIMEOUT,
+	}, nil
+}
+
+// getRunnerDir returns the name of the workspace subdir of the runner,
+// characters which are not safe in paths are replaced.
+func getRunnerDir(name string) string {
+	dir := strings.Trim(reUnsafeDirName.ReplaceAllString(name, "-"), ".")
+	if dir == "" {
+		return "default"
+	}
+
+	return dir
+}
+
+func withDefaultPort(address string) string {
+	_, _, err := net.SplitHostPort(address)
+	if err == nil {
+		return address
+	}
+
+	return net.JoinHostPort(address, DEFAULT_PORT)
+}
+
+func box(container executor.Container) *Box {
+	box, ok := container.(*Box)
+	if !ok {
+		panic("BUG: unexpected type given: " + fmt.Sprintf("%T", container))
+	}
+	return box
+}
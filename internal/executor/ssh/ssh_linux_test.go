@@ -0,0 +1,128 @@
+// +build linux
+
+package ssh
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/reconquest/snake-runner/internal/executor"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSsh_Exec_KillsProcessGroupOnCancel(t *testing.T) {
+	test := assert.New(t)
+
+	pool, dir := newTestSsh(t, &testServer{})
+	defer os.RemoveAll(dir)
+
+	container, err := pool.Create(
+		context.Background(),
+		executor.CreateOptions{Name: "pipeline-1"},
+	)
+	test.NoError(err)
+
+	defer pool.Destroy(context.Background(), container)
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	pidFile := filepath.Join(dir, "background.pid")
+
+	done := make(chan error, 1)
+	go func() {
+		done <- pool.Exec(ctx, container, executor.ExecOptions{
+			Cmd: []string{
+				"sh", "-c",
+				`sleep 100 > /dev/null 2>&1 & echo $! > "$0"; sleep 100`,
+				pidFile,
+			},
+		})
+	}()
+
+	pid := waitPid(t, pidFile)
+	test.True(isRunning(pid))
+
+	cancel()
+
+	test.Equal(context.Canceled, <-done)
+	test.True(isKilled(pid))
+}
+
+func TestSsh_Destroy_KillsBackgroundProcesses(t *testing.T) {
+	test := assert.New(t)
+
+	pool, dir := newTestSsh(t, &testServer{})
+	defer os.RemoveAll(dir)
+
+	ctx := context.Background()
+
+	container, err := pool.Create(ctx, executor.CreateOptions{Name: "pipeline-1"})
+	test.NoError(err)
+
+	pidFile := filepath.Join(dir, "background.pid")
+
+	err = pool.Exec(ctx, container, executor.ExecOptions{
+		Cmd: []string{
+			"sh", "-c",
+			`sleep 100 > /dev/null 2>&1 & echo $! > "$0"`,
+			pidFile,
+		},
+	})
+	test.NoError(err)
+
+	pid := waitPid(t, pidFile)
+	test.True(isRunning(pid))
+
+	test.NoError(pool.Destroy(ctx, container))
+	test.True(isKilled(pid))
+}
+
+func waitPid(t *testing.T, path string) int {
+	for i := 0; i < 100; i++ {
+		data, err := ioutil.ReadFile(path)
+		if err == nil && strings.HasSuffix(string(data), "\n") {
+			pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			return pid
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Fatal("pid file is not written: " + path)
+
+	return 0
+}
+
+func isRunning(pid int) bool {
+	stat, err := ioutil.ReadFile("/proc/" + strconv.Itoa(pid) + "/stat")
+	if err != nil {
+		return false
+	}
+
+	fields := strings.Fields(string(stat[strings.LastIndex(string(stat), ")")+1:]))
+
+	return fields[0] != "Z" && fields[0] != "X"
+}
+
+// isKilled waits for the process to exit after it has been killed.
+func isKilled(pid int) bool {
+	for i := 0; i < 100; i++ {
+		if !isRunning(pid) {
+			return true
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	return false
+}
@@ -0,0 +1,402 @@
+package ssh
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/reconquest/snake-runner/internal/executor"
+	"github.com/reconquest/snake-runner/internal/sshkey"
+	"github.com/stretchr/testify/assert"
+	crypto_ssh "golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+func TestSsh_Exec(t *testing.T) {
+	test := assert.New(t)
+
+	pool, dir := newTestSsh(t, &testServer{})
+	defer os.RemoveAll(dir)
+
+	ctx := context.Background()
+
+	workspace := filepath.Join(dir, "workspace", "runner-1", "pipeline-1")
+
+	container, err := pool.Create(ctx, executor.CreateOptions{Name: "pipeline-1"})
+	test.NoError(err)
+	test.DirExists(workspace)
+
+	var output strings.Builder
+	err = pool.Exec(ctx, container, executor.ExecOptions{
+		Cmd: []string{"sh", "-c", `echo "$A" "$B"; pwd; cat`},
+		Env: []string{
+			"A=1",
+			"B=it's 2",
+			"C.D=skipped",
+		},
+		Stdin:        strings.NewReader("stdin\n"),
+		AttachStdout: true,
+		OutputConsumer: func(text string) {
+			output.WriteString(text)
+		},
+	})
+	test.NoError(err)
+	test.Equal(
+		"1 it's 2\n"+workspace+"\nstdin\n",
+		output.String(),
+	)
+
+	// the env file is removed after it's sourced
+	files, err := filepath.Glob(filepath.Join(workspace, ".env-*"))
+	test.NoError(err)
+	test.Empty(files)
+
+	output.Reset()
+	err = pool.Exec(ctx, container, executor.ExecOptions{
+		Cmd:          []string{"pwd"},
+		WorkingDir:   dir,
+		AttachStdout: true,
+		OutputConsumer: func(text string) {
+			output.WriteString(text)
+		},
+	})
+	test.NoError(err)
+	test.Equal(dir+"\n", output.String())
+
+	err = pool.Exec(ctx, container, executor.ExecOptions{
+		Cmd: []string{"sh", "-c", "exit 3"},
+	})
+	test.Error(err)
+	test.Contains(err.Error(), "exitcode is greater than zero")
+	test.Contains(err.Error(), "exitcode: 3")
+
+	shell, err := pool.DetectShell(ctx, container)
+	test.NoError(err)
+	test.Contains([]string{PREFERRED_SHELL, DEFAULT_SHELL}, shell)
+
+	test.NoError(pool.Destroy(ctx, container))
+	test.NoDirExists(workspace)
+}
+
+func TestSsh_ForwardAgent(t *testing.T) {
+	test := assert.New(t)
+
+	server := &testServer{}
+
+	pool, dir := newTestSsh(t, server)
+	defer os.RemoveAll(dir)
+
+	key, err := sshkey.Generate(1024)
+	test.NoError(err)
+
+	ctx := context.Background()
+
+	container, err := pool.Create(ctx, executor.CreateOptions{
+		Name:   "snake-runner-sidecar-pipeline-1",
+		SshKey: key,
+	})
+	test.NoError(err)
+
+	test.NoError(pool.Exec(ctx, container, executor.ExecOptions{
+		Cmd: []string{"true"},
+	}))
+
+	public, _, _, _, err := crypto_ssh.ParseAuthorizedKey([]byte(key.Public))
+	test.NoError(err)
+
+	server.mutex.Lock()
+	test.Len(server.agentKeys, 1)
+	test.Equal(public.Marshal(), server.agentKeys[0].Blob)
+	server.mutex.Unlock()
+
+	test.NoError(pool.Destroy(ctx, container))
+}
+
+func TestSsh_Cleanup(t *testing.T) {
+	test := assert.New(t)
+
+	pool, dir := newTestSsh(t, &testServer{})
+	defer os.RemoveAll(dir)
+
+	workspace := filepath.Join(dir, "workspace", "runner-1")
+
+	// another runner shares the build host
+	other := filepath.Join(dir, "workspace", "runner-2")
+
+	for _, name := range []string{
+		"pipeline-1-job-1-uniq-abc",
+		"snake-runner-sidecar-pipeline-1-uniq-abc",
+		"cache",
+	} {
+		test.NoError(os.MkdirAll(filepath.Join(workspace, name), 0o755))
+		test.NoError(os.MkdirAll(filepath.Join(other, name), 0o755))
+	}
+
+	test.NoError(pool.Cleanup())
+
+	test.NoDirExists(filepath.Join(workspace, "pipeline-1-job-1-uniq-abc"))
+	test.NoDirExists(filepath.Join(workspace, "snake-runner-sidecar-pipeline-1-uniq-abc"))
+	test.DirExists(filepath.Join(workspace, "cache"))
+
+	test.DirExists(filepath.Join(other, "pipeline-1-job-1-uniq-abc"))
+	test.DirExists(filepath.Join(other, "snake-runner-sidecar-pipeline-1-uniq-abc"))
+}
+
+func TestSsh_Acquire(t *testing.T) {
+	test := assert.New(t)
+
+	first := &Host{address: "first:22"}
+	second := &Host{address: "second:22"}
+
+	pool := NewSsh(Options{})
+	pool.hosts = []*Host{first, second}
+
+	executor1, release1 := pool.Acquire()
+	executor2, release2 := pool.Acquire()
+	test.Equal(first, executor1)
+	test.Equal(second, executor2)
+
+	release1()
+	release1()
+
+	executor3, release3 := pool.Acquire()
+	test.Equal(first, executor3)
+
+	release2()
+	release3()
+
+	test.Equal(0, pool.usage[first])
+	test.Equal(0, pool.usage[second])
+}
+
+func TestGetRunnerDir(t *testing.T) {
+	test := assert.New(t)
+
+	test.Equal("runner-1", getRunnerDir("runner-1"))
+	test.Equal("build-host-1.local", getRunnerDir("build host/1.local"))
+	test.Equal("-", getRunnerDir("../.."))
+	test.Equal("default", getRunnerDir(""))
+}
+
+func TestFormatEnv(t *testing.T) {
+	test := assert.New(t)
+
+	test.Equal(
+		"export A=2\nexport B='x y'\nexport C=''\n",
+		formatEnv([]string{"B=x y", "A=1", "A=2", "C=", "1X=1", "D"}),
+	)
+}
+
+// newTestSsh connects to the in-process ssh server which runs commands on
+// the local host, the workspace is in the returned temporary dir.
+func newTestSsh(t *testing.T, server *testServer) (*Ssh, string) {
+	dir, err := ioutil.TempDir("", "snake-runner-test.*")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err = filepath.EvalSymlinks(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	identity, err := sshkey.Generate(1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	authorized, _, _, _, err := crypto_ssh.ParseAuthorizedKey(
+		[]byte(identity.Public),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	address, hostKey := server.start(t, authorized)
+
+	files := map[string]string{
+		"id_rsa":      identity.Private,
+		"known_hosts": knownhosts.Line([]string{address}, hostKey) + "\n",
+	}
+	for name, data := range files {
+		err := ioutil.WriteFile(filepath.Join(dir, name), []byte(data), 0o600)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	pool := NewSsh(Options{
+		Name:           "runner-1",
+		Hosts:          []string{address},
+		User:           "runner",
+		IdentityFile:   filepath.Join(dir, "id_rsa"),
+		KnownHostsFile: filepath.Join(dir, "known_hosts"),
+		WorkspaceDir:   filepath.Join(dir, "workspace"),
+	})
+
+	err = pool.Connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return pool, dir
+}
+
+// testServer is a minimal sshd which runs exec requests with sh and
+// remembers keys of forwarded agents.
+type testServer struct {
+	mutex     sync.Mutex
+	agentKeys []*agent.Key
+}
+
+func (server *testServer) start(
+	t *testing.T,
+	authorized crypto_ssh.PublicKey,
+) (string, crypto_ssh.PublicKey) {
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signer, err := crypto_ssh.NewSignerFromKey(private)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := &crypto_ssh.ServerConfig{
+		PublicKeyCallback: func(
+			meta crypto_ssh.ConnMetadata,
+			key crypto_ssh.PublicKey,
+		) (*crypto_ssh.Permissions, error) {
+			if string(key.Marshal()) != string(authorized.Marshal()) {
+				return nil, os.ErrPermission
+			}
+
+			return nil, nil
+		},
+	}
+	config.AddHostKey(signer)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			go server.serve(conn, config)
+		}
+	}()
+
+	return listener.Addr().String(), signer.PublicKey()
+}
+
+func (server *testServer) serve(conn net.Conn, config *crypto_ssh.ServerConfig) {
+	serverConn, channels, requests, err := crypto_ssh.NewServerConn(conn, config)
+	if err != nil {
+		return
+	}
+
+	go crypto_ssh.DiscardRequests(requests)
+
+	for newChannel := range channels {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(crypto_ssh.UnknownChannelType, "")
+			continue
+		}
+
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			continue
+		}
+
+		go server.serveSession(serverConn, channel, requests)
+	}
+}
+
+func (server *testServer) serveSession(
+	conn *crypto_ssh.ServerConn,
+	channel crypto_ssh.Channel,
+	requests <-chan *crypto_ssh.Request,
+) {
+	defer channel.Close()
+
+	for request := range requests {
+		switch request.Type {
+		case "auth-agent-req@openssh.com":
+			request.Reply(true, nil)
+
+			server.listAgentKeys(conn)
+
+		case "exec":
+			var payload struct{ Command string }
+
+			err := crypto_ssh.Unmarshal(request.Payload, &payload)
+			if err != nil {
+				request.Reply(false, nil)
+				return
+			}
+
+			request.Reply(true, nil)
+
+			cmd := exec.Command("sh", "-c", payload.Command)
+			cmd.Stdin = channel
+			cmd.Stdout = channel
+			cmd.Stderr = channel.Stderr()
+
+			status := struct{ Status uint32 }{}
+
+			err = cmd.Run()
+			if err != nil {
+				status.Status = 255
+				if err, ok := err.(*exec.ExitError); ok {
+					status.Status = uint32(err.ExitCode())
+				}
+			}
+
+			channel.SendRequest(
+				"exit-status", false, crypto_ssh.Marshal(&status),
+			)
+
+			return
+
+		default:
+			request.Reply(false, nil)
+		}
+	}
+}
+
+func (server *testServer) listAgentKeys(conn *crypto_ssh.ServerConn) {
+	channel, requests, err := conn.OpenChannel("auth-agent@openssh.com", nil)
+	if err != nil {
+		return
+	}
+
+	defer channel.Close()
+
+	go crypto_ssh.DiscardRequests(requests)
+
+	keys, err := agent.NewClient(channel).List()
+	if err != nil {
+		return
+	}
+
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	server.agentKeys = keys
+}
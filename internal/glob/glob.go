@@ -0,0 +1,39 @@
+package glob
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Match returns true if the value matches the glob: * matches anything except
+// slashes, ** matches anything including slashes and ? matches a single
+// character except a slash.
+func Match(pattern string, value string) bool {
+	return Compile(pattern).MatchString(value)
+}
+
+// Compile converts the glob to a regular expression.
+func Compile(pattern string) *regexp.Regexp {
+	var expr strings.Builder
+
+	expr.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		switch {
+		case strings.HasPrefix(pattern[i:], "**/"):
+			expr.WriteString("(.*/)?")
+			i += 2
+		case strings.HasPrefix(pattern[i:], "**"):
+			expr.WriteString(".*")
+			i++
+		case pattern[i] == '*':
+			expr.WriteString("[^/]*")
+		case pattern[i] == '?':
+			expr.WriteString("[^/]")
+		default:
+			expr.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		}
+	}
+	expr.WriteString("$")
+
+	return regexp.MustCompile(expr.String())
+}
@@ -0,0 +1,18 @@
+package glob
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatch(t *testing.T) {
+	test := assert.New(t)
+
+	test.True(Match("**/*.go", "main.go"))
+	test.True(Match("**/*.go", "cmd/app/main.go"))
+	test.False(Match("*.go", "cmd/main.go"))
+	test.True(Match("cmd/**", "cmd/app/main.go"))
+	test.True(Match("a?c.txt", "abc.txt"))
+	test.False(Match("a.c", "abc"))
+}
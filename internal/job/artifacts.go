@@ -0,0 +1,262 @@
+package job
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/reconquest/snake-runner/internal/config"
+	"github.com/reconquest/snake-runner/internal/env"
+)
+
+// limitWriter fails when more than limit bytes are written, zero limit
+// means no limit.
+type limitWriter struct {
+	writer   io.Writer
+	limit    int64
+	written  int64
+	exceeded bool
+}
+
+var errArtifactsTooLarge = errors.New("artifacts exceed the size limit")
+
+func (writer *limitWriter) Write(data []byte) (int, error) {
+	if writer.limit > 0 && writer.written+int64(len(data)) > writer.limit {
+		writer.exceeded = true
+		return 0, errArtifactsTooLarge
+	}
+
+	written, err := writer.writer.Write(data)
+	writer.written += int64(written)
+
+	return written, err
+}
+
+// restoreArtifacts extracts artifacts of the jobs the job depends on into the
+// git dir of the sidecar. The git dir is shared by all jobs of the pipeline,
+// so artifacts are restored into the workspace of parallel jobs as well,
+// restores of different jobs are serialized by the store.
+func (process *Process) restoreArtifacts() error {
+	unlock := process.artifacts.LockRestore()
+	defer unlock()
+
+	for _, job := range process.artifacts.List(process.dependencies) {
+		if job.Archive == "" {
+			continue
+		}
+
+		process.LogMask(
+			fmt.Sprintf("\n:: Restoring artifacts of job %s\n", job.Name),
+		)
+
+		err := process.extractArchive(job.Archive)
+		if err != nil {
+			return process.errorfRemote(
+				err,
+				"unable to restore artifacts of job %s", job.Name,
+			)
+		}
+	}
+
+	return nil
+}
+
+func (process *Process) extractArchive(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return process.sidecar.Extract(process.ctx, file)
+}
+
+// collectArtifacts reads the dotenv report and uploads artifacts of the job
+// according to the artifacts config of the job. Both are saved to the store
+// of the pipeline to be passed to the jobs which depend on the job.
+func (process *Process) collectArtifacts(jobErr error) error {
+	artifacts := process.configJob.Artifacts
+	if artifacts == nil {
+		return nil
+	}
+
+	if errors.Is(process.ctx.Err(), context.Canceled) {
+		return nil
+	}
+
+	switch artifacts.When {
+	case config.ARTIFACTS_WHEN_ON_SUCCESS:
+		if jobErr != nil {
+			return nil
+		}
+	case config.ARTIFACTS_WHEN_ON_FAILURE:
+		if jobErr == nil {
+			return nil
+		}
+	}
+
+	if artifacts.Reports != nil && artifacts.Reports.Dotenv != "" {
+		err := process.readDotenv(artifacts.Reports.Dotenv)
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(artifacts.Paths) == 0 {
+		return nil
+	}
+
+	return process.uploadArtifacts(artifacts)
+}
+
+func (process *Process) readDotenv(path string) error {
+	path = process.expandEnv(path)
+
+	data, err := process.sidecar.ReadFile(
+		process.ctx,
+		process.sidecar.GitDir(),
+		path,
+	)
+	if err != nil {
+		return process.errorfRemote(err, "unable to read dotenv report %s", path)
+	}
+
+	vars, err := env.ParseDotenv(data)
+	if err != nil {
+		return process.errorfRemote(err, "unable to parse dotenv report %s", path)
+	}
+
+	process.artifacts.SetDotenv(process.job, vars)
+
+	process.LogMask(
+		fmt.Sprintf("\n:: Loaded dotenv report: %d variables\n", len(vars)),
+	)
+
+	return nil
+}
+
+// uploadArtifacts archives paths matching the artifacts config of the job and
+// uploads the archive to the master. Artifacts are collected from the git
+// dir of the sidecar, so they are available after the container is
+// destroyed.
+func (process *Process) uploadArtifacts(artifacts *config.Artifacts) error {
+	ctx := process.ctx
+
+	process.LogMask("\n:: Collecting artifacts\n")
+
+	patterns := make([]string, len(artifacts.Paths))
+	for i, path := range artifacts.Paths {
+		patterns[i] = process.expandEnv(path)
+	}
+
+	paths, err := process.sidecar.ListArtifacts(ctx, patterns)
+	if err != nil {
+		return process.errorfRemote(err, "unable to collect artifacts")
+	}
+
+	if len(paths) == 0 {
+		process.LogMask("WARNING: no files match artifacts paths\n")
+		return nil
+	}
+
+	for _, path := range paths {
+		process.LogMask(path + "\n")
+	}
+
+	file, err := process.artifacts.Create(process.job.ID)
+	if err != nil {
+		return process.errorfRemote(err, "unable to create artifacts file")
+	}
+
+	reader, writer := io.Pipe()
+
+	limiter := &limitWriter{
+		writer: writer,
+		limit:  process.runnerConfig.ArtifactsMaxSize,
+	}
+
+	var archiveErr error
+
+	workers := &sync.WaitGroup{}
+	workers.Add(1)
+	go func() {
+		defer workers.Done()
+
+		archiveErr = process.sidecar.Archive(
+			ctx,
+			paths,
+			io.MultiWriter(limiter, file),
+		)
+
+		writer.CloseWithError(archiveErr)
+	}()
+
+	uploadErr := process.client.UploadArtifacts(
+		process.task.Pipeline.ID,
+		process.job.ID,
+		artifacts.ExpireIn.Duration(),
+		reader,
+	)
+
+	// unblocks the archiver if the upload has failed in the middle
+	reader.Close()
+
+	workers.Wait()
+
+	closeErr := file.Close()
+
+	switch {
+	case limiter.exceeded:
+		err = process.errorfRemote(
+			nil,
+			"artifacts exceed the size limit of %s",
+			formatSize(limiter.limit),
+		)
+	case archiveErr != nil:
+		err = process.errorfRemote(archiveErr, "unable to archive artifacts")
+	case uploadErr != nil:
+		err = process.errorfRemote(uploadErr, "unable to upload artifacts")
+	case closeErr != nil:
+		err = process.errorfRemote(closeErr, "unable to save artifacts")
+	}
+
+	if err != nil {
+		os.Remove(file.Name())
+		return err
+	}
+
+	process.artifacts.SetArchive(process.job, file.Name())
+
+	process.LogMask(
+		fmt.Sprintf(
+			":: Uploaded artifacts: %d paths, %s\n",
+			len(paths), formatSize(limiter.written),
+		),
+	)
+
+	return nil
+}
+
+func formatSize(size int64) string {
+	const unit = 1024
+
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+
+	value := float64(size)
+	suffixes := []string{"KiB", "MiB", "GiB", "TiB"}
+
+	suffix := ""
+	for _, suffix = range suffixes {
+		value /= unit
+		if value < unit {
+			break
+		}
+	}
+
+	return fmt.Sprintf("%.1f %s", value, suffix)
+}
@@ -0,0 +1,161 @@
+package job
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+
+	"github.com/reconquest/karma-go"
+	"github.com/reconquest/snake-runner/internal/cache"
+	"github.com/reconquest/snake-runner/internal/config"
+)
+
+// getCache returns the cache config of the job, the job level cache
+// overrides the pipeline level one.
+func (process *Process) getCache() *config.Cache {
+	if process.configJob.Cache != nil {
+		return process.configJob.Cache
+	}
+
+	return process.configPipeline.Cache
+}
+
+// getCacheKey returns the cache key scoped to the repository, so pipelines of
+// different repositories never share caches.
+func (process *Process) getCacheKey(key config.CacheKey) (string, error) {
+	result := process.expandEnv(key.Prefix)
+
+	if len(key.Files) > 0 {
+		hash := sha256.New()
+		for _, path := range key.Files {
+			path = process.expandEnv(path)
+
+			data, err := process.sidecar.ReadFile(
+				process.ctx,
+				process.sidecar.GitDir(),
+				path,
+			)
+			if err != nil {
+				return "", karma.Format(err, "unable to read cache key file %s", path)
+			}
+
+			fmt.Fprintf(hash, "%s\x00%s\x00", path, data)
+		}
+
+		if result != "" {
+			result += "-"
+		}
+
+		result += hex.EncodeToString(hash.Sum(nil))[:16]
+	}
+
+	if result == "" {
+		result = config.CACHE_KEY_DEFAULT
+	}
+
+	return fmt.Sprintf(
+		"%s/%s/%s",
+		process.task.Project.Key,
+		process.task.Repository.Slug,
+		result,
+	), nil
+}
+
+// restoreCache extracts the cache archive into the git dir of the sidecar.
+// The cache is an optimization, so failures are reported as warnings and
+// don't fail the job.
+func (process *Process) restoreCache() {
+	jobCache := process.getCache()
+	if jobCache == nil {
+		return
+	}
+
+	key, err := process.getCacheKey(jobCache.Key)
+	if err != nil {
+		process.warnCache(err, "unable to get cache key")
+		return
+	}
+
+	process.cacheKey = key
+
+	if jobCache.Policy == config.CACHE_POLICY_PUSH {
+		return
+	}
+
+	file, err := process.cache.Open(process.ctx, key)
+	if err != nil {
+		if err == cache.ErrNotFound {
+			process.LogMask(fmt.Sprintf("\n:: Cache %s not found\n", key))
+			return
+		}
+
+		process.warnCache(err, "unable to open cache")
+		return
+	}
+	defer file.Close()
+
+	process.LogMask(fmt.Sprintf("\n:: Restoring cache %s\n", key))
+
+	err = process.sidecar.Extract(process.ctx, file)
+	if err != nil {
+		process.warnCache(err, "unable to restore cache")
+	}
+}
+
+// saveCache archives paths matching the cache config of the job, the cache is
+// saved only if the job has succeeded.
+func (process *Process) saveCache(jobErr error) {
+	jobCache := process.getCache()
+	if jobCache == nil || jobErr != nil || process.cacheKey == "" {
+		return
+	}
+
+	if jobCache.Policy == config.CACHE_POLICY_PULL {
+		return
+	}
+
+	ctx := process.ctx
+
+	process.LogMask(fmt.Sprintf("\n:: Saving cache %s\n", process.cacheKey))
+
+	patterns := make([]string, len(jobCache.Paths))
+	for i, path := range jobCache.Paths {
+		patterns[i] = process.expandEnv(path)
+	}
+
+	paths, err := process.sidecar.ListArtifacts(ctx, patterns)
+	if err != nil {
+		process.warnCache(err, "unable to collect cache")
+		return
+	}
+
+	if len(paths) == 0 {
+		process.LogMask("WARNING: no files match cache paths\n")
+		return
+	}
+
+	size, err := process.cache.Save(
+		ctx,
+		process.cacheKey,
+		func(writer io.Writer) error {
+			return process.sidecar.Archive(ctx, paths, writer)
+		},
+	)
+	if err != nil {
+		process.warnCache(err, "unable to save cache")
+		return
+	}
+
+	process.LogMask(
+		fmt.Sprintf(
+			":: Saved cache: %d paths, %s\n",
+			len(paths), formatSize(size),
+		),
+	)
+}
+
+func (process *Process) warnCache(err error, message string) {
+	process.log.Warningf(err, "%s", message)
+	process.LogMask(fmt.Sprintf("WARNING: %s: %s\n", message, err))
+}
@@ -3,18 +3,22 @@ package job
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/reconquest/cog"
 	"github.com/reconquest/karma-go"
 	"github.com/reconquest/lineflushwriter-go"
 	"github.com/reconquest/pkg/log"
 	"github.com/reconquest/snake-runner/internal/api"
+	"github.com/reconquest/snake-runner/internal/artifacts"
 	"github.com/reconquest/snake-runner/internal/audit"
 	"github.com/reconquest/snake-runner/internal/bufferer"
+	"github.com/reconquest/snake-runner/internal/cache"
 	"github.com/reconquest/snake-runner/internal/config"
 	"github.com/reconquest/snake-runner/internal/env"
 	"github.com/reconquest/snake-runner/internal/executor"
@@ -28,6 +32,10 @@ import (
 
 const (
 	DEFAULT_CONTAINER_JOB_IMAGE = "alpine:latest"
+
+	// AFTER_COMMANDS_TIMEOUT limits after commands separately from the job
+	// timeout, so they can run even if the job has timed out.
+	AFTER_COMMANDS_TIMEOUT = 5 * time.Minute
 )
 
 type ContextExecutorAuth struct {
@@ -50,7 +58,8 @@ func (config *ContextExecutorAuth) List() []executor.Auths {
 type Process struct {
 	ctx          context.Context
 	executor     executor.Executor
-	client       *api.Client
+	cache        *cache.Cache
+	client       api.Master
 	runnerConfig *runner.Config
 
 	task tasks.PipelineRun
@@ -62,12 +71,34 @@ type Process struct {
 
 	configJob config.Job `gonstructor:"-"`
 
+	// artifacts keeps artifacts of finished jobs of the pipeline, artifacts
+	// of dependencies are restored before the job is started
+	artifacts    *artifacts.Store `gonstructor:"-"`
+	dependencies []int            `gonstructor:"-"`
+
+	// cacheKey is computed before the job is started, so changes of the key
+	// files made by the job don't affect the key the cache is saved with
+	cacheKey string `gonstructor:"-"`
+
+	// timedOut is set if commands of the last attempt exceeded the timeout
+	timedOut bool `gonstructor:"-"`
+
 	mutex     sync.Mutex         `gonstructor:"-"`
 	container executor.Container `gonstructor:"-"`
 	sidecar   sidecar.Sidecar    `gonstructor:"-"`
 	shell     string             `gonstructor:"-"`
 	env       *env.Env           `gonstructor:"-"`
-	logs      struct {
+	network   executor.Network   `gonstructor:"-"`
+	services  []service          `gonstructor:"-"`
+	timeout   time.Duration      `gonstructor:"-"`
+	resources executor.Resources `gonstructor:"-"`
+
+	privileges executor.Privileges `gonstructor:"-"`
+
+	// pipelineNetwork is nil if the executor doesn't support networks
+	pipelineNetwork executor.Network `gonstructor:"-"`
+
+	logs struct {
 		masker       masker.Masker
 		maskWriter   *lineflushwriter.Writer
 		directWriter *bufferer.Bufferer
@@ -82,10 +113,29 @@ func (job *Process) SetSidecar(car sidecar.Sidecar) {
 	job.sidecar = car
 }
 
+// SetPipelineNetwork sets the network which is shared by containers of the
+// pipeline, the job container is reachable in it by the job name.
+func (job *Process) SetPipelineNetwork(network executor.Network) {
+	job.pipelineNetwork = network
+}
+
+// SetJob replaces the job after it has been expanded by matrix or parallel,
+// the ID of the job stays the same.
+func (job *Process) SetJob(target snake.PipelineJob) {
+	job.job = target
+}
+
 func (job *Process) SetConfigPipeline(config config.Pipeline) {
 	job.configPipeline = config
 }
 
+// SetArtifacts sets the store of artifacts of the pipeline and IDs of jobs
+// which artifacts are passed to the job.
+func (job *Process) SetArtifacts(store *artifacts.Store, dependencies []int) {
+	job.artifacts = store
+	job.dependencies = dependencies
+}
+
 func (process *Process) setupDirectWriter() {
 	process.logs.directWriter = bufferer.NewBufferer(
 		bufferer.DefaultChanSize,
@@ -135,6 +185,17 @@ func (process *Process) Run() error {
 	var ok bool
 	process.configJob, ok = process.configPipeline.Jobs[process.job.Name]
 	if !ok {
+		if expanded, ok := process.configPipeline.Expanded[process.job.Name]; ok {
+			return process.ErrorfDirect(
+				nil,
+				"job %q is expanded by matrix or parallel into %d jobs which "+
+					"must be scheduled instead: %s",
+				process.job.Name,
+				len(expanded),
+				strings.Join(expanded, ", "),
+			)
+		}
+
 		return process.ErrorfDirect(
 			nil,
 			"unable to find given job %q in %q",
@@ -143,12 +204,15 @@ func (process *Process) Run() error {
 		)
 	}
 
+	process.timeout = process.getTimeout()
+
 	process.env = env.NewBuilder(
 		process.task,
 		process.task.Pipeline,
 		process.job,
 		process.configPipeline,
 		process.configJob,
+		process.artifacts.Dotenv(process.dependencies),
 		process.runnerConfig,
 		process.sidecar.GitDir(),
 		process.sidecar.SshSocketPath(),
@@ -157,6 +221,27 @@ func (process *Process) Run() error {
 
 	process.SetupMaskWriter(process.env)
 
+	resources, err := process.getResources()
+	if err != nil {
+		return process.errorfRemote(err, "unable to limit resources of the job")
+	}
+
+	process.resources = resources
+
+	privileges, err := process.getPrivileges()
+	if err != nil {
+		return process.errorfRemote(err, "unable to grant privileges to the job")
+	}
+
+	process.privileges = privileges
+
+	err = process.restoreArtifacts()
+	if err != nil {
+		return err
+	}
+
+	process.restoreCache()
+
 	imageExpr, image := process.getImage()
 
 	process.log.Debugf(nil, "image: %s → %s", imageExpr, image)
@@ -189,17 +274,167 @@ func (process *Process) Run() error {
 		"docker auth configs",
 	)
 
-	err = process.executor.Prepare(
+	err = process.runAttempts(image)
+
+	process.saveCache(err)
+
+	artifactsErr := process.collectArtifacts(err)
+	if err == nil {
+		return artifactsErr
+	}
+
+	return err
+}
+
+// runAttempts runs the job until it succeeds or the failure is not
+// retryable according to the retry config of the job.
+//
+// Every attempt runs in a new container but the workspace is not reset
+// between attempts: the git dir is shared with other jobs of the pipeline
+// and it contains restored artifacts and cache, so files changed by the failed
+// attempt are left as is and commands of the job should tolerate them.
+func (process *Process) runAttempts(image string) error {
+	attempts := process.configJob.Retry.Max + 1
+	for attempt := 1; ; attempt++ {
+		if attempts > 1 {
+			process.LogMask(
+				fmt.Sprintf("\n:: Attempt %d of %d\n", attempt, attempts),
+			)
+		}
+
+		kind, err := process.runAttempt(image)
+		if err == nil {
+			return nil
+		}
+
+		if !process.shouldRetry(attempt, kind) {
+			return err
+		}
+
+		process.log.Warningf(
+			err,
+			"job attempt %d of %d failed due to %s failure, retrying",
+			attempt, attempts, kind,
+		)
+	}
+}
+
+// getPullPolicy returns the pull policy of the job, the policy of the runner
+// is used unless the job specifies its own.
+func (process *Process) getPullPolicy() string {
+	if process.configJob.PullPolicy != "" {
+		return string(process.configJob.PullPolicy)
+	}
+
+	return process.runnerConfig.Docker.PullPolicy
+}
+
+// getPrivileges returns privileges of the job container, privileges of the
+// runner are granted to all jobs while privileges requested by the job and
+// docker in docker services are granted only to jobs allowed by the runner.
+func (process *Process) getPrivileges() (executor.Privileges, error) {
+	requested := executor.Privileges{
+		Privileged: process.configJob.Privileged,
+		CapAdd:     process.configJob.CapAdd,
+	}
+
+	for _, spec := range process.configJob.Devices {
+		device, err := executor.ParseDevice(string(spec))
+		if err != nil {
+			return executor.Privileges{}, err
+		}
+
+		requested.Devices = append(requested.Devices, device)
+	}
+
+	if !requested.IsEmpty() || process.hasDockerInDocker() {
+		if !process.isPrivileged() {
+			return executor.Privileges{}, karma.
+				Describe("repository", process.getRepository()).
+				Describe("job", process.job.Name).
+				Format(
+					nil,
+					"the job requests privileges or docker in docker "+
+						"services but it's not listed in docker.privileged_jobs "+
+						"of the runner",
+				)
+		}
+	}
+
+	return process.runnerConfig.GetDockerPrivileges().Merge(requested), nil
+}
+
+func (process *Process) isPrivileged() bool {
+	return process.runnerConfig.IsPrivilegedJob(
+		process.getRepository(),
+		process.job.Name,
+	)
+}
+
+func (process *Process) getRepository() string {
+	return process.task.Project.Key + "/" + process.task.Repository.Slug
+}
+
+func (process *Process) hasDockerInDocker() bool {
+	for _, service := range process.configJob.Services {
+		if service.IsDockerInDocker() {
+			return true
+		}
+	}
+
+	return false
+}
+
+// getResources returns resource limits of the job container, limits which
+// are not requested by the job are the defaults of the runner.
+func (process *Process) getResources() (executor.Resources, error) {
+	resources := process.runnerConfig.GetDockerResources()
+
+	if process.configJob.Resources != nil {
+		requested, err := process.configJob.Resources.Parse()
+		if err != nil {
+			return resources, err
+		}
+
+		resources = resources.Merge(requested)
+	}
+
+	err := resources.Validate()
+	if err != nil {
+		return resources, err
+	}
+
+	err = resources.CheckLimits(process.runnerConfig.GetDockerMaxResources())
+	if err != nil {
+		return resources, err
+	}
+
+	return resources, nil
+}
+
+// runAttempt pulls the image, creates a new container and runs all commands
+// of the job in it, the returned kind describes at which step it has failed.
+func (process *Process) runAttempt(image string) (ErrorKind, error) {
+	err := process.executor.Prepare(
 		process.ctx,
 		executor.PrepareOptions{
 			Image:          image,
 			OutputConsumer: process.LogMask,
 			InfoConsumer:   process.LogMask,
 			Auths:          process.contextPullAuth.List(),
+			PullPolicy:     process.getPullPolicy(),
 		},
 	)
 	if err != nil {
-		return process.errorfRemote(err, "unable to pull image %q", image)
+		return process.getErrorKind(ERROR_KIND_PULL),
+			process.errorfRemote(err, "unable to pull image %q", image)
+	}
+
+	defer process.destroyServices()
+
+	kind, err := process.startServices()
+	if err != nil {
+		return kind, err
 	}
 
 	process.container, err = process.executor.Create(
@@ -211,12 +446,21 @@ func (process *Process) Run() error {
 				process.job.ID,
 				utils.RandString(8),
 			),
-			Image:   image,
-			Volumes: process.sidecar.ContainerVolumes(),
+			Image:        image,
+			Volumes:      process.sidecar.ContainerVolumes(),
+			ChownVolumes: true,
+			Networks: []executor.Network{
+				process.pipelineNetwork,
+				process.network,
+			},
+			Alias:      getAlias(process.job.Name),
+			Resources:  process.resources,
+			Privileges: process.privileges,
 		},
 	)
 	if err != nil {
-		return process.errorfRemote(err, "unable to create a container")
+		return process.getErrorKind(ERROR_KIND_CREATE),
+			process.errorfRemote(err, "unable to create a container")
 	}
 
 	defer func() {
@@ -241,13 +485,50 @@ func (process *Process) Run() error {
 
 	err = process.detectShell()
 	if err != nil {
-		return process.errorfRemote(err, "unable to detect shell in container")
+		return process.getErrorKind(ERROR_KIND_CREATE),
+			process.errorfRemote(err, "unable to detect shell in container")
 	}
 
-	for _, command := range process.configJob.Commands {
-		err = process.execShell(command)
+	kind, err = process.runCommands()
+
+	process.runAfterCommands(err)
+
+	if err != nil {
+		process.logServices()
+	}
+
+	return kind, err
+}
+
+// runCommands runs before commands and commands of the job, it stops at the
+// first failed command. The timeout of the job is applied to the commands of
+// every attempt, so pulling the image, restoring artifacts and cache and
+// previous attempts don't take the time of the commands.
+func (process *Process) runCommands() (ErrorKind, error) {
+	commands := append(process.getBeforeCommands(), process.configJob.Commands...)
+
+	ctx := process.ctx
+	if process.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(process.ctx, process.timeout)
+		defer cancel()
+	}
+
+	for _, command := range commands {
+		err := process.execShell(ctx, command, nil)
 		if err != nil {
-			return process.errorfRemote(
+			if process.ctx.Err() == nil &&
+				errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				process.timedOut = true
+
+				return ERROR_KIND_EXEC, process.errorfRemote(
+					err,
+					"job timed out after %s while running command: %s",
+					process.timeout, command,
+				)
+			}
+
+			return process.getErrorKind(ERROR_KIND_EXEC), process.errorfRemote(
 				karma.
 					Describe("cmd", command).
 					Reason(err),
@@ -256,7 +537,80 @@ func (process *Process) Run() error {
 		}
 	}
 
-	return nil
+	return "", nil
+}
+
+// runAfterCommands runs after commands regardless of the result of the job
+// commands, even if the job has timed out. Failures of after commands are
+// written to the log but don't change the result of the job. After commands
+// are not run if the job has been canceled.
+func (process *Process) runAfterCommands(jobErr error) {
+	commands := process.getAfterCommands()
+	if len(commands) == 0 {
+		return
+	}
+
+	if errors.Is(process.ctx.Err(), context.Canceled) {
+		return
+	}
+
+	result := "success"
+	switch {
+	case process.IsTimedOut():
+		result = "timed_out"
+	case jobErr != nil:
+		result = "failed"
+	}
+
+	ctx, cancel := context.WithTimeout(process.ctx, AFTER_COMMANDS_TIMEOUT)
+	defer cancel()
+
+	process.LogMask("\n:: Running after commands\n")
+
+	for _, command := range commands {
+		err := process.execShell(ctx, command, []string{"CI_JOB_STATUS=" + result})
+		if err != nil {
+			err = karma.Describe("cmd", command).Format(err, "after command failed")
+
+			process.log.Warningf(err, "after commands failed")
+			process.LogMask("\n\n" + err.Error() + "\n")
+
+			return
+		}
+	}
+}
+
+func (process *Process) getBeforeCommands() []string {
+	if process.configJob.BeforeCommands != nil {
+		return process.configJob.BeforeCommands
+	}
+
+	return process.configPipeline.BeforeCommands
+}
+
+func (process *Process) getAfterCommands() []string {
+	if process.configJob.AfterCommands != nil {
+		return process.configJob.AfterCommands
+	}
+
+	return process.configPipeline.AfterCommands
+}
+
+// IsTimedOut returns true if the job has been stopped because its commands
+// exceeded the timeout.
+func (process *Process) IsTimedOut() bool {
+	return process.timedOut
+}
+
+func (process *Process) getTimeout() time.Duration {
+	switch {
+	case process.configJob.Timeout > 0:
+		return process.configJob.Timeout.Duration()
+	case process.configPipeline.Timeout > 0:
+		return process.configPipeline.Timeout.Duration()
+	default:
+		return process.runnerConfig.JobTimeout
+	}
 }
 
 func (process *Process) getImage() (string, string) {
@@ -340,7 +694,11 @@ func (process *Process) ErrorfDirect(
 	return err
 }
 
-func (process *Process) execShell(cmd string) error {
+func (process *Process) execShell(
+	ctx context.Context,
+	cmd string,
+	extraEnv []string,
+) error {
 	process.MaskSendPrompt([]string{cmd})
 
 	err := make(chan error, 1)
@@ -348,10 +706,10 @@ func (process *Process) execShell(cmd string) error {
 		defer audit.Go("exec", cmd)()
 
 		err <- process.executor.Exec(
-			process.ctx,
+			ctx,
 			process.container,
 			executor.ExecOptions{
-				Env:            process.env.GetAll(),
+				Env:            append(process.env.GetAll(), extraEnv...),
 				WorkingDir:     process.sidecar.GitDir(),
 				Cmd:            []string{process.shell, "-c", cmd},
 				AttachStdout:   true,
@@ -364,8 +722,10 @@ func (process *Process) execShell(cmd string) error {
 	select {
 	case value := <-err:
 		return value
-	case <-process.ctx.Done():
-		return context.Canceled
+	case <-ctx.Done():
+		// either context.Canceled or context.DeadlineExceeded if the job
+		// has timed out
+		return ctx.Err()
 	}
 }
 
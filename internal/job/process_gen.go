@@ -7,6 +7,7 @@ import (
 
 	"github.com/reconquest/cog"
 	"github.com/reconquest/snake-runner/internal/api"
+	"github.com/reconquest/snake-runner/internal/cache"
 	"github.com/reconquest/snake-runner/internal/config"
 	"github.com/reconquest/snake-runner/internal/executor"
 	"github.com/reconquest/snake-runner/internal/runner"
@@ -17,7 +18,8 @@ import (
 func NewProcess(
 	ctx context.Context,
 	executor executor.Executor,
-	client *api.Client,
+	cache *cache.Cache,
+	client api.Master,
 	runnerConfig *runner.Config,
 	task tasks.PipelineRun,
 	configPipeline config.Pipeline,
@@ -28,6 +30,7 @@ func NewProcess(
 	r := &Process{
 		ctx:             ctx,
 		executor:        executor,
+		cache:           cache,
 		client:          client,
 		runnerConfig:    runnerConfig,
 		task:            task,
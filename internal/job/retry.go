@@ -0,0 +1,69 @@
+package job
+
+import (
+	"context"
+	"errors"
+
+	"github.com/reconquest/snake-runner/internal/config"
+)
+
+// ErrorKind describes at which step a job attempt has failed, it's used to
+// decide whether the job should be retried.
+type ErrorKind string
+
+const (
+	ERROR_KIND_PULL     ErrorKind = "pull"
+	ERROR_KIND_CREATE   ErrorKind = "create"
+	ERROR_KIND_EXEC     ErrorKind = "exec"
+	ERROR_KIND_CANCELED ErrorKind = "canceled"
+)
+
+var retryConditions = map[ErrorKind]string{
+	ERROR_KIND_PULL:   config.RETRY_WHEN_IMAGE_PULL_FAILURE,
+	ERROR_KIND_CREATE: config.RETRY_WHEN_RUNNER_SYSTEM_FAILURE,
+	ERROR_KIND_EXEC:   config.RETRY_WHEN_SCRIPT_FAILURE,
+}
+
+// shouldRetry returns true if the job should be started again after the given
+// failed attempt, attempts are counted from 1.
+func (process *Process) shouldRetry(attempt int, kind ErrorKind) bool {
+	return attempt <= process.configJob.Retry.Max && process.isRetryable(kind)
+}
+
+// isRetryable returns true if a failure of the given kind matches the retry
+// conditions of the job. Canceled and timed out jobs are never retried.
+//
+// Failures of the sidecar and of cloning the repository are not retried by
+// jobs: the sidecar and the clone are shared by all jobs of the pipeline and
+// they are prepared before the config of the pipeline is read, so there is no
+// retry config yet and the pipeline fails as a whole.
+func (process *Process) isRetryable(kind ErrorKind) bool {
+	if process.ctx.Err() != nil || process.timedOut {
+		return false
+	}
+
+	condition, ok := retryConditions[kind]
+	if !ok {
+		return false
+	}
+
+	if len(process.configJob.Retry.When) == 0 {
+		return true
+	}
+
+	for _, when := range process.configJob.Retry.When {
+		if when == condition {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (process *Process) getErrorKind(kind ErrorKind) ErrorKind {
+	if errors.Is(process.ctx.Err(), context.Canceled) {
+		return ERROR_KIND_CANCELED
+	}
+
+	return kind
+}
@@ -0,0 +1,112 @@
+package job
+
+import (
+	"context"
+	"testing"
+
+	"github.com/reconquest/snake-runner/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsRetryable(t *testing.T) {
+	test := assert.New(t)
+
+	testcases := []struct {
+		when     []string
+		kind     ErrorKind
+		expected bool
+	}{
+		{nil, ERROR_KIND_PULL, true},
+		{nil, ERROR_KIND_CREATE, true},
+		{nil, ERROR_KIND_EXEC, true},
+		{nil, ERROR_KIND_CANCELED, false},
+		{nil, "", false},
+
+		{[]string{config.RETRY_WHEN_IMAGE_PULL_FAILURE}, ERROR_KIND_PULL, true},
+		{[]string{config.RETRY_WHEN_IMAGE_PULL_FAILURE}, ERROR_KIND_CREATE, false},
+		{[]string{config.RETRY_WHEN_IMAGE_PULL_FAILURE}, ERROR_KIND_EXEC, false},
+
+		{[]string{config.RETRY_WHEN_RUNNER_SYSTEM_FAILURE}, ERROR_KIND_CREATE, true},
+		{[]string{config.RETRY_WHEN_RUNNER_SYSTEM_FAILURE}, ERROR_KIND_PULL, false},
+
+		{[]string{config.RETRY_WHEN_SCRIPT_FAILURE}, ERROR_KIND_EXEC, true},
+		{[]string{config.RETRY_WHEN_SCRIPT_FAILURE}, ERROR_KIND_CANCELED, false},
+
+		{
+			[]string{
+				config.RETRY_WHEN_SCRIPT_FAILURE,
+				config.RETRY_WHEN_IMAGE_PULL_FAILURE,
+			},
+			ERROR_KIND_PULL,
+			true,
+		},
+	}
+
+	for _, testcase := range testcases {
+		process := &Process{
+			ctx: context.Background(),
+			configJob: config.Job{
+				Retry: config.Retry{Max: 1, When: testcase.when},
+			},
+		}
+
+		test.Equal(
+			testcase.expected,
+			process.isRetryable(testcase.kind),
+			"when: %v, kind: %s", testcase.when, testcase.kind,
+		)
+	}
+}
+
+func TestIsRetryable_CanceledOrTimedOut(t *testing.T) {
+	test := assert.New(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	process := &Process{ctx: ctx}
+	test.False(process.isRetryable(ERROR_KIND_EXEC))
+
+	process = &Process{ctx: context.Background(), timedOut: true}
+	test.False(process.isRetryable(ERROR_KIND_EXEC))
+}
+
+func TestShouldRetry(t *testing.T) {
+	test := assert.New(t)
+
+	process := &Process{
+		ctx:       context.Background(),
+		configJob: config.Job{Retry: config.Retry{Max: 2}},
+	}
+
+	test.True(process.shouldRetry(1, ERROR_KIND_EXEC))
+	test.True(process.shouldRetry(2, ERROR_KIND_EXEC))
+	test.False(process.shouldRetry(3, ERROR_KIND_EXEC))
+	test.False(process.shouldRetry(1, ERROR_KIND_CANCELED))
+
+	process.configJob.Retry.Max = 0
+	test.False(process.shouldRetry(1, ERROR_KIND_EXEC))
+}
+
+func TestGetErrorKind(t *testing.T) {
+	test := assert.New(t)
+
+	process := &Process{ctx: context.Background()}
+	test.Equal(ERROR_KIND_PULL, process.getErrorKind(ERROR_KIND_PULL))
+	test.Equal(ERROR_KIND_EXEC, process.getErrorKind(ERROR_KIND_EXEC))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	process = &Process{ctx: ctx}
+	test.Equal(ERROR_KIND_CANCELED, process.getErrorKind(ERROR_KIND_PULL))
+	test.Equal(ERROR_KIND_CANCELED, process.getErrorKind(ERROR_KIND_CREATE))
+	test.Equal(ERROR_KIND_CANCELED, process.getErrorKind(ERROR_KIND_EXEC))
+
+	// timed out jobs are failed, not canceled
+	ctx, cancel = context.WithTimeout(context.Background(), 0)
+	defer cancel()
+
+	process = &Process{ctx: ctx}
+	test.Equal(ERROR_KIND_EXEC, process.getErrorKind(ERROR_KIND_EXEC))
+}
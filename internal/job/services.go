@@ -0,0 +1,304 @@
+package job
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/reconquest/karma-go"
+	"github.com/reconquest/snake-runner/internal/executor"
+	"github.com/reconquest/snake-runner/internal/sidecar"
+	"github.com/reconquest/snake-runner/internal/utils"
+)
+
+// SERVICE_PROBE_IMAGE is the image of the container which checks ports of
+// services, the sidecar image is always present and it has bash.
+const SERVICE_PROBE_IMAGE = sidecar.CLOUD_SIDECAR_IMAGE
+
+const (
+	SERVICE_WAIT_TIMEOUT = 30 * time.Second
+
+	DNS_LABEL_MAX_LENGTH = 63
+)
+
+type service struct {
+	alias     string
+	container executor.Container
+}
+
+// startServices creates a private network for the job and starts service
+// containers in it, the job container has to be attached to the network.
+// Services which are not ready in time are reported as warnings because
+// readiness can't be detected reliably for every image.
+func (process *Process) startServices() (ErrorKind, error) {
+	if len(process.configJob.Services) == 0 {
+		return "", nil
+	}
+
+	services, ok := process.executor.(executor.ServiceExecutor)
+	if !ok {
+		return "", process.errorfRemote(
+			nil,
+			"services are not supported by %s executor",
+			process.executor.Type(),
+		)
+	}
+
+	var err error
+	process.network, err = services.CreateNetwork(
+		process.ctx,
+		fmt.Sprintf(
+			"pipeline-%d-job-%d-uniq-%v",
+			process.task.Pipeline.ID,
+			process.job.ID,
+			utils.RandString(8),
+		),
+	)
+	if err != nil {
+		return process.getErrorKind(ERROR_KIND_CREATE),
+			process.errorfRemote(err, "unable to create a network for services")
+	}
+
+	for i, config := range process.configJob.Services {
+		image := process.expandEnv(config.Image)
+
+		err := process.executor.Prepare(
+			process.ctx,
+			executor.PrepareOptions{
+				Image:          image,
+				OutputConsumer: process.LogMask,
+				InfoConsumer:   process.LogMask,
+				Auths:          process.contextPullAuth.List(),
+				PullPolicy:     process.getPullPolicy(),
+			},
+		)
+		if err != nil {
+			return process.getErrorKind(ERROR_KIND_PULL),
+				process.errorfRemote(err, "unable to pull service image %q", image)
+		}
+
+		env := []string{}
+
+		var healthcheck []string
+		if config.IsDockerInDocker() {
+			// the daemon listens on the plain tcp port without TLS,
+			// variables of the service still can override it
+			env = append(env, "DOCKER_TLS_CERTDIR=")
+			healthcheck = []string{"docker", "info"}
+		}
+
+		if config.Variables != nil {
+			for _, pair := range config.Variables.Pairs() {
+				env = append(env, pair.Key+"="+process.expandEnv(pair.Value))
+			}
+		}
+
+		container, err := services.CreateService(
+			process.ctx,
+			executor.ServiceOptions{
+				Name: fmt.Sprintf(
+					"pipeline-%d-job-%d-service-%d-uniq-%v",
+					process.task.Pipeline.ID,
+					process.job.ID,
+					i+1,
+					utils.RandString(8),
+				),
+				Image:   image,
+				Alias:   config.Alias,
+				Network: process.network,
+				Env:     env,
+				Cmd:     config.Command,
+
+				Resources: process.runnerConfig.GetDockerResources(),
+
+				Privileged:  config.IsDockerInDocker(),
+				Healthcheck: healthcheck,
+			},
+		)
+		if container != nil {
+			process.services = append(process.services, service{
+				alias:     config.Alias,
+				container: container,
+			})
+		}
+		if err != nil {
+			return process.getErrorKind(ERROR_KIND_CREATE),
+				process.errorfRemote(err, "unable to start service %q", config.Alias)
+		}
+	}
+
+	probe, err := process.createServiceProbe()
+	if probe != nil {
+		defer process.destroyServiceProbe(probe)
+	}
+	if err != nil {
+		return process.getErrorKind(ERROR_KIND_CREATE),
+			process.errorfRemote(err, "unable to create container to check services")
+	}
+
+	for _, service := range process.services {
+		process.LogMask(
+			fmt.Sprintf("\n:: Waiting for service %s\n", service.alias),
+		)
+
+		err := services.WaitService(
+			process.ctx,
+			service.container,
+			executor.WaitServiceOptions{
+				Timeout: SERVICE_WAIT_TIMEOUT,
+				Probe:   probe,
+			},
+		)
+		if err != nil {
+			if utils.IsCanceled(err) || process.ctx.Err() != nil {
+				return process.getErrorKind(ERROR_KIND_CREATE), err
+			}
+
+			process.log.Warningf(err, "service %s is not ready", service.alias)
+
+			process.LogMask(
+				fmt.Sprintf(
+					"\nWARNING: service %s is not ready: %s\n",
+					service.alias, err,
+				),
+			)
+		}
+	}
+
+	return "", nil
+}
+
+// createServiceProbe creates the container which checks ports of services in
+// the network of the job.
+func (process *Process) createServiceProbe() (executor.Container, error) {
+	err := process.executor.Prepare(
+		process.ctx,
+		executor.PrepareOptions{
+			Image:          SERVICE_PROBE_IMAGE,
+			OutputConsumer: process.LogMask,
+			InfoConsumer:   executor.DiscardConsumer,
+		},
+	)
+	if err != nil {
+		return nil, karma.Format(err, "unable to pull image %q", SERVICE_PROBE_IMAGE)
+	}
+
+	return process.executor.Create(
+		process.ctx,
+		executor.CreateOptions{
+			Name: fmt.Sprintf(
+				"pipeline-%d-job-%d-probe-uniq-%v",
+				process.task.Pipeline.ID,
+				process.job.ID,
+				utils.RandString(8),
+			),
+			Image:    SERVICE_PROBE_IMAGE,
+			Networks: []executor.Network{process.network},
+		},
+	)
+}
+
+func (process *Process) destroyServiceProbe(probe executor.Container) {
+	err := process.executor.Destroy(context.Background(), probe)
+	if err != nil {
+		process.log.Errorf(
+			karma.
+				Describe("id", probe.ID()).
+				Describe("container", probe.String()).
+				Reason(err),
+			"unable to destroy probe container",
+		)
+	}
+}
+
+// logServices writes output of all services to the job log.
+func (process *Process) logServices() {
+	services, ok := process.executor.(executor.ServiceExecutor)
+	if !ok {
+		return
+	}
+
+	for _, service := range process.services {
+		process.LogMask(
+			fmt.Sprintf("\n:: Output of service %s\n", service.alias),
+		)
+
+		err := services.ServiceLogs(
+			context.Background(),
+			service.container,
+			process.LogMask,
+		)
+		if err != nil {
+			process.log.Errorf(
+				err,
+				"unable to obtain output of service %s", service.alias,
+			)
+		}
+	}
+}
+
+// destroyServices destroys service containers and the network of the job.
+func (process *Process) destroyServices() {
+	services, ok := process.executor.(executor.ServiceExecutor)
+	if !ok {
+		return
+	}
+
+	for _, service := range process.services {
+		err := process.executor.Destroy(context.Background(), service.container)
+		if err != nil {
+			process.log.Errorf(
+				karma.
+					Describe("id", service.container.ID()).
+					Describe("container", service.container.String()).
+					Reason(err),
+				"unable to destroy service container",
+			)
+		}
+	}
+
+	process.services = nil
+
+	if process.network != nil {
+		err := services.DestroyNetwork(context.Background(), process.network)
+		if err != nil {
+			process.log.Errorf(
+				karma.
+					Describe("id", process.network.ID()).
+					Describe("network", process.network.String()).
+					Reason(err),
+				"unable to destroy network",
+			)
+		}
+
+		process.network = nil
+	}
+}
+
+// getAlias returns the DNS name of the job container in the pipeline
+// network, it's the job name with characters which are not allowed in DNS
+// names replaced by dashes, e.g. "test 1/2" → "test-1-2".
+func getAlias(name string) string {
+	alias := strings.Map(func(char rune) rune {
+		switch {
+		case char >= 'a' && char <= 'z', char >= '0' && char <= '9':
+			return char
+		case char >= 'A' && char <= 'Z':
+			return char - 'A' + 'a'
+		default:
+			return '-'
+		}
+	}, name)
+
+	for strings.Contains(alias, "--") {
+		alias = strings.ReplaceAll(alias, "--", "-")
+	}
+
+	alias = strings.Trim(alias, "-")
+	if len(alias) > DNS_LABEL_MAX_LENGTH {
+		alias = strings.TrimRight(alias[:DNS_LABEL_MAX_LENGTH], "-")
+	}
+
+	return alias
+}
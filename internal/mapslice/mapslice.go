@@ -53,8 +53,13 @@ func (slice *MapSlice) Map() map[string]string {
 
 func (slice *MapSlice) UnmarshalYAML(value *yaml.Node) error {
 	result, err := New(*value)
+	if err != nil {
+		return err
+	}
+
 	*slice = *result
-	return err
+
+	return nil
 }
 
 func (slice *MapSlice) Find(key string) *Pair {
@@ -67,6 +72,29 @@ func (slice *MapSlice) Find(key string) *Pair {
 	return nil
 }
 
+// Clone returns a copy of the slice, pairs are copied as well.
+func (slice *MapSlice) Clone() *MapSlice {
+	result := &MapSlice{pairs: []*Pair{}}
+	for _, pair := range slice.Pairs() {
+		result.pairs = append(result.pairs, &Pair{Key: pair.Key, Value: pair.Value})
+	}
+
+	return result
+}
+
+// Set replaces value of the existing key keeping its position or appends a
+// new pair if there is no such key.
+func (slice *MapSlice) Set(key, value string) {
+	for _, pair := range slice.pairs {
+		if pair.Key == key {
+			pair.Value = value
+			return
+		}
+	}
+
+	slice.pairs = append(slice.pairs, &Pair{Key: key, Value: value})
+}
+
 type Pair struct {
 	Key   string
 	Value string
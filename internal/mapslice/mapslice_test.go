@@ -122,3 +122,19 @@ v:
 	test.NotNil(config.V)
 	test.Len(config.V.Pairs(), 1)
 }
+
+func TestMapslice_CloneSet(t *testing.T) {
+	test := assert.New(t)
+
+	original := FromPairs("a", "1", "b", "2")
+
+	clone := original.Clone()
+	clone.Set("a", "3")
+	clone.Set("c", "4")
+
+	test.Equal([]*Pair{{"a", "1"}, {"b", "2"}}, original.Pairs())
+	test.Equal([]*Pair{{"a", "3"}, {"b", "2"}, {"c", "4"}}, clone.Pairs())
+
+	var empty *MapSlice
+	test.Equal([]*Pair{}, empty.Clone().Pairs())
+}
@@ -0,0 +1,229 @@
+package pipeline
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/reconquest/snake-runner/internal/config"
+	"github.com/reconquest/snake-runner/internal/snake"
+)
+
+type jobState struct {
+	done    chan struct{}
+	started bool
+	skipped bool
+}
+
+// graph tracks dependencies between jobs of the pipeline. A job depends on
+// the jobs listed in its needs or, if needs is not specified, on every job of
+// the previous stages.
+type graph struct {
+	mutex sync.Mutex
+
+	stages [][]snake.PipelineJob
+	states map[int]*jobState
+
+	// needs is nil until the graph is configured with the pipeline config
+	needs map[int][]int
+}
+
+func newGraph(stages [][]snake.PipelineJob) *graph {
+	graph := &graph{
+		stages: stages,
+		states: map[int]*jobState{},
+	}
+
+	for _, stageJobs := range stages {
+		for _, job := range stageJobs {
+			graph.states[job.ID] = &jobState{done: make(chan struct{})}
+		}
+	}
+
+	return graph
+}
+
+// configure resolves dependencies of jobs using the given config, it fails if
+// a job needs another job which is not a part of the pipeline.
+func (graph *graph) configure(pipeline config.Pipeline) error {
+	ids := map[string]int{}
+	for _, stageJobs := range graph.stages {
+		for _, job := range stageJobs {
+			ids[job.Name] = job.ID
+		}
+	}
+
+	needs := map[int][]int{}
+
+	previous := []int{}
+	for _, stageJobs := range graph.stages {
+		for _, job := range stageJobs {
+			configJob, ok := pipeline.Jobs[job.Name]
+			if !ok || configJob.Needs == nil {
+				needs[job.ID] = previous
+				continue
+			}
+
+			needs[job.ID] = []int{}
+			for _, name := range configJob.Needs {
+				id, ok := ids[name]
+				if !ok {
+					return fmt.Errorf(
+						"job %q needs job %q which is not a part of the pipeline",
+						job.Name, name,
+					)
+				}
+
+				needs[job.ID] = append(needs[job.ID], id)
+			}
+		}
+
+		for _, job := range stageJobs {
+			previous = append(previous, job.ID)
+		}
+	}
+
+	graph.mutex.Lock()
+	graph.needs = needs
+	graph.mutex.Unlock()
+
+	return nil
+}
+
+// expand replaces the job with the jobs it's expanded into by matrix or
+// parallel, the first of them has the ID of the replaced job.
+func (graph *graph) expand(id int, jobs []snake.PipelineJob) {
+	graph.mutex.Lock()
+	defer graph.mutex.Unlock()
+
+	for i, stageJobs := range graph.stages {
+		for j, job := range stageJobs {
+			if job.ID != id {
+				continue
+			}
+
+			expanded := append([]snake.PipelineJob{}, stageJobs[:j]...)
+			expanded = append(expanded, jobs...)
+			expanded = append(expanded, stageJobs[j+1:]...)
+
+			graph.stages[i] = expanded
+
+			for _, job := range jobs[1:] {
+				graph.states[job.ID] = &jobState{done: make(chan struct{})}
+			}
+
+			return
+		}
+	}
+}
+
+// wait blocks until all dependencies of the given job are finished.
+func (graph *graph) wait(id int) {
+	graph.mutex.Lock()
+	done := []chan struct{}{}
+	for _, need := range graph.needs[id] {
+		done = append(done, graph.states[need].done)
+	}
+	graph.mutex.Unlock()
+
+	for _, ch := range done {
+		<-ch
+	}
+}
+
+// dependencies returns IDs of the jobs the given job depends on directly.
+func (graph *graph) dependencies(id int) []int {
+	graph.mutex.Lock()
+	defer graph.mutex.Unlock()
+
+	return graph.needs[id]
+}
+
+// start marks the job as started, it returns false if the job has been
+// skipped and must not be started.
+func (graph *graph) start(id int) bool {
+	graph.mutex.Lock()
+	defer graph.mutex.Unlock()
+
+	state := graph.states[id]
+	if state.skipped {
+		return false
+	}
+
+	state.started = true
+
+	return true
+}
+
+func (graph *graph) isSkipped(id int) bool {
+	graph.mutex.Lock()
+	defer graph.mutex.Unlock()
+
+	return graph.states[id].skipped
+}
+
+func (graph *graph) finish(id int) {
+	graph.mutex.Lock()
+	defer graph.mutex.Unlock()
+
+	close(graph.states[id].done)
+}
+
+// skipDependents marks every job that directly or transitively depends on the
+// failed job as skipped and returns the ones which have not been started yet.
+// If the graph is not configured yet all other jobs are skipped because
+// their dependencies are unknown.
+func (graph *graph) skipDependents(failedID int) []snake.PipelineJob {
+	graph.mutex.Lock()
+	defer graph.mutex.Unlock()
+
+	dependents := map[int]struct{}{}
+	if graph.needs == nil {
+		for id := range graph.states {
+			if id != failedID {
+				dependents[id] = struct{}{}
+			}
+		}
+	} else {
+		queue := []int{failedID}
+		for len(queue) > 0 {
+			current := queue[0]
+			queue = queue[1:]
+
+			for id, needs := range graph.needs {
+				if _, ok := dependents[id]; ok {
+					continue
+				}
+
+				for _, need := range needs {
+					if need == current {
+						dependents[id] = struct{}{}
+						queue = append(queue, id)
+						break
+					}
+				}
+			}
+		}
+	}
+
+	skipped := []snake.PipelineJob{}
+	for _, stageJobs := range graph.stages {
+		for _, job := range stageJobs {
+			if _, ok := dependents[job.ID]; !ok {
+				continue
+			}
+
+			state := graph.states[job.ID]
+			if state.skipped {
+				continue
+			}
+
+			state.skipped = true
+
+			if !state.started {
+				skipped = append(skipped, job)
+			}
+		}
+	}
+
+	return skipped
+}
@@ -0,0 +1,184 @@
+package pipeline
+
+import (
+	"testing"
+	"time"
+
+	"github.com/reconquest/snake-runner/internal/config"
+	"github.com/reconquest/snake-runner/internal/snake"
+	"github.com/stretchr/testify/assert"
+)
+
+func getTestGraph() *graph {
+	return newGraph([][]snake.PipelineJob{
+		{{ID: 1, Name: "build"}, {ID: 2, Name: "lint"}},
+		{{ID: 3, Name: "test"}},
+		{{ID: 4, Name: "deploy"}, {ID: 5, Name: "docs"}},
+	})
+}
+
+func getJobIDs(jobs []snake.PipelineJob) []int {
+	ids := []int{}
+	for _, job := range jobs {
+		ids = append(ids, job.ID)
+	}
+
+	return ids
+}
+
+func TestGraph_Configure(t *testing.T) {
+	test := assert.New(t)
+
+	graph := getTestGraph()
+	test.NoError(graph.configure(config.Pipeline{
+		Jobs: map[string]config.Job{
+			"build":  {},
+			"test":   {},
+			"deploy": {Needs: []string{"build"}},
+			"docs":   {Needs: []string{}},
+		},
+	}))
+
+	test.Equal([]int{}, graph.dependencies(1))
+	test.Equal([]int{}, graph.dependencies(2))
+	test.Equal([]int{1, 2}, graph.dependencies(3))
+	test.Equal([]int{1}, graph.dependencies(4))
+	test.Equal([]int{}, graph.dependencies(5))
+}
+
+func TestGraph_Configure_PreviousStages(t *testing.T) {
+	test := assert.New(t)
+
+	// jobs missing in the config depend on all jobs of previous stages
+	graph := getTestGraph()
+	test.NoError(graph.configure(config.Pipeline{}))
+
+	test.Equal([]int{}, graph.dependencies(1))
+	test.Equal([]int{}, graph.dependencies(2))
+	test.Equal([]int{1, 2}, graph.dependencies(3))
+	test.Equal([]int{1, 2, 3}, graph.dependencies(4))
+	test.Equal([]int{1, 2, 3}, graph.dependencies(5))
+}
+
+func TestGraph_Configure_MissingNeeds(t *testing.T) {
+	test := assert.New(t)
+
+	graph := getTestGraph()
+	err := graph.configure(config.Pipeline{
+		Jobs: map[string]config.Job{
+			"deploy": {Needs: []string{"package"}},
+		},
+	})
+	test.EqualError(
+		err,
+		`job "deploy" needs job "package" which is not a part of the pipeline`,
+	)
+
+	// the graph stays unconfigured
+	test.Nil(graph.dependencies(4))
+}
+
+func TestGraph_Expand(t *testing.T) {
+	test := assert.New(t)
+
+	graph := getTestGraph()
+	graph.expand(1, []snake.PipelineJob{
+		{ID: 1, Name: "build 1/3"},
+		{ID: 6, Name: "build 2/3"},
+		{ID: 7, Name: "build 3/3"},
+	})
+
+	test.Equal([]int{1, 6, 7, 2}, getJobIDs(graph.stages[0]))
+	test.Equal("build 1/3", graph.stages[0][0].Name)
+	test.Contains(graph.states, 6)
+	test.Contains(graph.states, 7)
+
+	test.NoError(graph.configure(config.Pipeline{
+		Jobs: map[string]config.Job{
+			"deploy": {Needs: []string{"build 1/3", "build 3/3"}},
+		},
+	}))
+
+	test.Equal([]int{1, 6, 7, 2}, graph.dependencies(3))
+	test.Equal([]int{1, 7}, graph.dependencies(4))
+
+	// unknown jobs are ignored
+	graph.expand(100, []snake.PipelineJob{{ID: 100}, {ID: 101}})
+	test.NotContains(graph.states, 101)
+}
+
+func TestGraph_SkipDependents(t *testing.T) {
+	test := assert.New(t)
+
+	graph := getTestGraph()
+	test.NoError(graph.configure(config.Pipeline{
+		Jobs: map[string]config.Job{
+			"deploy": {Needs: []string{"test"}},
+			"docs":   {Needs: []string{"lint"}},
+		},
+	}))
+
+	test.True(graph.start(1))
+	test.True(graph.start(3))
+
+	// test depends on build directly and deploy depends on it transitively,
+	// test is started already, so only deploy has to be reported
+	test.Equal([]int{4}, getJobIDs(graph.skipDependents(1)))
+
+	test.True(graph.isSkipped(3))
+	test.True(graph.isSkipped(4))
+	test.False(graph.isSkipped(2))
+	test.False(graph.isSkipped(5))
+
+	test.False(graph.start(4))
+	test.True(graph.start(5))
+
+	// skipped jobs are not reported twice
+	test.Equal([]int{}, getJobIDs(graph.skipDependents(3)))
+	test.Equal([]int{}, getJobIDs(graph.skipDependents(2)))
+	test.True(graph.isSkipped(5))
+}
+
+func TestGraph_SkipDependents_Unconfigured(t *testing.T) {
+	test := assert.New(t)
+
+	graph := getTestGraph()
+	test.True(graph.start(2))
+
+	// dependencies are unknown, so all other jobs are skipped
+	test.Equal([]int{3, 4, 5}, getJobIDs(graph.skipDependents(1)))
+	test.False(graph.isSkipped(1))
+	test.True(graph.isSkipped(2))
+}
+
+func TestGraph_Wait(t *testing.T) {
+	test := assert.New(t)
+
+	graph := getTestGraph()
+	test.NoError(graph.configure(config.Pipeline{}))
+
+	done := make(chan struct{})
+	go func() {
+		graph.wait(3)
+		close(done)
+	}()
+
+	graph.finish(1)
+
+	select {
+	case <-done:
+		test.Fail("job is started before all its dependencies are finished")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	graph.finish(2)
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		test.Fail("job is not started after its dependencies are finished")
+	}
+
+	// jobs without dependencies don't wait at all
+	graph.wait(1)
+}
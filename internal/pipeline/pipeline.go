@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"runtime/debug"
+	"strings"
 	"sync"
 	"time"
 
@@ -12,12 +13,15 @@ import (
 	"github.com/reconquest/karma-go"
 	"github.com/reconquest/pkg/log"
 	"github.com/reconquest/snake-runner/internal/api"
+	"github.com/reconquest/snake-runner/internal/artifacts"
 	"github.com/reconquest/snake-runner/internal/audit"
+	"github.com/reconquest/snake-runner/internal/cache"
 	"github.com/reconquest/snake-runner/internal/config"
 	"github.com/reconquest/snake-runner/internal/env"
 	"github.com/reconquest/snake-runner/internal/executor"
 	"github.com/reconquest/snake-runner/internal/job"
 	"github.com/reconquest/snake-runner/internal/ptr"
+	"github.com/reconquest/snake-runner/internal/rules"
 	"github.com/reconquest/snake-runner/internal/runner"
 	"github.com/reconquest/snake-runner/internal/sidecar"
 	"github.com/reconquest/snake-runner/internal/signal"
@@ -36,10 +40,11 @@ const (
 type Process struct {
 	parentCtx    context.Context
 	ctx          context.Context
-	client       *api.Client
+	client       api.Master
 	runnerConfig *runner.Config
 	task         tasks.PipelineRun
 	executor     executor.Executor
+	cache        *cache.Cache
 	log          *cog.Logger
 
 	sshKey sshkey.Key
@@ -48,12 +53,41 @@ type Process struct {
 	sidecar sidecar.Sidecar `gonstructor:"-"`
 	config  config.Pipeline `gonstructor:"-"`
 
+	// artifacts keeps artifacts and dotenv variables of finished jobs
+	artifacts *artifacts.Store `gonstructor:"-"`
+
+	// network isolates containers of the pipeline from containers of other
+	// pipelines, it's nil if the executor doesn't support networks
+	network executor.Network `gonstructor:"-"`
+
+	// releaseHost releases the host acquired by the pipeline, it's nil if
+	// the executor isn't a pool of hosts
+	releaseHost func() `gonstructor:"-"`
+
+	// changes is a list of files changed by the pipeline commit, it's nil if
+	// changes are unknown
+	changes []string `gonstructor:"-"`
+
 	auth struct {
 		variable    executor.Auths `gonstructor:"-"`
 		environment executor.Auths `gonstructor:"-"`
 	} `gonstructor:"-"`
 
+	// jobsMutex guards task.Jobs, matrix and parallel jobs are replaced
+	// with the jobs they are expanded into once the config is read
+	jobsMutex sync.Mutex `gonstructor:"-"`
+
+	// workers are goroutines of jobs, the result is the status and the error
+	// of the first failed job
+	workers *sync.WaitGroup `gonstructor:"-"`
+	result  struct {
+		once   sync.Once
+		status status.Status
+		err    error
+	} `gonstructor:"-"`
+
 	onceFail   sync.Once `gonstructor:"-"`
+	graph      *graph    `gonstructor:"-"`
 	configCond signal.Condition
 }
 
@@ -88,6 +122,15 @@ func (process *Process) Run() error {
 		return err
 	}
 
+	process.acquireHost()
+
+	err = process.createNetwork()
+	if err != nil {
+		process.status = status.FAILED
+		process.fail(FAIL_ALL_JOBS)
+		return err
+	}
+
 	process.status, err = process.runJobs()
 	if err != nil {
 		return err
@@ -112,8 +155,10 @@ func (process *Process) Run() error {
 }
 
 func (process *Process) splitJobs() [][]snake.PipelineJob {
+	jobs := process.getJobs()
+
 	stages := []string{}
-	for _, job := range process.task.Jobs {
+	for _, job := range jobs {
 		found := false
 		for _, stage := range stages {
 			if stage == job.Stage {
@@ -130,7 +175,7 @@ func (process *Process) splitJobs() [][]snake.PipelineJob {
 	for _, stage := range stages {
 		stageJobs := []snake.PipelineJob{}
 
-		for _, job := range process.task.Jobs {
+		for _, job := range jobs {
 			if job.Stage == stage {
 				stageJobs = append(stageJobs, job)
 			}
@@ -142,49 +187,229 @@ func (process *Process) splitJobs() [][]snake.PipelineJob {
 	return result
 }
 
+func (process *Process) getJobs() []snake.PipelineJob {
+	process.jobsMutex.Lock()
+	defer process.jobsMutex.Unlock()
+
+	return append([]snake.PipelineJob{}, process.task.Jobs...)
+}
+
+// getJob returns the job with the given ID, the job could be renamed when it
+// has been expanded by matrix or parallel.
+func (process *Process) getJob(id int) snake.PipelineJob {
+	process.jobsMutex.Lock()
+	defer process.jobsMutex.Unlock()
+
+	for _, job := range process.task.Jobs {
+		if job.ID == id {
+			return job
+		}
+	}
+
+	panic(fmt.Sprintf("BUG: no such job in pipeline: %d", id))
+}
+
 func (process *Process) runJobs() (status.Status, error) {
-	var once sync.Once
-	var resultStatus status.Status
-	var resultErr error
+	process.graph = newGraph(process.splitJobs())
+	process.artifacts = artifacts.NewStore()
+	process.workers = &sync.WaitGroup{}
 
-	total := len(process.task.Jobs)
-	index := 0
-	for _, stageJobs := range process.splitJobs() {
-		workers := &sync.WaitGroup{}
-
-		for _, job := range stageJobs {
-			index++
-			workers.Add(1)
-			go func(index int, job snake.PipelineJob) {
-				defer audit.Go("job", index, job.ID)()
-				defer workers.Done()
-
-				status, err := process.runJob(total, index, job)
-				if err != nil {
-					once.Do(func() {
-						resultStatus = status
-						resultErr = err
-
-						process.fail(job.ID)
-					})
-				}
-			}(index, job)
+	for i, job := range process.getJobs() {
+		process.startJob(i+1, job)
+	}
+
+	process.workers.Wait()
+
+	if process.result.err != nil {
+		return process.result.status, process.result.err
+	}
+
+	return status.SUCCESS, nil
+}
+
+// startJob runs the job in its own goroutine, jobs are started when the
+// pipeline starts and when matrix and parallel jobs are expanded.
+func (process *Process) startJob(index int, job snake.PipelineJob) {
+	process.workers.Add(1)
+	go func() {
+		defer audit.Go("job", index, job.ID)()
+		defer process.workers.Done()
+		defer process.graph.finish(job.ID)
+
+		// the first job reads the config, so its dependencies are known
+		// only after it has been started, see processJob
+		if index != 1 {
+			if !process.configCond.Wait() {
+				return
+			}
+
+			// the job could be expanded by matrix or parallel
+			job = process.getJob(job.ID)
+
+			process.graph.wait(job.ID)
+		}
+
+		if !process.graph.start(job.ID) {
+			return
 		}
 
-		workers.Wait()
+		if index != 1 && !process.shouldRun(job) {
+			process.skip(job)
+			return
+		}
+
+		status, err := process.runJob(index, job)
+		if err != nil {
+			if process.isFailureAllowed(job, status) {
+				process.log.Warningf(
+					nil,
+					"job %d failed with status %s but it is allowed to fail",
+					job.ID, status,
+				)
+				return
+			}
+
+			process.result.once.Do(func() {
+				process.result.status = status
+				process.result.err = err
+			})
 
-		if resultErr != nil {
-			return resultStatus, resultErr
+			process.fail(job.ID)
 		}
+	}()
+}
+
+// expandJobs reports matrix and parallel jobs to the master and replaces them
+// with the jobs they are expanded into. The first of the expanded jobs keeps
+// the ID of the original job, so its goroutine runs it, goroutines for the
+// rest of them are started here.
+func (process *Process) expandJobs() error {
+	if len(process.config.Expanded) == 0 {
+		return nil
 	}
 
-	return status.SUCCESS, nil
+	process.jobsMutex.Lock()
+	defer process.jobsMutex.Unlock()
+
+	result := []snake.PipelineJob{}
+	created := []snake.PipelineJob{}
+	for _, job := range process.task.Jobs {
+		names, ok := process.config.Expanded[job.Name]
+		if !ok {
+			result = append(result, job)
+			continue
+		}
+
+		jobs, err := process.client.ExpandJob(
+			process.task.Pipeline.ID,
+			job.ID,
+			names,
+		)
+		if err != nil {
+			return karma.
+				Describe("job", job.Name).
+				Format(err, "unable to report expanded jobs to master")
+		}
+
+		if len(jobs) != len(names) || jobs[0].ID != job.ID {
+			return karma.
+				Describe("job", job.Name).
+				Describe("expected", len(names)).
+				Describe("actual", len(jobs)).
+				Format(nil, "master returned unexpected list of expanded jobs")
+		}
+
+		process.log.Infof(
+			nil,
+			"job expanded: id=%d into %d jobs", job.ID, len(jobs),
+		)
+
+		process.graph.expand(job.ID, jobs)
+
+		result = append(result, jobs...)
+		created = append(created, jobs[1:]...)
+	}
+
+	total := len(process.task.Jobs)
+
+	process.task.Jobs = result
+
+	for i, job := range created {
+		process.startJob(total+i+1, job)
+	}
+
+	return nil
+}
+
+// shouldRun returns true if the job matches its only, except and rules
+// conditions.
+func (process *Process) shouldRun(job snake.PipelineJob) bool {
+	configJob, ok := process.config.Jobs[job.Name]
+	if !ok {
+		// the job will fail on its own with a proper error message
+		return true
+	}
+
+	jobEnv := env.NewBuilder(
+		process.task,
+		process.task.Pipeline,
+		job,
+		process.config,
+		configJob,
+		process.artifacts.Dotenv(process.graph.dependencies(job.ID)),
+		process.runnerConfig,
+		process.sidecar.GitDir(),
+		process.sidecar.SshSocketPath(),
+		process.sidecar.SshKnownHostsPath(),
+	).Build()
+
+	return rules.ShouldRun(configJob, jobEnv, process.changes)
+}
+
+// skip reports the job as skipped without running it.
+func (process *Process) skip(job snake.PipelineJob) {
+	process.log.Infof(nil, "skipping job: id=%d, rules don't match", job.ID)
+
+	now := ptr.TimePtr(utils.Now())
+
+	err := process.updateJob(job.ID, status.SKIPPED, nil, now)
+	if err != nil {
+		process.log.Errorf(
+			err,
+			"unable to update job status to %q",
+			status.SKIPPED,
+		)
+	}
+}
+
+// isFailureAllowed returns true if the failed job has allow_failure set and
+// its failure should not fail the pipeline. Canceled jobs are never allowed
+// to fail because cancellation applies to the whole pipeline.
+func (process *Process) isFailureAllowed(
+	job snake.PipelineJob,
+	result status.Status,
+) bool {
+	if result != status.FAILED && result != status.TIMED_OUT {
+		return false
+	}
+
+	// the first job is expanded while it's running
+	job = process.getJob(job.ID)
+
+	configJob, ok := process.config.Jobs[job.Name]
+	if !ok {
+		return false
+	}
+
+	return configJob.AllowFailure
 }
 
 func (process *Process) runJob(
-	total, index int,
+	index int,
 	job snake.PipelineJob,
 ) (status.Status, error) {
+	total := len(process.getJobs())
+
 	process.log.Infof(
 		nil,
 		"%d/%d starting job: id=%d",
@@ -268,6 +493,7 @@ func (process *Process) processJob(
 	task = job.NewProcess(
 		process.ctx,
 		process.executor,
+		process.cache,
 		process.client,
 		process.runnerConfig,
 		process.task,
@@ -302,15 +528,36 @@ func (process *Process) processJob(
 		}
 
 		process.configCond.Satisfy()
-	} else {
-		process.configCond.Wait()
+
+		// the job could be expanded by matrix or parallel
+		target = process.getJob(target.ID)
+		task.SetJob(target)
+
+		process.graph.wait(target.ID)
+		if process.graph.isSkipped(target.ID) {
+			task.LogDirect("\n\nthe job is skipped because its dependencies failed\n")
+
+			return status.SKIPPED, nil
+		}
+
+		if !process.shouldRun(target) {
+			task.LogDirect("\n\nthe job is skipped because its rules don't match\n")
+
+			return status.SKIPPED, nil
+		}
 	}
 
 	task.SetSidecar(process.sidecar)
+	task.SetPipelineNetwork(process.network)
 	task.SetConfigPipeline(process.config)
+	task.SetArtifacts(process.artifacts, process.graph.dependencies(target.ID))
 
 	err = task.Run()
 	if err != nil {
+		if task.IsTimedOut() {
+			return status.TIMED_OUT, err
+		}
+
 		if utils.IsCanceled(err) {
 			// special case when runner gets terminated
 			if utils.IsDone(process.parentCtx) {
@@ -360,7 +607,35 @@ func (process *Process) readConfig(job *job.Process) error {
 		)
 	}
 
-	process.config, err = config.Unmarshal([]byte(yamlContents))
+	var problems config.Problems
+
+	document, err := config.NewDocument([]byte(yamlContents))
+	if err != nil {
+		// syntax errors are reported by the linter along with their positions
+		problems = config.Lint([]byte(yamlContents))
+	} else {
+		err = document.Include(process.task.Pipeline.Filename, process.readInclude)
+		if err != nil {
+			return karma.Format(
+				err,
+				"unable to include files into %q",
+				process.task.Pipeline.Filename,
+			)
+		}
+
+		problems = config.LintDocument(document)
+	}
+
+	if len(problems) > 0 {
+		return problems.Reason(
+			fmt.Sprintf(
+				"pipeline file %q is not valid",
+				process.task.Pipeline.Filename,
+			),
+		)
+	}
+
+	process.config, err = config.UnmarshalDocument(document)
 	if err != nil {
 		return karma.Format(
 			err,
@@ -369,9 +644,91 @@ func (process *Process) readConfig(job *job.Process) error {
 		)
 	}
 
+	err = process.expandJobs()
+	if err != nil {
+		return err
+	}
+
+	err = process.graph.configure(process.config)
+	if err != nil {
+		return karma.Format(
+			err,
+			"invalid job dependencies in %q",
+			process.task.Pipeline.Filename,
+		)
+	}
+
+	if rules.HasChanges(process.config) {
+		process.changes = process.listChanges()
+	}
+
 	return nil
 }
 
+// readInclude reads the file included into the pipeline file, files of other
+// repositories are fetched by the sidecar.
+func (process *Process) readInclude(source config.IncludeSource) ([]byte, error) {
+	if source.Project == "" {
+		data, err := process.sidecar.ReadFile(
+			process.ctx,
+			process.sidecar.GitDir(),
+			source.Path,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		return []byte(data), nil
+	}
+
+	url, err := process.task.GetRepositoryCloneURL(source.Project)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := process.sidecar.ReadRemoteFile(
+		process.ctx,
+		url,
+		source.Ref,
+		source.Path,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return []byte(data), nil
+}
+
+// listChanges returns files changed since the previous commit of the ref, nil
+// is returned if the previous commit is unknown, e.g. the branch is new.
+func (process *Process) listChanges() []string {
+	from := process.task.Pipeline.FromCommit
+	if strings.Trim(from, "0") == "" {
+		process.log.Debugf(
+			nil,
+			"previous commit is unknown, all changes conditions will match",
+		)
+
+		return nil
+	}
+
+	changes, err := process.sidecar.ListChanges(
+		process.ctx,
+		from,
+		process.task.Pipeline.Commit,
+	)
+	if err != nil {
+		process.log.Warningf(
+			err,
+			"unable to list changed files, all changes conditions will match",
+		)
+
+		return nil
+	}
+
+	return changes
+}
+
 func (process *Process) buildSidecar(job *job.Process) sidecar.Sidecar {
 	name := fmt.Sprintf(
 		"pipeline-%d-uniq-%s", process.task.Pipeline.ID, utils.RandString(10),
@@ -384,7 +741,7 @@ func (process *Process) buildSidecar(job *job.Process) sidecar.Sidecar {
 	job.SetupMaskWriter(env.NewEnv(process.task.Env))
 
 	switch process.runnerConfig.Mode {
-	case runner.RUNNER_MODE_DOCKER:
+	case runner.RUNNER_MODE_DOCKER, runner.RUNNER_MODE_PODMAN:
 		var volumes []executor.Volume
 
 		for _, volume := range process.runnerConfig.Sidecar.Docker.Volumes {
@@ -400,6 +757,7 @@ func (process *Process) buildSidecar(job *job.Process) sidecar.Sidecar {
 			OutputConsumer(job.LogMask).
 			SshKey(process.sshKey).
 			Volumes(volumes).
+			Network(process.network).
 			Build()
 	case runner.RUNNER_MODE_SHELL:
 		return sidecar.NewShellSidecarBuilder().
@@ -411,58 +769,52 @@ func (process *Process) buildSidecar(job *job.Process) sidecar.Sidecar {
 			OutputConsumer(job.LogMask).
 			SshKey(process.sshKey).
 			Build()
+	case runner.RUNNER_MODE_SSH:
+		return sidecar.NewSshSidecarBuilder().
+			Executor(process.executor).
+			Name(name).
+			Slug(slug).
+			PromptConsumer(job.MaskSendPrompt).
+			OutputConsumer(job.LogMask).
+			SshKey(process.sshKey).
+			Build()
 
 	default:
 		panic("BUG: unexpected runner mode: " + process.runnerConfig.Mode)
 	}
 }
 
+// fail marks the pipeline as failed, jobs which depend on the failed job are
+// skipped while other jobs keep running. If failedID is FAIL_ALL_JOBS then all
+// jobs are marked as failed.
 func (process *Process) fail(failedID int) {
-	process.onceFail.Do(func() {
-		now := ptr.TimePtr(utils.Now())
-
-		var failedStage string
-		var found bool
-
-		for _, job := range process.task.Jobs {
-			var result status.Status
-			var finished *time.Time
-
-			switch {
-			case failedID == FAIL_ALL_JOBS:
-				result = status.FAILED
-				finished = now
-
-			case job.ID == failedID:
-				found = true
-				failedStage = job.Stage
-				continue
-
-			case !found:
-				continue
+	now := ptr.TimePtr(utils.Now())
 
-			case found && job.Stage == failedStage:
-				continue
-
-			case found:
-				result = status.SKIPPED
+	if failedID == FAIL_ALL_JOBS {
+		for _, job := range process.getJobs() {
+			err := process.updateJob(job.ID, status.FAILED, nil, now)
+			if err != nil {
+				process.log.Errorf(
+					err,
+					"unable to update job status to %q",
+					status.FAILED,
+				)
 			}
-
-			err := process.updateJob(
-				job.ID,
-				result,
-				nil,
-				finished,
-			)
+		}
+	} else {
+		for _, job := range process.graph.skipDependents(failedID) {
+			err := process.updateJob(job.ID, status.SKIPPED, nil, nil)
 			if err != nil {
 				process.log.Errorf(
 					err,
 					"unable to update job status to %q",
-					result,
+					status.SKIPPED,
 				)
 			}
 		}
+	}
 
+	process.onceFail.Do(func() {
 		err := process.client.UpdatePipeline(
 			process.task.Pipeline.ID,
 			status.FAILED,
@@ -528,8 +880,70 @@ func (process *Process) prepareVariables() error {
 	return nil
 }
 
+// acquireHost picks the host of the pipeline if the executor runs containers
+// on several hosts, all jobs of the pipeline run on the same host because
+// they share the git dir of the sidecar.
+func (process *Process) acquireHost() {
+	pool, ok := process.executor.(executor.HostPool)
+	if !ok {
+		return
+	}
+
+	process.executor, process.releaseHost = pool.Acquire()
+}
+
+// createNetwork creates the network which is shared by containers of the
+// pipeline, so containers of parallel pipelines can't reach each other.
+func (process *Process) createNetwork() error {
+	services, ok := process.executor.(executor.ServiceExecutor)
+	if !ok {
+		return nil
+	}
+
+	network, err := services.CreateNetwork(
+		process.ctx,
+		fmt.Sprintf(
+			"pipeline-%d-uniq-%s",
+			process.task.Pipeline.ID,
+			utils.RandString(10),
+		),
+	)
+	if err != nil {
+		return karma.Format(err, "unable to create pipeline network")
+	}
+
+	process.network = network
+
+	return nil
+}
+
 func (process *Process) destroy() {
 	if process.sidecar != nil {
 		process.sidecar.Destroy()
 	}
+
+	// the network can be removed only when all its containers are removed
+	if process.network != nil {
+		err := process.executor.(executor.ServiceExecutor).DestroyNetwork(
+			context.Background(),
+			process.network,
+		)
+		if err != nil {
+			process.log.Errorf(
+				karma.Describe("network", process.network.String()).Reason(err),
+				"unable to destroy pipeline network",
+			)
+		}
+	}
+
+	if process.artifacts != nil {
+		err := process.artifacts.Destroy()
+		if err != nil {
+			process.log.Errorf(err, "unable to remove artifacts")
+		}
+	}
+
+	if process.releaseHost != nil {
+		process.releaseHost()
+	}
 }
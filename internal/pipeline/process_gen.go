@@ -7,6 +7,7 @@ import (
 
 	"github.com/reconquest/cog"
 	"github.com/reconquest/snake-runner/internal/api"
+	"github.com/reconquest/snake-runner/internal/cache"
 	"github.com/reconquest/snake-runner/internal/executor"
 	"github.com/reconquest/snake-runner/internal/runner"
 	"github.com/reconquest/snake-runner/internal/signal"
@@ -17,10 +18,11 @@ import (
 func NewProcess(
 	parentCtx context.Context,
 	ctx context.Context,
-	client *api.Client,
+	client api.Master,
 	runnerConfig *runner.Config,
 	task tasks.PipelineRun,
 	executor executor.Executor,
+	cache *cache.Cache,
 	log *cog.Logger,
 	sshKey sshkey.Key,
 	configCond signal.Condition,
@@ -32,6 +34,7 @@ func NewProcess(
 		runnerConfig: runnerConfig,
 		task:         task,
 		executor:     executor,
+		cache:        cache,
 		log:          log,
 		sshKey:       sshKey,
 		configCond:   configCond,
@@ -17,6 +17,12 @@ type LogsPush struct {
 	Data string `json:"data"`
 }
 
+// JobExpand lists names of jobs which the matrix or parallel job is expanded
+// into.
+type JobExpand struct {
+	Names []string `json:"names"`
+}
+
 type Heartbeat struct {
 	Version *string `json:"version,omitempty"`
 }
@@ -0,0 +1,137 @@
+package rules
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/reconquest/snake-runner/internal/config"
+	"github.com/reconquest/snake-runner/internal/glob"
+)
+
+// Env provides values of environment variables of the job.
+type Env interface {
+	Get(key string) (string, bool)
+}
+
+// ShouldRun returns true if the job has to be run in the pipeline described
+// by the given env. If rules are specified the first matching rule decides,
+// the job is not run if no rule matches. Otherwise the job runs if it matches
+// only and doesn't match except.
+//
+// Changes is a list of files changed by the pipeline, nil means that changes
+// are unknown and all changes conditions match.
+func ShouldRun(job config.Job, env Env, changes []string) bool {
+	if len(job.Rules) > 0 {
+		for _, rule := range job.Rules {
+			if Match(rule.Condition, env, changes) {
+				return rule.When != config.RULE_WHEN_NEVER
+			}
+		}
+
+		return false
+	}
+
+	if job.Only != nil && !Match(*job.Only, env, changes) {
+		return false
+	}
+
+	if job.Except != nil && Match(*job.Except, env, changes) {
+		return false
+	}
+
+	return true
+}
+
+// HasChanges returns true if any job of the pipeline has a changes condition,
+// so changed files need to be listed.
+func HasChanges(pipeline config.Pipeline) bool {
+	for _, job := range pipeline.Jobs {
+		if job.Only != nil && len(job.Only.Changes) > 0 {
+			return true
+		}
+
+		if job.Except != nil && len(job.Except.Changes) > 0 {
+			return true
+		}
+
+		for _, rule := range job.Rules {
+			if len(rule.Condition.Changes) > 0 {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// Match returns true if every specified field of the condition matches.
+func Match(condition config.Condition, env Env, changes []string) bool {
+	if len(condition.Refs) > 0 {
+		ref, _ := env.Get("CI_REF")
+		if !matchAny(condition.Refs, ref) {
+			return false
+		}
+	}
+
+	if len(condition.RefTypes) > 0 {
+		refType, _ := env.Get("CI_REF_TYPE")
+
+		found := false
+		for _, expected := range condition.RefTypes {
+			if strings.EqualFold(expected, refType) {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return false
+		}
+	}
+
+	for key, pattern := range condition.Variables {
+		value, ok := env.Get(key)
+		if !ok || !matchPattern(pattern, value) {
+			return false
+		}
+	}
+
+	if len(condition.Changes) > 0 && changes != nil {
+		found := false
+		for _, path := range changes {
+			if matchAny(condition.Changes, path) {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
+
+func matchAny(patterns []string, value string) bool {
+	for _, pattern := range patterns {
+		if matchPattern(pattern, value) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func matchPattern(pattern string, value string) bool {
+	if config.IsRegexpPattern(pattern) {
+		matcher, err := regexp.Compile(pattern[1 : len(pattern)-1])
+		if err != nil {
+			return false
+		}
+
+		return matcher.MatchString(value)
+	}
+
+	return glob.Match(pattern, value)
+}
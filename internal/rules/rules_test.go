@@ -0,0 +1,75 @@
+package rules
+
+import (
+	"testing"
+
+	"github.com/reconquest/snake-runner/internal/config"
+	"github.com/reconquest/snake-runner/internal/env"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShouldRun(t *testing.T) {
+	test := assert.New(t)
+
+	branch := env.NewEnv(map[string]string{
+		"CI_REF":      "release/1.0",
+		"CI_REF_TYPE": "BRANCH",
+		"CI_BRANCH":   "release/1.0",
+	})
+
+	pullRequest := env.NewEnv(map[string]string{
+		"CI_REF":                 "feature",
+		"CI_REF_TYPE":            "BRANCH",
+		"CI_PULL_REQUEST_ID":     "42",
+		"CI_PULL_REQUEST_TO_REF": "refs/heads/master",
+	})
+
+	test.True(ShouldRun(config.Job{}, branch, nil))
+
+	test.True(ShouldRun(
+		config.Job{Only: &config.Condition{Refs: []string{"release/*"}}},
+		branch, nil,
+	))
+	test.False(ShouldRun(
+		config.Job{Only: &config.Condition{Refs: []string{"master"}}},
+		branch, nil,
+	))
+	test.True(ShouldRun(
+		config.Job{Only: &config.Condition{Refs: []string{`/^release/\d+/`}}},
+		branch, nil,
+	))
+	test.False(ShouldRun(
+		config.Job{Except: &config.Condition{RefTypes: []string{"branch"}}},
+		branch, nil,
+	))
+
+	onlyPullRequests := config.Job{
+		Only: &config.Condition{
+			Variables: map[string]string{
+				"CI_PULL_REQUEST_ID":     "*",
+				"CI_PULL_REQUEST_TO_REF": "refs/heads/master",
+			},
+		},
+	}
+	test.True(ShouldRun(onlyPullRequests, pullRequest, nil))
+	test.False(ShouldRun(onlyPullRequests, branch, nil))
+
+	docs := config.Job{
+		Rules: []config.Rule{
+			{
+				Condition: config.Condition{Refs: []string{"master"}},
+				When:      config.RULE_WHEN_NEVER,
+			},
+			{
+				Condition: config.Condition{Changes: []string{"docs/**", "*.md"}},
+				When:      config.RULE_WHEN_ON_SUCCESS,
+			},
+		},
+	}
+	test.True(ShouldRun(docs, branch, []string{"docs/a/b.txt"}))
+	test.True(ShouldRun(docs, branch, []string{"src/main.go", "README.md"}))
+	test.False(ShouldRun(docs, branch, []string{"src/README.md"}))
+	test.False(ShouldRun(docs, branch, []string{}))
+	test.True(ShouldRun(docs, branch, nil))
+	test.False(ShouldRun(docs, env.NewEnv(map[string]string{"CI_REF": "master"}), nil))
+}
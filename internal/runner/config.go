@@ -5,6 +5,8 @@ import (
 	"errors"
 	"io/ioutil"
 	"os"
+	"os/user"
+	"path"
 	"path/filepath"
 	"runtime"
 	"strings"
@@ -21,12 +23,32 @@ import (
 
 const (
 	RUNNER_MODE_DOCKER = `docker`
+	RUNNER_MODE_PODMAN = `podman`
 	RUNNER_MODE_SHELL  = `shell`
+	RUNNER_MODE_SSH    = `ssh`
+)
+
+const (
+	CACHE_BACKEND_FILESYSTEM = `filesystem`
+	CACHE_BACKEND_S3         = `s3`
 )
 
 var ErrorNotConfigured = errors.New("not configured")
 
-var modes = set.NewStringSet(RUNNER_MODE_DOCKER, RUNNER_MODE_SHELL)
+var modes = set.NewStringSet(
+	RUNNER_MODE_DOCKER,
+	RUNNER_MODE_PODMAN,
+	RUNNER_MODE_SHELL,
+	RUNNER_MODE_SSH,
+)
+
+var cacheBackends = set.NewStringSet(CACHE_BACKEND_FILESYSTEM, CACHE_BACKEND_S3)
+
+var pullPolicies = set.NewStringSet(
+	executor.PULL_POLICY_ALWAYS,
+	executor.PULL_POLICY_IF_NOT_PRESENT,
+	executor.PULL_POLICY_NEVER,
+)
 
 type Config struct {
 	// MasterAddress is actually required but it will be handled manually
@@ -45,10 +67,64 @@ type Config struct {
 	Mode                 string        `yaml:"exec_mode"              env:"SNAKE_EXEC_MODE"              default:"docker" required:"true"`
 	MaxParallelPipelines int64         `yaml:"max_parallel_pipelines" env:"SNAKE_MAX_PARALLEL_PIPELINES" default:"0"      required:"true"`
 	PipelinesDir         string        `yaml:"pipelines_dir"          env:"SNAKE_PIPELINES_DIR"`
-	Docker               struct {
+	JobTimeout           time.Duration `yaml:"job_timeout"            env:"SNAKE_JOB_TIMEOUT"`
+	ArtifactsMaxSize     int64         `yaml:"artifacts_max_size"     env:"SNAKE_ARTIFACTS_MAX_SIZE"     default:"104857600"`
+
+	// Env defines variables of the runner which are available to jobs in
+	// the shell mode and in expansion of pipeline variables, other
+	// variables such as SNAKE_ACCESS_TOKEN are removed
+	Env struct {
+		// Passthrough are names of variables in addition to
+		// DEFAULT_ENV_PASSTHROUGH, names ending with * are prefixes
+		Passthrough []string `yaml:"passthrough" env:"SNAKE_ENV_PASSTHROUGH"`
+
+		// InheritAll makes all variables of the runner available to jobs
+		// like older versions did, it's a compatibility flag for existing
+		// setups
+		InheritAll bool `yaml:"inherit_all" env:"SNAKE_ENV_INHERIT_ALL"`
+	} `yaml:"env"`
+
+	Docker struct {
 		Network string   `yaml:"network"     env:"SNAKE_DOCKER_NETWORK"`
 		Volumes []string `yaml:"volumes"     env:"SNAKE_DOCKER_VOLUMES"`
 
+		// PullPolicy is the default pull policy of job and service images,
+		// jobs can override it
+		PullPolicy string `yaml:"pull_policy" env:"SNAKE_DOCKER_PULL_POLICY" default:"if-not-present"`
+
+		// GC removes images which are not used by the runner, images of
+		// existing containers and the sidecar image are always kept
+		GC struct {
+			Enabled       bool          `yaml:"enabled"        env:"SNAKE_DOCKER_GC_ENABLED"`
+			Interval      time.Duration `yaml:"interval"       env:"SNAKE_DOCKER_GC_INTERVAL"       default:"1h"`
+			MaxUnused     time.Duration `yaml:"max_unused"     env:"SNAKE_DOCKER_GC_MAX_UNUSED"     default:"168h"`
+			DiskThreshold int           `yaml:"disk_threshold" env:"SNAKE_DOCKER_GC_DISK_THRESHOLD" default:"90"`
+			Keep          []string      `yaml:"keep"           env:"SNAKE_DOCKER_GC_KEEP"`
+		} `yaml:"gc"`
+
+		// Resources are default resource limits of job containers and
+		// service containers, jobs can request other values up to
+		// MaxResources
+		Resources    executor.ResourcesSpec `yaml:"resources"`
+		MaxResources executor.ResourcesSpec `yaml:"max_resources"`
+
+		resources    executor.Resources
+		maxResources executor.Resources
+
+		// Privileged, CapAdd, CapDrop and Devices are applied to all job
+		// containers
+		Privileged bool     `yaml:"privileged" env:"SNAKE_DOCKER_PRIVILEGED"`
+		CapAdd     []string `yaml:"cap_add"    env:"SNAKE_DOCKER_CAP_ADD"`
+		CapDrop    []string `yaml:"cap_drop"   env:"SNAKE_DOCKER_CAP_DROP"`
+		Devices    []string `yaml:"devices"    env:"SNAKE_DOCKER_DEVICES"`
+
+		// PrivilegedJobs are patterns of jobs which may request privileges
+		// and docker in docker services: PROJECT/repository matches all jobs
+		// of the repository, PROJECT/repository:job matches only the job
+		PrivilegedJobs []string `yaml:"privileged_jobs" env:"SNAKE_DOCKER_PRIVILEGED_JOBS"`
+
+		devices []executor.Device
+
 		// We also read SNAKE_DOCKER_AUTH_CONFIG but we do it manually to avoid
 		// unmarshalling JSON as map
 		AuthConfigJSON string `yaml:"auth_config"`
@@ -56,6 +132,46 @@ type Config struct {
 		auths executor.DockerAuths
 	} `yaml:"docker"`
 
+	// Podman is used in the podman mode, docker settings are applied to
+	// podman containers as well
+	Podman struct {
+		// Host is the Podman socket, the socket of the rootless Podman of
+		// the runner user is used by default
+		Host string `yaml:"host" env:"SNAKE_PODMAN_HOST"`
+	} `yaml:"podman"`
+
+	// Shell is used in the shell mode
+	Shell struct {
+		// User runs commands of jobs instead of the runner user, the runner
+		// must be started by root to switch users
+		User string `yaml:"user" env:"SNAKE_SHELL_USER"`
+	} `yaml:"shell"`
+
+	// Ssh is used in the ssh mode, jobs are executed on build hosts in
+	// workspaces of the given dir
+	Ssh struct {
+		Hosts          []string `yaml:"hosts"            env:"SNAKE_SSH_HOSTS"`
+		User           string   `yaml:"user"             env:"SNAKE_SSH_USER"`
+		IdentityFile   string   `yaml:"identity_file"    env:"SNAKE_SSH_IDENTITY_FILE"`
+		KnownHostsFile string   `yaml:"known_hosts_file" env:"SNAKE_SSH_KNOWN_HOSTS_FILE"`
+		WorkspaceDir   string   `yaml:"workspace_dir"    env:"SNAKE_SSH_WORKSPACE_DIR" default:"snake-runner"`
+	} `yaml:"ssh"`
+
+	Cache struct {
+		Backend string `yaml:"backend"  env:"SNAKE_CACHE_BACKEND"  default:"filesystem"`
+		Dir     string `yaml:"dir"      env:"SNAKE_CACHE_DIR"`
+		MaxSize int64  `yaml:"max_size" env:"SNAKE_CACHE_MAX_SIZE" default:"10737418240"`
+
+		S3 struct {
+			Endpoint  string `yaml:"endpoint"   env:"SNAKE_CACHE_S3_ENDPOINT"`
+			Bucket    string `yaml:"bucket"     env:"SNAKE_CACHE_S3_BUCKET"`
+			Prefix    string `yaml:"prefix"     env:"SNAKE_CACHE_S3_PREFIX"`
+			Region    string `yaml:"region"     env:"SNAKE_CACHE_S3_REGION"`
+			AccessKey string `yaml:"access_key" env:"SNAKE_CACHE_S3_ACCESS_KEY"`
+			SecretKey string `yaml:"secret_key" env:"SNAKE_CACHE_S3_SECRET_KEY"`
+		} `yaml:"s3"`
+	} `yaml:"cache"`
+
 	Sidecar struct {
 		Docker struct {
 			Volumes []string `yaml:"volumes" env:"SNAKE_SIDECAR_DOCKER_VOLUMES"`
@@ -67,6 +183,131 @@ func (config *Config) GetDockerAuthConfig() executor.Auths {
 	return config.Docker.auths.Auths
 }
 
+// GetDockerPrivileges returns privileges of all job containers.
+func (config *Config) GetDockerPrivileges() executor.Privileges {
+	return executor.Privileges{
+		Privileged: config.Docker.Privileged,
+		CapAdd:     config.Docker.CapAdd,
+		CapDrop:    config.Docker.CapDrop,
+		Devices:    config.Docker.devices,
+	}
+}
+
+// IsPrivilegedJob returns true if the job of the repository may request
+// privileges, the repository is specified as PROJECT/repository.
+func (config *Config) IsPrivilegedJob(repository string, job string) bool {
+	for _, pattern := range config.Docker.PrivilegedJobs {
+		repositoryPattern, jobPattern := splitPrivilegedJob(pattern)
+
+		if matched, _ := path.Match(repositoryPattern, repository); !matched {
+			continue
+		}
+
+		if jobPattern == "" {
+			return true
+		}
+
+		if matched, _ := path.Match(jobPattern, job); matched {
+			return true
+		}
+	}
+
+	return false
+}
+
+func splitPrivilegedJob(pattern string) (string, string) {
+	if index := strings.Index(pattern, ":"); index >= 0 {
+		return pattern[:index], pattern[index+1:]
+	}
+
+	return pattern, ""
+}
+
+// GetPassthroughEnv returns variables of the runner which are available to
+// jobs.
+func (config *Config) GetPassthroughEnv() []string {
+	env := []string{}
+	for _, pair := range os.Environ() {
+		name := strings.SplitN(pair, "=", 2)[0]
+		if name != "" && config.isPassthroughEnv(name) {
+			env = append(env, pair)
+		}
+	}
+
+	return env
+}
+
+// LookupPassthroughEnv returns the value of the variable of the runner if
+// it's available to jobs.
+func (config *Config) LookupPassthroughEnv(name string) (string, bool) {
+	if !config.isPassthroughEnv(name) {
+		return "", false
+	}
+
+	return os.LookupEnv(name)
+}
+
+func (config *Config) isPassthroughEnv(name string) bool {
+	if config.Env.InheritAll {
+		return true
+	}
+
+	if runtime.GOOS == "windows" {
+		// variables are case-insensitive on Windows
+		name = strings.ToUpper(name)
+	}
+
+	for _, list := range [][]string{DEFAULT_ENV_PASSTHROUGH, config.Env.Passthrough} {
+		for _, pattern := range list {
+			if runtime.GOOS == "windows" {
+				pattern = strings.ToUpper(pattern)
+			}
+
+			if strings.HasSuffix(pattern, "*") {
+				if strings.HasPrefix(name, strings.TrimSuffix(pattern, "*")) {
+					return true
+				}
+
+				continue
+			}
+
+			if name == pattern {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// GetDockerResources returns default resource limits of containers.
+func (config *Config) GetDockerResources() executor.Resources {
+	return config.Docker.resources
+}
+
+// GetDockerMaxResources returns maximums of resource limits which jobs can
+// request, zero values are not limited.
+func (config *Config) GetDockerMaxResources() executor.Resources {
+	return config.Docker.maxResources
+}
+
+// GetCacheDir returns the directory for job caches of the filesystem cache
+// backend, it's the cache directory next to the pipelines directory unless
+// specified.
+func (config *Config) GetCacheDir() string {
+	if config.Cache.Dir != "" {
+		return config.Cache.Dir
+	}
+
+	return filepath.Join(filepath.Dir(config.PipelinesDir), "cache")
+}
+
+// GetImageUsagePath returns the file where the image gc saves when images
+// were used the last time, it's next to the pipelines directory.
+func (config *Config) GetImageUsagePath() string {
+	return filepath.Join(filepath.Dir(config.PipelinesDir), "images.json")
+}
+
 func LoadConfig(path string, fileRequired ko.RequireFile) (*Config, error) {
 	log.Infof(karma.Describe("path", path), "reading configuration file")
 
@@ -100,18 +341,165 @@ func LoadConfig(path string, fileRequired ko.RequireFile) (*Config, error) {
 		config.AccessToken = strings.TrimSpace(string(tokenData))
 	}
 
+	err = config.prepare()
+	if err != nil {
+		return nil, err
+	}
+
+	return &config, nil
+}
+
+// LoadLocalConfig reads the configuration for running pipelines locally
+// without Bitbucket, so neither master address nor tokens are required.
+func LoadLocalConfig(path string) (*Config, error) {
+	log.Infof(karma.Describe("path", path), "reading configuration file")
+
+	var config Config
+	err := ko.Load(path, &config, yaml.Unmarshal, ko.RequireFile(false))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	err = config.prepare()
+	if err != nil {
+		return nil, err
+	}
+
+	return &config, nil
+}
+
+func (config *Config) prepare() error {
+	var err error
+
 	if !modes.Has(config.Mode) {
-		return nil, karma.Format(
+		return karma.Format(
 			err,
 			"unknown mode specified: %q; known are: %v",
 			config.Mode, modes.List(),
 		)
 	}
 
-	if config.Mode == "shell" {
+	if !cacheBackends.Has(config.Cache.Backend) {
+		return karma.Format(
+			err,
+			"unknown cache backend specified: %q; known are: %v",
+			config.Cache.Backend, cacheBackends.List(),
+		)
+	}
+
+	if !pullPolicies.Has(config.Docker.PullPolicy) {
+		return karma.Format(
+			err,
+			"unknown docker pull policy specified: %q; known are: %v",
+			config.Docker.PullPolicy, pullPolicies.List(),
+		)
+	}
+
+	if config.Docker.GC.Enabled {
+		if config.Docker.GC.Interval <= 0 {
+			return errors.New("docker.gc.interval must be positive")
+		}
+
+		if config.Docker.GC.DiskThreshold < 0 || config.Docker.GC.DiskThreshold > 100 {
+			return errors.New("docker.gc.disk_threshold must be between 0 and 100")
+		}
+
+		for _, pattern := range config.Docker.GC.Keep {
+			_, err := path.Match(pattern, "")
+			if err != nil {
+				return karma.Format(
+					err,
+					"invalid pattern in docker.gc.keep: %q", pattern,
+				)
+			}
+		}
+	}
+
+	for _, spec := range config.Docker.Devices {
+		device, err := executor.ParseDevice(spec)
+		if err != nil {
+			return karma.Format(err, "invalid device in docker.devices")
+		}
+
+		config.Docker.devices = append(config.Docker.devices, device)
+	}
+
+	for _, pattern := range config.Docker.PrivilegedJobs {
+		repositoryPattern, jobPattern := splitPrivilegedJob(pattern)
+
+		_, err := path.Match(repositoryPattern, "")
+		if err == nil {
+			_, err = path.Match(jobPattern, "")
+		}
+		if err != nil {
+			return karma.Format(
+				err,
+				"invalid pattern in docker.privileged_jobs: %q", pattern,
+			)
+		}
+	}
+
+	config.Docker.resources, err = config.Docker.Resources.Parse()
+	if err != nil {
+		return karma.Format(err, "invalid docker.resources")
+	}
+
+	config.Docker.maxResources, err = config.Docker.MaxResources.Parse()
+	if err != nil {
+		return karma.Format(err, "invalid docker.max_resources")
+	}
+
+	// limits which have a maximum but no default are limited by the
+	// maximum, otherwise jobs without requests would be unlimited
+	config.Docker.resources = config.Docker.maxResources.Merge(
+		config.Docker.resources,
+	)
+
+	err = config.Docker.resources.Validate()
+	if err != nil {
+		return karma.Format(err, "invalid docker.resources")
+	}
+
+	err = config.Docker.resources.CheckLimits(config.Docker.maxResources)
+	if err != nil {
+		return karma.Format(
+			err,
+			"docker.resources must not exceed docker.max_resources",
+		)
+	}
+
+	if config.Cache.Backend == CACHE_BACKEND_S3 {
+		if config.Cache.S3.Endpoint == "" || config.Cache.S3.Bucket == "" {
+			return errors.New(
+				"cache.s3.endpoint and cache.s3.bucket must be specified " +
+					"for the s3 cache backend",
+			)
+		}
+	}
+
+	if config.Podman.Host == "" {
+		config.Podman.Host = getDefaultPodmanHost()
+	}
+
+	if config.Env.InheritAll {
+		log.Warning(
+			"env.inherit_all is enabled, all environment variables of the " +
+				"runner including its secrets are available to jobs",
+		)
+	}
+
+	if config.Mode == RUNNER_MODE_SSH {
+		err := config.prepareSsh()
+		if err != nil {
+			return err
+		}
+	}
+
+	if config.Mode == "shell" && config.Shell.User == "" {
 		log.Warning(
 			"shell mode specified, all commands will be " +
-				"executed on the local host with current process permissions",
+				"executed on the local host with current process permissions; " +
+				"specify shell.user to run jobs as an unprivileged user",
 		)
 	}
 
@@ -129,7 +517,7 @@ func LoadConfig(path string, fileRequired ko.RequireFile) (*Config, error) {
 	if config.Name == "" {
 		hostname, err := os.Hostname()
 		if err != nil {
-			return nil, karma.Format(err, "unable to obtain hostname")
+			return karma.Format(err, "unable to obtain hostname")
 		}
 
 		config.Name = hostname
@@ -142,7 +530,7 @@ func LoadConfig(path string, fileRequired ko.RequireFile) (*Config, error) {
 	if !filepath.IsAbs(config.PipelinesDir) {
 		config.PipelinesDir, err = filepath.Abs(config.PipelinesDir)
 		if err != nil {
-			return nil, karma.Format(
+			return karma.Format(
 				err,
 				"unable to get absolute path of %q", config.PipelinesDir,
 			)
@@ -166,7 +554,7 @@ func LoadConfig(path string, fileRequired ko.RequireFile) (*Config, error) {
 				origin = "the docker.auth_config config parameter"
 			}
 
-			return nil, karma.Format(
+			return karma.Format(
 				err,
 				"unable to decode JSON in the docker auth config specified as %s",
 				origin,
@@ -174,5 +562,37 @@ func LoadConfig(path string, fileRequired ko.RequireFile) (*Config, error) {
 		}
 	}
 
-	return &config, nil
+	return nil
+}
+
+// prepareSsh validates settings of the ssh mode, the user and the known hosts
+// file are the same as used by the ssh client by default.
+func (config *Config) prepareSsh() error {
+	if len(config.Ssh.Hosts) == 0 {
+		return errors.New("ssh.hosts must be specified for the ssh mode")
+	}
+
+	if config.Ssh.IdentityFile == "" {
+		return errors.New("ssh.identity_file must be specified for the ssh mode")
+	}
+
+	if config.Ssh.User == "" {
+		current, err := user.Current()
+		if err != nil {
+			return karma.Format(err, "unable to obtain current user")
+		}
+
+		config.Ssh.User = current.Username
+	}
+
+	if config.Ssh.KnownHostsFile == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return karma.Format(err, "unable to obtain home dir")
+		}
+
+		config.Ssh.KnownHostsFile = filepath.Join(home, ".ssh", "known_hosts")
+	}
+
+	return nil
 }
@@ -2,8 +2,44 @@
 
 package runner
 
+import (
+	"fmt"
+	"os"
+)
+
 const (
 	DEFAULT_ACCESS_TOKEN_PATH = "/var/lib/snake-runner/secrets/access_token"
 	DEFAULT_PIPELINES_DIR     = "/var/lib/snake-runner/pipelines"
 	DEFAULT_CONFIG_PATH       = "/etc/snake-runner/snake-runner.conf"
 )
+
+// DEFAULT_ENV_PASSTHROUGH are variables of the runner which are always
+// available to jobs, names ending with * are prefixes.
+var DEFAULT_ENV_PASSTHROUGH = []string{
+	"PATH",
+	"HOME",
+	"USER",
+	"LOGNAME",
+	"SHELL",
+	"LANG",
+	"LANGUAGE",
+	"LC_*",
+	"TZ",
+	"TERM",
+	"TMPDIR",
+}
+
+// getDefaultPodmanHost returns the socket of the rootless Podman of the
+// current user or the system socket if the runner is started by root.
+func getDefaultPodmanHost() string {
+	if os.Getuid() == 0 {
+		return "unix:///run/podman/podman.sock"
+	}
+
+	dir := os.Getenv("XDG_RUNTIME_DIR")
+	if dir == "" {
+		dir = fmt.Sprintf("/run/user/%d", os.Getuid())
+	}
+
+	return "unix://" + dir + "/podman/podman.sock"
+}
@@ -12,3 +12,33 @@ var (
 	DEFAULT_PIPELINES_DIR     = filepath.Join(os.Getenv("ProgramData"), "snake-runner", "pipelines")
 	DEFAULT_CONFIG_PATH       = filepath.Join(os.Getenv("ProgramData"), "snake-runner", "config", "snake-runner.conf")
 )
+
+// DEFAULT_ENV_PASSTHROUGH are variables of the runner which are always
+// available to jobs, names ending with * are prefixes.
+var DEFAULT_ENV_PASSTHROUGH = []string{
+	"PATH",
+	"PATHEXT",
+	"SYSTEMROOT",
+	"SYSTEMDRIVE",
+	"WINDIR",
+	"COMSPEC",
+	"TEMP",
+	"TMP",
+	"HOMEDRIVE",
+	"HOMEPATH",
+	"USERPROFILE",
+	"USERNAME",
+	"APPDATA",
+	"LOCALAPPDATA",
+	"PROGRAMDATA",
+	"PROGRAMFILES*",
+	"COMMONPROGRAMFILES*",
+	"NUMBER_OF_PROCESSORS",
+	"PROCESSOR_*",
+	"OS",
+}
+
+// getDefaultPodmanHost returns the pipe of the default Podman machine.
+func getDefaultPodmanHost() string {
+	return "npipe:////./pipe/podman-machine-default"
+}
@@ -0,0 +1,297 @@
+package sidecar
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/reconquest/karma-go"
+	"github.com/reconquest/snake-runner/internal/glob"
+)
+
+type artifactEntry struct {
+	path string
+	dir  bool
+}
+
+// matchArtifacts returns paths of entries which match any of the patterns,
+// entries inside of matched directories are not listed because directories
+// are archived with all their contents. The .git directory is never matched.
+func matchArtifacts(entries []artifactEntry, patterns []string) []string {
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].path < entries[j].path
+	})
+
+	matched := []string{}
+	dirs := []string{}
+
+entries:
+	for _, entry := range entries {
+		if entry.path == ".git" || strings.HasPrefix(entry.path, ".git/") {
+			continue
+		}
+
+		for _, dir := range dirs {
+			if strings.HasPrefix(entry.path, dir+"/") {
+				continue entries
+			}
+		}
+
+		for _, pattern := range patterns {
+			pattern = strings.TrimSuffix(strings.TrimPrefix(pattern, "./"), "/")
+
+			if glob.Match(pattern, entry.path) {
+				matched = append(matched, entry.path)
+
+				if entry.dir {
+					dirs = append(dirs, entry.path)
+				}
+
+				break
+			}
+		}
+	}
+
+	return matched
+}
+
+// parseArtifactEntries parses output of find executed in the git dir.
+func parseArtifactEntries(output string, dir bool) []artifactEntry {
+	entries := []artifactEntry{}
+	for _, line := range strings.Split(output, "\n") {
+		path := strings.TrimPrefix(strings.TrimSpace(line), "./")
+		if path == "" || path == "." {
+			continue
+		}
+
+		entries = append(entries, artifactEntry{path: path, dir: dir})
+	}
+
+	return entries
+}
+
+// writeArchive writes a gzipped tar archive of the given paths relative to
+// the root directory.
+func writeArchive(root string, paths []string, writer io.Writer) error {
+	compressor := gzip.NewWriter(writer)
+	archive := tar.NewWriter(compressor)
+
+	for _, path := range paths {
+		err := filepath.Walk(
+			filepath.Join(root, filepath.FromSlash(path)),
+			func(name string, info os.FileInfo, err error) error {
+				if err != nil {
+					return err
+				}
+
+				return writeArchiveEntry(archive, root, name, info)
+			},
+		)
+		if err != nil {
+			return karma.Format(err, "unable to archive %s", path)
+		}
+	}
+
+	err := archive.Close()
+	if err != nil {
+		return err
+	}
+
+	return compressor.Close()
+}
+
+func writeArchiveEntry(
+	archive *tar.Writer,
+	root string,
+	name string,
+	info os.FileInfo,
+) error {
+	relative, err := filepath.Rel(root, name)
+	if err != nil {
+		return err
+	}
+
+	var link string
+	if info.Mode()&os.ModeSymlink != 0 {
+		link, err = os.Readlink(name)
+		if err != nil {
+			return err
+		}
+	}
+
+	header, err := tar.FileInfoHeader(info, link)
+	if err != nil {
+		return err
+	}
+
+	header.Name = filepath.ToSlash(relative)
+	if info.IsDir() {
+		header.Name += "/"
+	}
+
+	err = archive.WriteHeader(header)
+	if err != nil {
+		return err
+	}
+
+	if !info.Mode().IsRegular() {
+		return nil
+	}
+
+	file, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = io.Copy(archive, file)
+	return err
+}
+
+// extractArchive unpacks a gzipped tar archive into the root directory,
+// entries pointing outside of the root directory are rejected as well as
+// symlinks pointing outside of it and entries written through symlinks.
+func extractArchive(root string, reader io.Reader) error {
+	root = filepath.Clean(root)
+
+	decompressor, err := gzip.NewReader(reader)
+	if err != nil {
+		return err
+	}
+	defer decompressor.Close()
+
+	archive := tar.NewReader(decompressor)
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		err = extractArchiveEntry(archive, root, header)
+		if err != nil {
+			return karma.Format(err, "unable to extract %s", header.Name)
+		}
+	}
+}
+
+func extractArchiveEntry(
+	archive *tar.Reader,
+	root string,
+	header *tar.Header,
+) error {
+	name := filepath.Join(root, filepath.FromSlash(header.Name))
+	if !isInsideDir(root, name) {
+		return errors.New("path is outside of the directory")
+	}
+
+	err := checkNoSymlinks(root, filepath.Dir(name))
+	if err != nil {
+		return err
+	}
+
+	mode := os.FileMode(header.Mode).Perm()
+
+	switch header.Typeflag {
+	case tar.TypeDir:
+		return os.MkdirAll(name, mode|0700)
+
+	case tar.TypeSymlink:
+		target := filepath.FromSlash(header.Linkname)
+		if !filepath.IsAbs(target) {
+			target = filepath.Join(filepath.Dir(name), target)
+		}
+
+		if !isInsideDir(root, filepath.Clean(target)) {
+			return errors.New("symlink points outside of the directory")
+		}
+
+		err := os.MkdirAll(filepath.Dir(name), 0755)
+		if err != nil {
+			return err
+		}
+
+		err = os.Remove(name)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+
+		return os.Symlink(header.Linkname, name)
+
+	case tar.TypeReg:
+		err := os.MkdirAll(filepath.Dir(name), 0755)
+		if err != nil {
+			return err
+		}
+
+		// a file is never written through a symlink, the symlink is replaced
+		info, err := os.Lstat(name)
+		if err == nil && info.Mode()&os.ModeSymlink != 0 {
+			err = os.Remove(name)
+			if err != nil {
+				return err
+			}
+		}
+
+		file, err := os.OpenFile(name, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, mode)
+		if err != nil {
+			return err
+		}
+
+		_, err = io.Copy(file, archive)
+		if err != nil {
+			file.Close()
+			return err
+		}
+
+		return file.Close()
+	}
+
+	return nil
+}
+
+func isInsideDir(root string, name string) bool {
+	return name == root || strings.HasPrefix(name, root+string(filepath.Separator))
+}
+
+// checkNoSymlinks returns an error if any existing directory between the
+// root and the given directory is a symlink, so nothing is written outside
+// of the root through symlinks extracted before.
+func checkNoSymlinks(root string, dir string) error {
+	relative, err := filepath.Rel(root, dir)
+	if err != nil {
+		return err
+	}
+
+	if relative == "." {
+		return nil
+	}
+
+	path := root
+	for _, part := range strings.Split(relative, string(filepath.Separator)) {
+		path = filepath.Join(path, part)
+
+		info, err := os.Lstat(path)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if info.Mode()&os.ModeSymlink != 0 {
+			return karma.
+				Describe("path", path).
+				Reason("path contains a symlink")
+		}
+	}
+
+	return nil
+}
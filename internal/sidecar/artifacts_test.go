@@ -0,0 +1,171 @@
+package sidecar
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatchArtifacts(t *testing.T) {
+	test := assert.New(t)
+
+	entries := []artifactEntry{
+		{path: "bin", dir: true},
+		{path: "bin/app"},
+		{path: "coverage", dir: true},
+		{path: "coverage/unit", dir: true},
+		{path: "coverage/unit/report.xml"},
+		{path: "coverage/unit/report.html"},
+		{path: "main.go"},
+		{path: ".git", dir: true},
+		{path: ".git/config"},
+	}
+
+	test.Equal(
+		[]string{"bin", "coverage/unit/report.xml"},
+		matchArtifacts(entries, []string{"bin/", "coverage/**/*.xml"}),
+	)
+
+	test.Equal(
+		[]string{"main.go"},
+		matchArtifacts(entries, []string{"./*.go", ".git/**"}),
+	)
+
+	test.Equal(
+		[]string{},
+		matchArtifacts(entries, []string{"dist"}),
+	)
+}
+
+func TestWriteArchive_ExtractArchive(t *testing.T) {
+	test := assert.New(t)
+
+	source, err := ioutil.TempDir("", "snake-runner-test.*")
+	test.NoError(err)
+	defer os.RemoveAll(source)
+
+	test.NoError(os.MkdirAll(filepath.Join(source, "bin", "sub"), 0755))
+	test.NoError(ioutil.WriteFile(filepath.Join(source, "bin", "app"), []byte("app"), 0755))
+	test.NoError(ioutil.WriteFile(filepath.Join(source, "bin", "sub", "lib"), []byte("lib"), 0644))
+	test.NoError(ioutil.WriteFile(filepath.Join(source, "main.go"), []byte("main"), 0644))
+
+	var buffer bytes.Buffer
+	test.NoError(writeArchive(source, []string{"bin"}, &buffer))
+
+	target, err := ioutil.TempDir("", "snake-runner-test.*")
+	test.NoError(err)
+	defer os.RemoveAll(target)
+
+	test.NoError(extractArchive(target, &buffer))
+
+	data, err := ioutil.ReadFile(filepath.Join(target, "bin", "app"))
+	test.NoError(err)
+	test.Equal("app", string(data))
+
+	info, err := os.Stat(filepath.Join(target, "bin", "app"))
+	test.NoError(err)
+	test.Equal(os.FileMode(0755), info.Mode().Perm())
+
+	data, err = ioutil.ReadFile(filepath.Join(target, "bin", "sub", "lib"))
+	test.NoError(err)
+	test.Equal("lib", string(data))
+
+	test.NoFileExists(filepath.Join(target, "main.go"))
+}
+
+func TestExtractArchive_RejectsOutsidePaths(t *testing.T) {
+	test := assert.New(t)
+
+	var buffer bytes.Buffer
+	compressor := gzip.NewWriter(&buffer)
+	archive := tar.NewWriter(compressor)
+	test.NoError(archive.WriteHeader(&tar.Header{
+		Name:     "../evil",
+		Mode:     0644,
+		Size:     4,
+		Typeflag: tar.TypeReg,
+	}))
+	_, err := archive.Write([]byte("evil"))
+	test.NoError(err)
+	test.NoError(archive.Close())
+	test.NoError(compressor.Close())
+
+	target, err := ioutil.TempDir("", "snake-runner-test.*")
+	test.NoError(err)
+	defer os.RemoveAll(target)
+
+	err = extractArchive(filepath.Join(target, "git"), &buffer)
+	test.Error(err)
+	test.NoFileExists(filepath.Join(target, "evil"))
+}
+
+func TestExtractArchive_RejectsWritesThroughSymlinks(t *testing.T) {
+	test := assert.New(t)
+
+	target, err := ioutil.TempDir("", "snake-runner-test.*")
+	test.NoError(err)
+	defer os.RemoveAll(target)
+
+	root := filepath.Join(target, "git")
+	outside := filepath.Join(target, "ssh")
+	test.NoError(os.MkdirAll(outside, 0755))
+
+	// symlinks pointing outside are rejected
+	err = extractArchive(root, getTestArchive(test, []tar.Header{
+		{Name: "out", Linkname: outside, Typeflag: tar.TypeSymlink},
+	}))
+	test.Error(err)
+
+	err = extractArchive(root, getTestArchive(test, []tar.Header{
+		{Name: "out", Linkname: "../ssh", Typeflag: tar.TypeSymlink},
+	}))
+	test.Error(err)
+
+	// symlinks inside the root are fine but nothing is written through them
+	test.NoError(extractArchive(root, getTestArchive(test, []tar.Header{
+		{Name: "dir/", Mode: 0755, Typeflag: tar.TypeDir},
+		{Name: "link", Linkname: "dir", Typeflag: tar.TypeSymlink},
+	})))
+
+	err = extractArchive(root, getTestArchive(test, []tar.Header{
+		{Name: "link/file", Mode: 0644, Typeflag: tar.TypeReg},
+	}))
+	test.Error(err)
+	test.NoFileExists(filepath.Join(root, "dir", "file"))
+
+	// symlinks created outside of archives are not followed either
+	test.NoError(os.Symlink(outside, filepath.Join(root, "out")))
+
+	err = extractArchive(root, getTestArchive(test, []tar.Header{
+		{Name: "out/authorized_keys", Mode: 0644, Typeflag: tar.TypeReg},
+	}))
+	test.Error(err)
+	test.NoFileExists(filepath.Join(outside, "authorized_keys"))
+
+	// a file replaces the symlink instead of being written through it
+	test.NoError(extractArchive(root, getTestArchive(test, []tar.Header{
+		{Name: "out", Mode: 0644, Typeflag: tar.TypeReg},
+	})))
+	test.FileExists(filepath.Join(root, "out"))
+	test.DirExists(outside)
+}
+
+func getTestArchive(test *assert.Assertions, headers []tar.Header) *bytes.Buffer {
+	var buffer bytes.Buffer
+	compressor := gzip.NewWriter(&buffer)
+	archive := tar.NewWriter(compressor)
+	for _, header := range headers {
+		header := header
+		test.NoError(archive.WriteHeader(&header))
+	}
+	test.NoError(archive.Close())
+	test.NoError(compressor.Close())
+
+	return &buffer
+}
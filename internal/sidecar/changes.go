@@ -0,0 +1,47 @@
+package sidecar
+
+import (
+	"context"
+	"strings"
+
+	"github.com/reconquest/karma-go"
+	"github.com/reconquest/snake-runner/internal/executor"
+)
+
+// listChanges returns paths of files changed between the given commits in the
+// repository cloned into the given dir.
+func listChanges(
+	ctx context.Context,
+	runner executor.Executor,
+	container executor.Container,
+	gitDir string,
+	from string,
+	to string,
+) ([]string, error) {
+	var output strings.Builder
+
+	cmd := []string{"git", "-C", gitDir, "diff", "--name-only", from, to}
+
+	err := runner.Exec(ctx, container, executor.ExecOptions{
+		Cmd:          cmd,
+		AttachStdout: true,
+		OutputConsumer: func(text string) {
+			output.WriteString(text)
+		},
+	})
+	if err != nil {
+		return nil, karma.
+			Describe("cmd", cmd).
+			Format(err, "unable to list changed files")
+	}
+
+	changes := []string{}
+	for _, line := range strings.Split(output.String(), "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" {
+			changes = append(changes, line)
+		}
+	}
+
+	return changes, nil
+}
@@ -2,6 +2,7 @@ package sidecar
 
 import (
 	"context"
+	"io"
 	"path/filepath"
 	"strings"
 	"sync"
@@ -17,6 +18,7 @@ import (
 
 const (
 	CLOUD_SIDECAR_IMAGE = "reconquest/snake-runner-sidecar"
+	CLOUD_SIDECAR_ALIAS = "sidecar"
 )
 
 var _ Sidecar = (*CloudSidecar)(nil)
@@ -50,6 +52,10 @@ type CloudSidecar struct {
 
 	volumes []executor.Volume
 
+	// network is the network of the pipeline, the sidecar is reachable in
+	// it as CLOUD_SIDECAR_ALIAS
+	network executor.Network
+
 	sshAgent *sync.WaitGroup `gonstructor:"-"`
 }
 
@@ -112,9 +118,11 @@ func (sidecar *CloudSidecar) create(ctx context.Context) error {
 	sidecar.container, err = sidecar.executor.Create(
 		ctx,
 		executor.CreateOptions{
-			Name:    "snake-runner-sidecar-" + sidecar.name,
-			Image:   CLOUD_SIDECAR_IMAGE,
-			Volumes: volumes,
+			Name:     "snake-runner-sidecar-" + sidecar.name,
+			Image:    CLOUD_SIDECAR_IMAGE,
+			Volumes:  volumes,
+			Networks: []executor.Network{sidecar.network},
+			Alias:    CLOUD_SIDECAR_ALIAS,
 		},
 	)
 	if err != nil {
@@ -331,3 +339,65 @@ func (sidecar *CloudSidecar) ReadFile(ctx context.Context, cwd, path string) (st
 
 	return data, nil
 }
+
+func (sidecar *CloudSidecar) ReadRemoteFile(
+	ctx context.Context,
+	url, ref, path string,
+) (string, error) {
+	reader := remoteFileReader{
+		executor:  sidecar.executor,
+		container: sidecar.container,
+		env: []string{
+			consts.SSH_AUTH_SOCK_VAR + "=" + sidecar.sshSocket,
+		},
+		dir:            filepath.Join(sidecar.containerDir, consts.SUBDIR_REMOTE),
+		promptConsumer: sidecar.promptConsumer,
+		outputConsumer: sidecar.outputConsumer,
+		logger:         sidecar.getLogger("git"),
+	}
+
+	return reader.read(ctx, url, ref, path)
+}
+
+func (sidecar *CloudSidecar) ListChanges(
+	ctx context.Context,
+	from, to string,
+) ([]string, error) {
+	return listChanges(
+		ctx, sidecar.executor, sidecar.container, sidecar.gitDir, from, to,
+	)
+}
+
+func (sidecar *CloudSidecar) ListArtifacts(
+	ctx context.Context,
+	patterns []string,
+) ([]string, error) {
+	return listContainerArtifacts(
+		ctx, sidecar.executor, sidecar.container, sidecar.gitDir, patterns,
+	)
+}
+
+// Archive runs tar in the sidecar container and streams its output to the
+// writer, the command is canceled if the writer fails.
+func (sidecar *CloudSidecar) Archive(
+	ctx context.Context,
+	paths []string,
+	writer io.Writer,
+) error {
+	return archiveContainerFiles(
+		ctx, sidecar.executor, sidecar.container, sidecar.gitDir, paths, writer,
+	)
+}
+
+// Extract runs tar in the sidecar container which reads the archive from
+// stdin.
+func (sidecar *CloudSidecar) Extract(ctx context.Context, reader io.Reader) error {
+	return extractContainerFiles(
+		ctx,
+		sidecar.executor,
+		sidecar.container,
+		sidecar.gitDir,
+		reader,
+		sidecar.getLogger("tar"),
+	)
+}
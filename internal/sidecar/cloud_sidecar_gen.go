@@ -16,6 +16,7 @@ type CloudSidecarBuilder struct {
 	outputConsumer executor.OutputConsumer
 	sshKey         sshkey.Key
 	volumes        []executor.Volume
+	network        executor.Network
 }
 
 func NewCloudSidecarBuilder() *CloudSidecarBuilder {
@@ -62,6 +63,11 @@ func (b *CloudSidecarBuilder) Volumes(volumes []executor.Volume) *CloudSidecarBu
 	return b
 }
 
+func (b *CloudSidecarBuilder) Network(network executor.Network) *CloudSidecarBuilder {
+	b.network = network
+	return b
+}
+
 func (b *CloudSidecarBuilder) Build() *CloudSidecar {
 	return &CloudSidecar{
 		executor:       b.executor,
@@ -72,5 +78,6 @@ func (b *CloudSidecarBuilder) Build() *CloudSidecar {
 		outputConsumer: b.outputConsumer,
 		sshKey:         b.sshKey,
 		volumes:        b.volumes,
+		network:        b.network,
 	}
 }
@@ -0,0 +1,123 @@
+package sidecar
+
+import (
+	"context"
+	"io"
+	"strings"
+
+	"github.com/reconquest/karma-go"
+	"github.com/reconquest/snake-runner/internal/executor"
+)
+
+// listContainerArtifacts runs find in the git dir of the container and
+// returns paths which match any of the given globs.
+func listContainerArtifacts(
+	ctx context.Context,
+	runner executor.Executor,
+	container executor.Container,
+	gitDir string,
+	patterns []string,
+) ([]string, error) {
+	entries := []artifactEntry{}
+
+	for _, dir := range []bool{true, false} {
+		cmd := []string{"find", ".", "!", "-type", "d"}
+		if dir {
+			cmd = []string{"find", ".", "-type", "d"}
+		}
+
+		var output strings.Builder
+
+		err := runner.Exec(ctx, container, executor.ExecOptions{
+			Cmd:          cmd,
+			WorkingDir:   gitDir,
+			AttachStdout: true,
+			OutputConsumer: func(text string) {
+				output.WriteString(text)
+			},
+		})
+		if err != nil {
+			return nil, karma.
+				Describe("cmd", cmd).
+				Format(err, "unable to list files")
+		}
+
+		entries = append(entries, parseArtifactEntries(output.String(), dir)...)
+	}
+
+	return matchArtifacts(entries, patterns), nil
+}
+
+// archiveContainerFiles runs tar in the container and streams its output to
+// the writer, the command is canceled if the writer fails.
+func archiveContainerFiles(
+	ctx context.Context,
+	runner executor.Executor,
+	container executor.Container,
+	gitDir string,
+	paths []string,
+	writer io.Writer,
+) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var writeErr error
+
+	cmd := append([]string{"tar", "-czf", "-", "--"}, paths...)
+
+	err := runner.Exec(ctx, container, executor.ExecOptions{
+		Cmd:          cmd,
+		WorkingDir:   gitDir,
+		AttachStdout: true,
+		OutputConsumer: func(text string) {
+			if writeErr != nil {
+				return
+			}
+
+			_, writeErr = writer.Write([]byte(text))
+			if writeErr != nil {
+				cancel()
+			}
+		},
+	})
+	if writeErr != nil {
+		return writeErr
+	}
+
+	if err != nil {
+		return karma.
+			Describe("cmd", cmd).
+			Format(err, "unable to archive files")
+	}
+
+	return nil
+}
+
+// extractContainerFiles runs tar in the container which reads the archive
+// from stdin.
+func extractContainerFiles(
+	ctx context.Context,
+	runner executor.Executor,
+	container executor.Container,
+	gitDir string,
+	reader io.Reader,
+	logger executor.OutputConsumer,
+) error {
+	cmd := []string{"tar", "-xzf", "-"}
+
+	err := runner.Exec(ctx, container, executor.ExecOptions{
+		Cmd:            cmd,
+		WorkingDir:     gitDir,
+		AttachStdout:   true,
+		AttachStderr:   true,
+		OutputConsumer: logger,
+		Stdin:          reader,
+	})
+	if err != nil {
+		return karma.
+			Describe("cmd", cmd).
+			Format(err, "unable to extract files")
+	}
+
+	return nil
+}
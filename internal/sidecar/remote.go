@@ -0,0 +1,94 @@
+package sidecar
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"path"
+	"strings"
+
+	"github.com/reconquest/karma-go"
+	"github.com/reconquest/snake-runner/internal/executor"
+)
+
+// remoteFileReader reads files of other repositories, only the requested
+// commit is fetched into a separate repository in the given dir.
+type remoteFileReader struct {
+	executor       executor.Executor
+	container      executor.Container
+	env            []string
+	dir            string
+	promptConsumer executor.PromptConsumer
+	outputConsumer executor.OutputConsumer
+	logger         executor.OutputConsumer
+}
+
+func (reader remoteFileReader) read(
+	ctx context.Context,
+	url, ref, filename string,
+) (string, error) {
+	if ref == "" {
+		ref = "HEAD"
+	}
+
+	hash := sha1.Sum([]byte(url))
+	gitDir := reader.dir + "/" + hex.EncodeToString(hash[:])[:12]
+
+	steps := []struct {
+		prompt bool
+		cmd    []string
+	}{
+		{
+			prompt: false,
+			cmd:    []string{"git", "init", "-q", gitDir},
+		},
+		{
+			prompt: true,
+			cmd:    []string{"git", "-C", gitDir, "fetch", "--depth=1", url, ref},
+		},
+	}
+
+	for _, step := range steps {
+		consumer := reader.logger
+		if step.prompt {
+			reader.promptConsumer(step.cmd)
+			consumer = reader.outputConsumer
+		}
+
+		err := reader.executor.Exec(ctx, reader.container, executor.ExecOptions{
+			Env:            reader.env,
+			Cmd:            step.cmd,
+			AttachStdout:   true,
+			AttachStderr:   true,
+			OutputConsumer: consumer,
+		})
+		if err != nil {
+			return "", karma.
+				Describe("cmd", step.cmd).
+				Format(err, "unable to fetch %s from %s", ref, url)
+		}
+	}
+
+	var output strings.Builder
+
+	cmd := []string{
+		"git", "-C", gitDir, "show",
+		"FETCH_HEAD:" + strings.TrimPrefix(path.Clean(filename), "/"),
+	}
+
+	err := reader.executor.Exec(ctx, reader.container, executor.ExecOptions{
+		Env:          reader.env,
+		Cmd:          cmd,
+		AttachStdout: true,
+		OutputConsumer: func(text string) {
+			output.WriteString(text)
+		},
+	})
+	if err != nil {
+		return "", karma.
+			Describe("cmd", cmd).
+			Format(err, "unable to read file %s at %s of %s", filename, ref, url)
+	}
+
+	return output.String(), nil
+}
@@ -62,7 +62,15 @@ func (sidecar *ShellSidecar) Serve(
 		return karma.Format(err, "unable to obtain temporary directory")
 	}
 
-	err = os.MkdirAll(sidecar.gitDir, 0o755)
+	err = sidecar.chown(sidecar.tempDir)
+	if err != nil {
+		return karma.Format(
+			err,
+			"unable to change owner of temporary directory: %s", sidecar.tempDir,
+		)
+	}
+
+	err = sidecar.mkdirAll(sidecar.gitDir)
 	if err != nil {
 		return karma.Format(
 			err,
@@ -111,13 +119,7 @@ func (sidecar *ShellSidecar) Serve(
 		)
 	}
 
-	env := append(os.Environ(), []string{
-		consts.SSH_AUTH_SOCK_VAR + "=" + sidecar.sshSocket,
-		consts.GIT_SSH_COMMAND_VAR + "=" + "ssh -o" + consts.SSH_OPTION_GLOBAL_HOSTS_FILE + "=" + sidecar.sshKnownHosts,
-
-		// NOTE: the private key is not passed anymore but it's already
-		// in ssh-agent's memory
-	}...)
+	env := sidecar.getGitEnv()
 
 	var gitCloneArgs []string
 
@@ -184,6 +186,37 @@ func (sidecar *ShellSidecar) Serve(
 	return nil
 }
 
+// getGitEnv returns variables of git commands, basic variables such as PATH
+// are passed by the shell executor.
+func (sidecar *ShellSidecar) getGitEnv() []string {
+	return []string{
+		consts.SSH_AUTH_SOCK_VAR + "=" + sidecar.sshSocket,
+		consts.GIT_SSH_COMMAND_VAR + "=" + "ssh -o" + consts.SSH_OPTION_GLOBAL_HOSTS_FILE + "=" + sidecar.sshKnownHosts,
+
+		// NOTE: the private key is not passed anymore but it's already
+		// in ssh-agent's memory
+	}
+}
+
+// mkdirAll creates the directory which is writable by commands, they may be
+// run by another user than the runner.
+func (sidecar *ShellSidecar) mkdirAll(dir string) error {
+	if owner, ok := sidecar.executor.(*shell.Shell); ok {
+		return owner.MkdirAll(dir)
+	}
+
+	return os.MkdirAll(dir, 0o755)
+}
+
+// chown passes the path to the user of commands.
+func (sidecar *ShellSidecar) chown(path string) error {
+	if owner, ok := sidecar.executor.(*shell.Shell); ok {
+		return owner.Chown(path)
+	}
+
+	return nil
+}
+
 func (sidecar *ShellSidecar) Destroy() {
 	if sidecar.container != nil {
 		err := sidecar.executor.Destroy(context.Background(), sidecar.container)
@@ -231,6 +264,92 @@ func (sidecar *ShellSidecar) ReadFile(
 	return string(contents), nil
 }
 
+func (sidecar *ShellSidecar) ReadRemoteFile(
+	ctx context.Context,
+	url, ref, path string,
+) (string, error) {
+	reader := remoteFileReader{
+		executor:       sidecar.executor,
+		container:      sidecar.container,
+		env:            sidecar.getGitEnv(),
+		dir:            filepath.ToSlash(filepath.Join(sidecar.baseDir, consts.SUBDIR_REMOTE)),
+		promptConsumer: sidecar.promptConsumer,
+		outputConsumer: sidecar.outputConsumer,
+		logger:         sidecar.getLogger("git"),
+	}
+
+	return reader.read(ctx, url, ref, path)
+}
+
+func (sidecar *ShellSidecar) ListChanges(
+	ctx context.Context,
+	from, to string,
+) ([]string, error) {
+	return listChanges(
+		ctx, sidecar.executor, sidecar.container, sidecar.gitDir, from, to,
+	)
+}
+
+func (sidecar *ShellSidecar) ListArtifacts(
+	ctx context.Context,
+	patterns []string,
+) ([]string, error) {
+	entries := []artifactEntry{}
+
+	err := filepath.Walk(
+		sidecar.gitDir,
+		func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+
+			relative, err := filepath.Rel(sidecar.gitDir, path)
+			if err != nil {
+				return err
+			}
+
+			if relative == "." {
+				return nil
+			}
+
+			if relative == ".git" && info.IsDir() {
+				return filepath.SkipDir
+			}
+
+			entries = append(entries, artifactEntry{
+				path: filepath.ToSlash(relative),
+				dir:  info.IsDir(),
+			})
+
+			return nil
+		},
+	)
+	if err != nil {
+		return nil, karma.Format(err, "unable to list files")
+	}
+
+	return matchArtifacts(entries, patterns), nil
+}
+
+func (sidecar *ShellSidecar) Archive(
+	ctx context.Context,
+	paths []string,
+	writer io.Writer,
+) error {
+	return writeArchive(sidecar.gitDir, paths, writer)
+}
+
+// Extract unpacks the archive and passes extracted files to the user of
+// commands.
+func (sidecar *ShellSidecar) Extract(ctx context.Context, reader io.Reader) error {
+	err := extractArchive(sidecar.gitDir, reader)
+	if err != nil {
+		return err
+	}
+
+	return sidecar.chown(sidecar.gitDir)
+}
+
 func (sidecar *ShellSidecar) ContainerVolumes() []executor.Volume {
 	return nil
 }
@@ -2,6 +2,7 @@ package sidecar
 
 import (
 	"context"
+	"io"
 
 	"github.com/reconquest/snake-runner/internal/env"
 	"github.com/reconquest/snake-runner/internal/executor"
@@ -19,6 +20,24 @@ type Sidecar interface {
 	ContainerVolumes() []executor.Volume
 
 	ReadFile(context context.Context, cwd, path string) (string, error)
+
+	// ReadRemoteFile reads the file of another repository at the given ref
+	// using ssh-agent of the sidecar, the empty ref stands for the default
+	// branch.
+	ReadRemoteFile(context context.Context, url, ref, path string) (string, error)
+
+	// ListChanges returns paths of files changed between the given commits.
+	ListChanges(context context.Context, from, to string) ([]string, error)
+
+	// ListArtifacts returns paths in the git dir which match any of the given
+	// globs.
+	ListArtifacts(context context.Context, patterns []string) ([]string, error)
+
+	// Archive writes a gzipped tar archive of the given paths of the git dir.
+	Archive(context context.Context, paths []string, writer io.Writer) error
+
+	// Extract unpacks a gzipped tar archive into the git dir.
+	Extract(context context.Context, reader io.Reader) error
 }
 
 type ServeOptions struct {
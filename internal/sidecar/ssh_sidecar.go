@@ -0,0 +1,397 @@
+package sidecar
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/reconquest/karma-go"
+	"github.com/reconquest/pkg/log"
+	"github.com/reconquest/snake-runner/internal/audit"
+	"github.com/reconquest/snake-runner/internal/consts"
+	"github.com/reconquest/snake-runner/internal/executor"
+	"github.com/reconquest/snake-runner/internal/sshkey"
+	"github.com/reconquest/snake-runner/internal/utils"
+)
+
+//go:generate gonstructor -type SshSidecar -constructorTypes builder
+
+var _ Sidecar = (*SshSidecar)(nil)
+
+// SshSidecar clones the repository on the remote build host. The private key
+// is never copied to the host, it's served by the runner through ssh-agent
+// forwarding of a session which lives until the sidecar is destroyed.
+type SshSidecar struct {
+	executor       executor.Executor
+	name           string
+	slug           string
+	promptConsumer executor.PromptConsumer
+	outputConsumer executor.OutputConsumer
+	sshKey         sshkey.Key
+
+	container executor.Container `gonstructor:"-"`
+
+	// baseDir is the workspace of the sidecar on the build host
+	baseDir string `gonstructor:"-"`
+	gitDir  string `gonstructor:"-"`
+	sshDir  string `gonstructor:"-"`
+
+	sshSocket     string `gonstructor:"-"`
+	sshKnownHosts string `gonstructor:"-"`
+
+	// sshAgent is the session with the forwarded ssh-agent, it's stopped
+	// when sshAgentStdin is closed
+	sshAgent      *sync.WaitGroup `gonstructor:"-"`
+	sshAgentStdin io.Closer       `gonstructor:"-"`
+}
+
+func (sidecar *SshSidecar) Serve(
+	ctx context.Context,
+	opts ServeOptions,
+) error {
+	var err error
+
+	sidecar.container, err = sidecar.executor.Create(
+		ctx,
+		executor.CreateOptions{
+			Name:   "snake-runner-sidecar-" + sidecar.name,
+			SshKey: &sidecar.sshKey,
+		},
+	)
+	if err != nil {
+		return karma.Format(err, "unable to create sidecar")
+	}
+
+	// the workspace of the container is the default working dir
+	var output strings.Builder
+	err = sidecar.executor.Exec(ctx, sidecar.container, executor.ExecOptions{
+		Cmd:          []string{"pwd"},
+		AttachStdout: true,
+		OutputConsumer: func(text string) {
+			output.WriteString(text)
+		},
+	})
+	if err != nil {
+		return karma.Format(err, "unable to obtain sidecar workspace")
+	}
+
+	sidecar.baseDir = strings.TrimSpace(output.String())
+	sidecar.gitDir = path.Join(sidecar.baseDir, consts.SUBDIR_GIT, sidecar.slug)
+	sidecar.sshDir = path.Join(sidecar.baseDir, consts.SUBDIR_SSH)
+	sidecar.sshKnownHosts = path.Join(sidecar.sshDir, "known_hosts")
+
+	err = sidecar.executor.Exec(ctx, sidecar.container, executor.ExecOptions{
+		Cmd:            []string{"mkdir", "-p", sidecar.gitDir, sidecar.sshDir},
+		AttachStdout:   true,
+		AttachStderr:   true,
+		OutputConsumer: sidecar.getLogger("mkdir"),
+	})
+	if err != nil {
+		return karma.Format(
+			err,
+			"unable to create directories: %s %s",
+			sidecar.gitDir,
+			sidecar.sshDir,
+		)
+	}
+
+	err = sidecar.startSshAgent(ctx)
+	if err != nil {
+		return karma.Format(err, "unable to forward ssh-agent")
+	}
+
+	err = sidecar.executor.Exec(ctx, sidecar.container, executor.ExecOptions{
+		Cmd:            []string{"sh", "-c", `cat > "$0"`, sidecar.sshKnownHosts},
+		Stdin:          strings.NewReader(joinKnownHosts(opts.KnownHosts)),
+		AttachStderr:   true,
+		OutputConsumer: sidecar.getLogger("known_hosts"),
+	})
+	if err != nil {
+		return karma.Format(err, "unable to save known hosts file")
+	}
+
+	steps := []struct {
+		prompt bool
+		cmd    []string
+	}{
+		{
+			prompt: true,
+			cmd:    []string{"git", "clone", "--recursive", opts.CloneURL, sidecar.gitDir},
+		},
+		{
+			prompt: false,
+			cmd:    []string{"git", "-C", sidecar.gitDir, "config", "advice.detachedHead", "false"},
+		},
+		{
+			prompt: true,
+			cmd:    []string{"git", "-C", sidecar.gitDir, "checkout", opts.Commit},
+		},
+	}
+
+	for _, step := range steps {
+		if step.prompt {
+			sidecar.promptConsumer(step.cmd)
+		}
+
+		err = sidecar.executor.Exec(ctx, sidecar.container, executor.ExecOptions{
+			Env:            sidecar.getGitEnv(),
+			Cmd:            step.cmd,
+			AttachStdout:   true,
+			AttachStderr:   true,
+			OutputConsumer: sidecar.outputConsumer,
+		})
+		if err != nil {
+			return karma.
+				Describe("cmd", fmt.Sprintf("%q", step.cmd)).
+				Format(err, "unable to setup repository")
+		}
+	}
+
+	return nil
+}
+
+// startSshAgent starts the session which links its forwarded ssh-agent
+// socket to the ssh dir, so jobs on the build host can use the agent as well.
+// The session waits for stdin to be closed.
+func (sidecar *SshSidecar) startSshAgent(ctx context.Context) error {
+	logger := sidecar.getLogger("ssh-agent")
+
+	chError := make(chan error, 1)
+	chStarted := make(chan struct{}, 1)
+
+	callback := func(text string) {
+		logger(text)
+
+		if strings.Contains(text, consts.SSH_AUTH_SOCK_VAR+"=") {
+			chStarted <- struct{}{}
+		}
+	}
+
+	socket := path.Join(sidecar.sshDir, consts.SSH_SOCKET_FILENAME)
+
+	stdin, stdinWriter := io.Pipe()
+
+	sidecar.sshAgentStdin = stdinWriter
+	sidecar.sshAgent = &sync.WaitGroup{}
+	sidecar.sshAgent.Add(1)
+
+	go func() {
+		defer audit.Go("sidecar", "ssh-agent")()
+
+		defer sidecar.sshAgent.Done()
+
+		cmd := []string{
+			"sh", "-c",
+			`test -n "$SSH_AUTH_SOCK" || exit 1;` +
+				` ln -sf "$SSH_AUTH_SOCK" "$0" &&` +
+				` echo ` + consts.SSH_AUTH_SOCK_VAR + `="$0" &&` +
+				` exec cat > /dev/null`,
+			socket,
+		}
+
+		err := sidecar.executor.Exec(ctx, sidecar.container, executor.ExecOptions{
+			Cmd:            cmd,
+			Stdin:          stdin,
+			AttachStdout:   true,
+			AttachStderr:   true,
+			OutputConsumer: callback,
+		})
+		if err != nil {
+			if utils.IsCanceled(err) {
+				return
+			}
+
+			chError <- karma.Format(
+				err,
+				"unable to link forwarded ssh-agent socket, "+
+					"make sure that AllowAgentForwarding is enabled on the build host",
+			)
+			return
+		}
+
+		chError <- karma.Describe("cmd", cmd).Format(
+			"the session has stopped and did not output the path to socket",
+			"unable to forward ssh-agent",
+		)
+	}()
+
+	select {
+	case <-ctx.Done():
+		return context.Canceled
+
+	case <-chStarted:
+		sidecar.sshSocket = socket
+		return nil
+
+	case err := <-chError:
+		return err
+	}
+}
+
+func (sidecar *SshSidecar) getGitEnv() []string {
+	return []string{
+		consts.SSH_AUTH_SOCK_VAR + "=" + sidecar.sshSocket,
+		consts.GIT_SSH_COMMAND_VAR + "=" + "ssh -o" + consts.SSH_OPTION_GLOBAL_HOSTS_FILE + "=" + sidecar.sshKnownHosts,
+	}
+}
+
+func (sidecar *SshSidecar) Destroy() {
+	if sidecar.container == nil {
+		return
+	}
+
+	if sidecar.sshAgentStdin != nil {
+		sidecar.sshAgentStdin.Close()
+	}
+
+	log.Debugf(
+		nil,
+		"destroying sidecar %s container",
+		sidecar.container.String(),
+	)
+
+	// the workspace with git and ssh directories is removed with the
+	// container
+	err := sidecar.executor.Destroy(context.Background(), sidecar.container)
+	if err != nil {
+		log.Errorf(
+			err,
+			"unable to destroy sidecar container %s",
+			sidecar.container.String(),
+		)
+	}
+
+	if sidecar.sshAgent != nil {
+		sidecar.sshAgent.Wait()
+	}
+}
+
+func (sidecar *SshSidecar) GitDir() string {
+	return sidecar.gitDir
+}
+
+func (sidecar *SshSidecar) SshSocketPath() string {
+	return sidecar.sshSocket
+}
+
+func (sidecar *SshSidecar) SshKnownHostsPath() string {
+	return sidecar.sshKnownHosts
+}
+
+func (sidecar *SshSidecar) ContainerVolumes() []executor.Volume {
+	return nil
+}
+
+func (sidecar *SshSidecar) ReadFile(
+	ctx context.Context,
+	cwd, path string,
+) (string, error) {
+	var output strings.Builder
+
+	err := sidecar.executor.Exec(ctx, sidecar.container, executor.ExecOptions{
+		Cmd:          []string{"cat", path},
+		WorkingDir:   cwd,
+		AttachStdout: true,
+		OutputConsumer: func(text string) {
+			output.WriteString(text)
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return output.String(), nil
+}
+
+func (sidecar *SshSidecar) ReadRemoteFile(
+	ctx context.Context,
+	url, ref, filename string,
+) (string, error) {
+	reader := remoteFileReader{
+		executor:       sidecar.executor,
+		container:      sidecar.container,
+		env:            sidecar.getGitEnv(),
+		dir:            path.Join(sidecar.baseDir, consts.SUBDIR_REMOTE),
+		promptConsumer: sidecar.promptConsumer,
+		outputConsumer: sidecar.outputConsumer,
+		logger:         sidecar.getLogger("git"),
+	}
+
+	return reader.read(ctx, url, ref, filename)
+}
+
+func (sidecar *SshSidecar) ListChanges(
+	ctx context.Context,
+	from, to string,
+) ([]string, error) {
+	return listChanges(
+		ctx, sidecar.executor, sidecar.container, sidecar.gitDir, from, to,
+	)
+}
+
+func (sidecar *SshSidecar) ListArtifacts(
+	ctx context.Context,
+	patterns []string,
+) ([]string, error) {
+	return listContainerArtifacts(
+		ctx, sidecar.executor, sidecar.container, sidecar.gitDir, patterns,
+	)
+}
+
+func (sidecar *SshSidecar) Archive(
+	ctx context.Context,
+	paths []string,
+	writer io.Writer,
+) error {
+	return archiveContainerFiles(
+		ctx, sidecar.executor, sidecar.container, sidecar.gitDir, paths, writer,
+	)
+}
+
+func (sidecar *SshSidecar) Extract(ctx context.Context, reader io.Reader) error {
+	return extractContainerFiles(
+		ctx,
+		sidecar.executor,
+		sidecar.container,
+		sidecar.gitDir,
+		reader,
+		sidecar.getLogger("tar"),
+	)
+}
+
+// CheckPrerequisites checks that binaries required by the sidecar are
+// installed on build hosts.
+func (sidecar *SshSidecar) CheckPrerequisites(ctx context.Context) error {
+	var errs karma.Reason
+
+	for _, dep := range []string{"git", "ssh", "tar", "find", "setsid"} {
+		_, err := sidecar.executor.LookPath(ctx, dep)
+		if err != nil {
+			if errs == nil {
+				errs = errors.New("unable to locate required dependencies")
+			}
+
+			errs = karma.Push(errs, err)
+		}
+	}
+
+	if errs != nil {
+		return karma.Format(errs, "build hosts don't meet prerequisites")
+	}
+
+	return nil
+}
+
+func (sidecar *SshSidecar) getLogger(tag string) func(string) {
+	return func(text string) {
+		log.Debugf(
+			nil,
+			"[sidecar] %s {%s}: %s",
+			sidecar.name, tag, strings.TrimRight(text, "\n"),
+		)
+	}
+}
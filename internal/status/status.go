@@ -3,14 +3,15 @@ package status
 type Status string
 
 const (
-	PENDING  = Status("PENDING")
-	QUEUED   = Status("QUEUED")
-	RUNNING  = Status("RUNNING")
-	SUCCESS  = Status("SUCCESS")
-	FAILED   = Status("FAILED")
-	CANCELED = Status("CANCELED")
-	SKIPPED  = Status("SKIPPED")
-	UNKNOWN  = Status("UNKNOWN")
+	PENDING   = Status("PENDING")
+	QUEUED    = Status("QUEUED")
+	RUNNING   = Status("RUNNING")
+	SUCCESS   = Status("SUCCESS")
+	FAILED    = Status("FAILED")
+	CANCELED  = Status("CANCELED")
+	SKIPPED   = Status("SKIPPED")
+	TIMED_OUT = Status("TIMED_OUT")
+	UNKNOWN   = Status("UNKNOWN")
 )
 
 // unused for now
@@ -18,5 +19,6 @@ func isFinalStatus(status Status) bool {
 	return status == SUCCESS ||
 		status == FAILED ||
 		status == CANCELED ||
-		status == SKIPPED
+		status == SKIPPED ||
+		status == TIMED_OUT
 }
@@ -3,6 +3,7 @@ package tasks
 import (
 	"encoding/json"
 	"fmt"
+	"strings"
 
 	"github.com/reconquest/pkg/log"
 	"github.com/reconquest/snake-runner/internal/responses"
@@ -57,6 +58,33 @@ type PipelineRun struct {
 	CloneURL    CloneURL               `json:"clone_url"`
 }
 
+// GetRepositoryCloneURL returns the clone URL of another repository of the
+// same Bitbucket instance, the repository is specified as PROJECT_KEY/slug.
+// The URL is derived from the clone URL of the pipeline repository.
+func (task PipelineRun) GetRepositoryCloneURL(repository string) (string, error) {
+	url := task.CloneURL.GetPreferredURL()
+
+	parts := strings.Split(repository, "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", fmt.Errorf(
+			"repository must be specified as PROJECT_KEY/slug but got %q",
+			repository,
+		)
+	}
+
+	suffix := "/" + task.Project.Key + "/" + task.Repository.Slug + ".git"
+	if len(url) < len(suffix) ||
+		!strings.EqualFold(url[len(url)-len(suffix):], suffix) {
+		return "", fmt.Errorf(
+			"unable to get clone url of %s from clone url %q",
+			repository, url,
+		)
+	}
+
+	return url[:len(url)-len(suffix)] +
+		"/" + strings.ToLower(parts[0]) + "/" + parts[1] + ".git", nil
+}
+
 type PipelineCancel struct {
 	Pipelines []int `json:"pipelines"`
 }
@@ -3,6 +3,7 @@ package tasks
 import (
 	"testing"
 
+	"github.com/reconquest/snake-runner/internal/responses"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -64,3 +65,34 @@ func TestCloneURL_GetPreferredURL_ReturnsSSHIfMethodIsUnknown(t *testing.T) {
 
 	test.Equal("ssh://url", url.GetPreferredURL())
 }
+
+func TestPipelineRun_GetRepositoryCloneURL(t *testing.T) {
+	test := assert.New(t)
+
+	task := PipelineRun{
+		Project:    responses.Project{Key: "PROJ"},
+		Repository: responses.Repository{Slug: "repo"},
+		CloneURL: CloneURL{
+			SSH:  "ssh://git@bitbucket:7999/proj/repo.git",
+			HTTP: "https://bitbucket/scm/proj/repo.git",
+		},
+	}
+
+	url, err := task.GetRepositoryCloneURL("CI/templates")
+	test.NoError(err)
+	test.Equal("ssh://git@bitbucket:7999/ci/templates.git", url)
+
+	task.CloneURL.Method = CloneMethodHTTP
+
+	url, err = task.GetRepositoryCloneURL("CI/templates")
+	test.NoError(err)
+	test.Equal("https://bitbucket/scm/ci/templates.git", url)
+
+	task.CloneURL.HTTP = "https://bitbucket/unknown.git"
+
+	_, err = task.GetRepositoryCloneURL("CI/templates")
+	test.Error(err)
+
+	_, err = task.GetRepositoryCloneURL("templates")
+	test.Error(err)
+}